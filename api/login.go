@@ -32,6 +32,6 @@ func ApiMe(c *gin.Context) {
 		"id":       user.ID.Hex(),
 		"username": claims.Username,
 		"email":    user.Email,
-		"avatar":   user.Avatar,
+		"avatar":   auth.AvatarURL(user),
 	})
 }
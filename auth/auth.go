@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -25,6 +26,41 @@ var (
 	cfgOnce sync.Once
 )
 
+// GuestPrefix préfixe les pseudonymes attribués par le serveur aux invités.
+// Il est réservé: aucun compte ne peut s'inscrire sous un nom qui le porte.
+const GuestPrefix = "Invité-"
+
+// AvatarKeyPrefix préfixe les clés de blob des avatars téléversés; toute autre valeur
+// de User.Avatar (anciennes chaînes libres fournies à l'inscription) est ignorée.
+const AvatarKeyPrefix = "avatars/"
+
+// AvatarURL renvoie l'URL de l'avatar du compte: l'image téléversée, versionnée pour
+// les caches, sinon l'avatar généré à partir de l'id.
+func AvatarURL(u models.User) string {
+	if u.ID.IsZero() {
+		return ""
+	}
+	if strings.HasPrefix(u.Avatar, AvatarKeyPrefix) {
+		return DefaultAvatarURL(u.ID.Hex()) + "?v=" + strconv.FormatInt(u.AvatarVersion, 10)
+	}
+	return DefaultAvatarURL(u.ID.Hex())
+}
+
+// DefaultAvatarURL: URL stable d'un avatar à partir d'un id de compte (ou du pseudonyme d'un invité).
+func DefaultAvatarURL(seed string) string {
+	return "/api/avatars/" + url.PathEscape(seed)
+}
+
+// ReservedUsername indique un nom interdit à l'inscription (invités, messages serveur).
+func ReservedUsername(name string) bool {
+	n := strings.ToLower(strings.TrimSpace(name))
+	switch n {
+	case "invité", "invite", "serveur":
+		return true
+	}
+	return strings.HasPrefix(n, "invité-") || strings.HasPrefix(n, "invite-")
+}
+
 type config struct {
 	jwtKey          []byte
 	cookieDomain    string
@@ -95,8 +131,8 @@ type LoginRequest struct {
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
-	Avatar   string `json:"avatar"`
-	Email    string `json:"email"` // optionnel mais recommandé
+	Avatar   string `json:"avatar"` // ignoré: l'avatar se téléverse (POST /api/me/avatar)
+	Email    string `json:"email"`  // optionnel mais recommandé
 }
 
 func generateJWT(userID, username, tokenType string, ttl time.Duration) (string, error) {
@@ -229,7 +265,7 @@ func LoginHandler(c *gin.Context) {
 		"id":       uid,
 		"username": user.Username,
 		"email":    user.Email,
-		"avatar":   user.Avatar,
+		"avatar":   AvatarURL(user),
 	})
 }
 
@@ -251,6 +287,11 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	if ReservedUsername(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nom réservé"})
+		return
+	}
+
 	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
 
 	// Unicité
@@ -275,11 +316,11 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	// L'avatar se téléverse après l'inscription (POST /api/me/avatar): req.Avatar n'est plus repris.
 	newUser := models.User{
 		Username: req.Username,
 		Email:    req.Email,
 		Password: string(hashed),
-		Avatar:   req.Avatar,
 	}
 	result, err := db.UsersCol.InsertOne(c, newUser)
 	if err != nil {
@@ -289,6 +330,7 @@ func RegisterHandler(c *gin.Context) {
 
 	oid, _ := result.InsertedID.(primitive.ObjectID)
 	uid := oid.Hex()
+	newUser.ID = oid
 
 	access, err := generateJWT(uid, newUser.Username, "access", cfg.accessTokenTTL)
 	if err != nil {
@@ -306,7 +348,7 @@ func RegisterHandler(c *gin.Context) {
 		"id":       uid,
 		"username": newUser.Username,
 		"email":    newUser.Email,
-		"avatar":   newUser.Avatar,
+		"avatar":   AvatarURL(newUser),
 	})
 }
 
@@ -390,6 +432,6 @@ func MeHandler(c *gin.Context) {
 		"id":       user.ID.Hex(),
 		"username": user.Username,
 		"email":    user.Email,
-		"avatar":   user.Avatar,
+		"avatar":   AvatarURL(user),
 	})
 }
@@ -0,0 +1,613 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pièces jointes. Le fichier est téléversé seul (POST /api/attachments), puis
+// référencé par id dans un message; il est alors rattaché au message et au salon,
+// dont il suit les droits de lecture. Un fichier jamais rattaché, ou dont le
+// message a été supprimé, est collecté par collectAttachments.
+type attachmentConfig struct {
+	maxBytes  int64
+	maxPixels int64           // au-delà, une image est refusée avant décodage (bombe de décompression)
+	allowed   map[string]bool // types détectés acceptés (sans paramètres)
+	orphanTTL time.Duration
+	urlTTL    time.Duration // validité des URL signées
+	secret    []byte        // vide: pas d'URL signées
+}
+
+const (
+	maxMessageAttachments = 10
+	maxPendingUploads     = 20 // fichiers non rattachés par utilisateur
+	maxFilenameLen        = 200
+	attachmentGCInterval  = 10 * time.Minute
+	attachmentGCLock      = "attachments:gc"
+	attachmentGCBatch     = 500
+)
+
+// Types acceptés par défaut: pas de HTML ni de SVG, qui s'exécuteraient dans le navigateur.
+var defaultUploadTypes = "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain," +
+	"application/zip,audio/mpeg,audio/ogg,video/mp4,video/webm"
+
+var (
+	attachCfg     attachmentConfig
+	attachCfgOnce sync.Once
+	gcOnce        sync.Once
+)
+
+// attachmentLimits lit MAX_UPLOAD_BYTES, MAX_IMAGE_PIXELS, UPLOAD_MIME_TYPES, ATTACHMENT_ORPHAN_TTL,
+// ATTACHMENT_URL_TTL et ATTACHMENT_URL_SECRET (à défaut, clé dérivée de JWT_SECRET).
+func attachmentLimits() attachmentConfig {
+	attachCfgOnce.Do(func() {
+		attachCfg = attachmentConfig{
+			maxBytes:  int64(envFloat("MAX_UPLOAD_BYTES", 10<<20)),
+			maxPixels: int64(envFloat("MAX_IMAGE_PIXELS", 25e6)),
+			allowed:   map[string]bool{},
+			orphanTTL: time.Hour,
+			urlTTL:    15 * time.Minute,
+		}
+		types := os.Getenv("UPLOAD_MIME_TYPES")
+		if types == "" {
+			types = defaultUploadTypes
+		}
+		for _, t := range splitList(types, ",") {
+			attachCfg.allowed[strings.ToLower(t)] = true
+		}
+		if d, err := time.ParseDuration(os.Getenv("ATTACHMENT_ORPHAN_TTL")); err == nil && d > 0 {
+			attachCfg.orphanTTL = d
+		}
+		if d, err := time.ParseDuration(os.Getenv("ATTACHMENT_URL_TTL")); err == nil && d > 0 {
+			attachCfg.urlTTL = d
+		}
+		attachCfg.secret = []byte(strings.TrimSpace(os.Getenv("ATTACHMENT_URL_SECRET")))
+		if len(attachCfg.secret) == 0 {
+			// Clé dérivée: une signature d'URL ne vaut jamais signature de JWT.
+			if jwt := strings.TrimSpace(os.Getenv("JWT_SECRET")); jwt != "" {
+				attachCfg.secret = deriveKey([]byte(jwt), "attachment-url")
+			}
+		}
+	})
+	return attachCfg
+}
+
+// baseType retire les paramètres ("text/plain; charset=utf-8" -> "text/plain").
+func baseType(t string) string {
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// cleanFilename garde le nom de base, sans caractères de contrôle ni chemin.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		name = "fichier"
+	}
+	if r := []rune(name); len(r) > maxFilenameLen {
+		name = string(r[len(r)-maxFilenameLen:])
+	}
+	return name
+}
+
+func attachmentURL(id string) string {
+	return "/api/attachments/" + id
+}
+
+// attachmentRefs: pièces jointes d'un message avec leur URL, rien si le message est masqué ou supprimé.
+func attachmentRefs(m models.Message) []models.AttachmentRef {
+	if len(m.Attachments) == 0 || m.Deleted || m.Hidden {
+		return nil
+	}
+	out := make([]models.AttachmentRef, len(m.Attachments))
+	for i, a := range m.Attachments {
+		a.URL = attachmentURL(a.ID)
+		if len(a.Thumbnails) > 0 {
+			thumbs := make([]models.Thumbnail, len(a.Thumbnails))
+			for j, t := range a.Thumbnails {
+				t.URL = thumbnailURL(a.ID, t.Size)
+				thumbs[j] = t
+			}
+			a.Thumbnails = thumbs
+		}
+		out[i] = a
+	}
+	return out
+}
+
+// pendingAttachments vérifie que les ids désignent des fichiers de user encore libres.
+func pendingAttachments(ctx context.Context, user WSUser, ids []string) ([]models.AttachmentRef, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if !user.Authenticated || len(ids) > maxMessageAttachments {
+		return nil, errInvalid
+	}
+	oids := make([]primitive.ObjectID, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil || seen[id] {
+			return nil, errInvalid
+		}
+		seen[id] = true
+		oids = append(oids, oid)
+	}
+	cur, err := db.AttachmentsCol.Find(ctx, bson.M{
+		"_id":        bson.M{"$in": oids},
+		"owner_id":   user.ID,
+		"message_id": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Attachment
+	if err := cur.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	if len(found) != len(oids) {
+		return nil, errInvalid
+	}
+	byID := make(map[string]models.Attachment, len(found))
+	for _, a := range found {
+		byID[a.ID.Hex()] = a
+	}
+	refs := make([]models.AttachmentRef, len(ids))
+	for i, id := range ids {
+		a := byID[id]
+		refs[i] = models.AttachmentRef{
+			ID:          id,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Width:       a.Width,
+			Height:      a.Height,
+			Placeholder: a.Placeholder,
+			Thumbnails:  a.Thumbnails,
+		}
+	}
+	return refs, nil
+}
+
+// claimAttachments rattache les fichiers au message avant son insertion.
+// Un fichier déjà pris entre-temps par un autre message fait échouer l'ensemble.
+func claimAttachments(ctx context.Context, m models.Message) error {
+	if len(m.Attachments) == 0 {
+		return nil
+	}
+	oids := make([]primitive.ObjectID, 0, len(m.Attachments))
+	for _, a := range m.Attachments {
+		oid, err := primitive.ObjectIDFromHex(a.ID)
+		if err != nil {
+			return errInvalid
+		}
+		oids = append(oids, oid)
+	}
+	res, err := db.AttachmentsCol.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": oids}, "owner_id": m.UserID, "message_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"message_id": m.ID.Hex(), "room": m.Room}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount != int64(len(oids)) {
+		releaseAttachments(ctx, m.ID.Hex())
+		return errConflict
+	}
+	return nil
+}
+
+// releaseAttachments rend libres les fichiers d'un message qui n'a pas pu être stocké.
+func releaseAttachments(ctx context.Context, messageID string) {
+	if _, err := db.AttachmentsCol.UpdateMany(ctx,
+		bson.M{"message_id": messageID},
+		bson.M{"$unset": bson.M{"message_id": "", "room": ""}},
+	); err != nil {
+		log.Printf("release attachments %s: %v", messageID, err)
+	}
+}
+
+// detachAttachments marque pour collecte les fichiers d'un message supprimé.
+func detachAttachments(ctx context.Context, messageID string) {
+	if _, err := db.AttachmentsCol.UpdateMany(ctx,
+		bson.M{"message_id": messageID},
+		bson.M{"$set": bson.M{"detached": true}},
+	); err != nil {
+		log.Printf("detach attachments %s: %v", messageID, err)
+	}
+}
+
+// canAccessAttachment: l'auteur seul tant que le fichier n'est pas rattaché,
+// puis les lecteurs du salon; un message masqué ne l'est plus que pour l'auteur et les modérateurs.
+func canAccessAttachment(ctx context.Context, u WSUser, a models.Attachment) error {
+	if a.Detached {
+		return errNotFound
+	}
+	owner := u.Authenticated && u.ID == a.OwnerID
+	if a.MessageID == "" {
+		if !owner {
+			return errNotFound
+		}
+		return nil
+	}
+	if !canReadRoom(ctx, u, a.Room) {
+		return errForbidden
+	}
+	m, err := loadMessage(ctx, a.MessageID)
+	if err != nil || m.Deleted {
+		return errNotFound
+	}
+	if m.Hidden && !owner && !canModerate(ctx, u, a.Room) {
+		return errNotFound
+	}
+	return nil
+}
+
+func loadAttachment(ctx context.Context, id string) (models.Attachment, error) {
+	var a models.Attachment
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return a, errNotFound
+	}
+	if err := db.AttachmentsCol.FindOne(ctx, bson.M{"_id": oid}).Decode(&a); err != nil {
+		return a, errNotFound
+	}
+	return a, nil
+}
+
+// deriveKey dérive de secret une clé propre à un usage: HMAC-SHA256(secret, label).
+func deriveKey(secret []byte, label string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
+
+func attachmentSignature(secret []byte, id string, exp int64) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(id + "." + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validSignature vérifie exp/sig d'une URL signée.
+func validSignature(id, expParam, sig string) bool {
+	cfg := attachmentLimits()
+	if len(cfg.secret) == 0 || sig == "" {
+		return false
+	}
+	exp, err := strconv.ParseInt(expParam, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(attachmentSignature(cfg.secret, id, exp)))
+}
+
+// uploadAttachmentHandler: POST /api/attachments (multipart, champ "file")
+// Le type est détecté sur le contenu, jamais pris du client.
+func uploadAttachmentHandler(c *gin.Context) {
+	cfg := attachmentLimits()
+	me := authViewer(c)
+	// Marge pour l'enveloppe multipart; la taille du fichier est vérifiée ensuite.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.maxBytes+64<<10)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large", "max_bytes": cfg.maxBytes})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+		return
+	}
+	if fh.Size > cfg.maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large", "max_bytes": cfg.maxBytes})
+		return
+	}
+	if fh.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty file"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+		return
+	}
+	defer f.Close()
+
+	detected, err := mimetype.DetectReader(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+		return
+	}
+	ctype := baseType(detected.String())
+	if !cfg.allowed[ctype] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "file type not allowed", "content_type": ctype})
+		return
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "read error"})
+		return
+	}
+
+	// Les images sont lues en entier (la taille est déjà bornée) pour en retirer les métadonnées.
+	var body io.Reader = f
+	size := fh.Size
+	var width, height int
+	if strings.HasPrefix(ctype, "image/") {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+			return
+		}
+		clean, w, h, err := sanitizeImage(ctype, data, cfg.maxPixels)
+		switch {
+		case errors.Is(err, errImageTooLarge):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "image too large", "max_pixels": cfg.maxPixels})
+			return
+		case err != nil:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image"})
+			return
+		}
+		body, size, width, height = bytes.NewReader(clean), int64(len(clean)), w, h
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	pending, err := db.AttachmentsCol.CountDocuments(ctx, bson.M{"owner_id": me.ID, "message_id": bson.M{"$exists": false}})
+	if err == nil && pending >= maxPendingUploads {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errLimit.Error()})
+		return
+	}
+
+	a := models.Attachment{
+		ID:          primitive.NewObjectID(),
+		OwnerID:     me.ID,
+		Filename:    cleanFilename(fh.Filename),
+		ContentType: ctype,
+		Size:        size,
+		Width:       width,
+		Height:      height,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if decodableImage(ctype) {
+		a.PreviewStatus = previewPending
+	}
+	a.Key = "attachments/" + a.ID.Hex()
+	store := currentBlobStore()
+	if err := store.Put(ctx, a.Key, body, a.Size, a.ContentType); err != nil {
+		log.Printf("blob put %s: %v", a.Key, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "storage error"})
+		return
+	}
+	if _, err := db.AttachmentsCol.InsertOne(ctx, a); err != nil {
+		_ = store.Delete(ctx, a.Key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if a.PreviewStatus == previewPending {
+		wakeThumbnailer()
+	}
+	resp := gin.H{
+		"id":           a.ID.Hex(),
+		"filename":     a.Filename,
+		"content_type": a.ContentType,
+		"size":         a.Size,
+		"url":          attachmentURL(a.ID.Hex()),
+		"created_at":   a.CreatedAt,
+	}
+	if a.Width > 0 {
+		resp["width"], resp["height"], resp["preview_status"] = a.Width, a.Height, a.PreviewStatus
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// downloadAttachmentHandler: GET /api/attachments/:id[?exp=&sig=]
+func downloadAttachmentHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, err := loadAttachment(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if !authorizeDownload(ctx, c, a) {
+		return
+	}
+	serveBlob(c, a.Key, a.Size, a.ContentType, a.Filename)
+}
+
+// authorizeDownload: une URL signée valide dispense de l'authentification; sinon droits de l'appelant.
+// Répond lui-même en cas de refus.
+func authorizeDownload(ctx context.Context, c *gin.Context, a models.Attachment) bool {
+	if c.Query("sig") != "" {
+		if !validSignature(a.ID.Hex(), c.Query("exp"), c.Query("sig")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired signature"})
+			return false
+		}
+		if a.Detached {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return false
+		}
+		return true
+	}
+	if err := canAccessAttachment(ctx, requestViewer(c.Request), a); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return false
+	}
+	return true
+}
+
+// serveBlob diffuse un blob sans jamais laisser le navigateur réinterpréter son type.
+func serveBlob(c *gin.Context, key string, size int64, contentType, filename string) {
+	// Le flux peut durer: pas de délai court sur la lecture du blob.
+	rc, err := currentBlobStore().Get(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return
+		}
+		log.Printf("blob get %s: %v", key, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "storage error"})
+		return
+	}
+	defer rc.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	c.DataFromReader(http.StatusOK, size, contentType, rc, map[string]string{
+		"Content-Disposition":     disposition + `; filename*=UTF-8''` + url.PathEscape(filename),
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "default-src 'none'; sandbox",
+		"Cache-Control":           "private, max-age=300",
+	})
+}
+
+// attachmentURLHandler: GET /api/attachments/:id/url
+// URL signée à durée limitée, pour les clients qui ne peuvent pas joindre leurs identifiants (balises <img>, lecteurs).
+func attachmentURLHandler(c *gin.Context) {
+	cfg := attachmentLimits()
+	if len(cfg.secret) == 0 {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "signed urls disabled"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, err := loadAttachment(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if err := canAccessAttachment(ctx, authViewer(c), a); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	exp := time.Now().Add(cfg.urlTTL).Unix()
+	id := a.ID.Hex()
+	c.JSON(http.StatusOK, gin.H{
+		"url":        attachmentURL(id) + "?exp=" + strconv.FormatInt(exp, 10) + "&sig=" + attachmentSignature(cfg.secret, id, exp),
+		"expires_at": time.Unix(exp, 0).UTC(),
+	})
+}
+
+// deleteAttachmentHandler: DELETE /api/attachments/:id (fichier non rattaché, par son auteur)
+func deleteAttachmentHandler(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var a models.Attachment
+	err = db.AttachmentsCol.FindOneAndDelete(ctx,
+		bson.M{"_id": oid, "owner_id": authViewer(c).ID, "message_id": bson.M{"$exists": false}},
+	).Decode(&a)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if err := deleteAttachmentBlobs(ctx, a); err != nil {
+		log.Printf("blob delete %s: %v", a.Key, err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// deleteAttachmentBlobs supprime le fichier et ses vignettes.
+func deleteAttachmentBlobs(ctx context.Context, a models.Attachment) error {
+	store := currentBlobStore()
+	for _, t := range a.Thumbnails {
+		if err := store.Delete(ctx, t.Key); err != nil {
+			return err
+		}
+	}
+	return store.Delete(ctx, a.Key)
+}
+
+// startAttachmentGC lance la collecte périodique des fichiers orphelins.
+func startAttachmentGC() {
+	gcOnce.Do(func() {
+		go func() {
+			t := time.NewTicker(attachmentGCInterval)
+			defer t.Stop()
+			for range t.C {
+				collectAttachments()
+			}
+		}()
+	})
+}
+
+// collectAttachments supprime les fichiers jamais rattachés passé orphanTTL et ceux
+// des messages supprimés. Un verrou Redis évite que les instances ne collectent ensemble.
+func collectAttachments() {
+	ctx, cancel := context.WithTimeout(context.Background(), attachmentGCInterval/2)
+	defer cancel()
+	ok, err := db.Rdb.SetNX(ctx, attachmentGCLock, 1, attachmentGCInterval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	cutoff := primitive.NewDateTimeFromTime(time.Now().Add(-attachmentLimits().orphanTTL))
+	filter := bson.M{"$or": []bson.M{
+		{"message_id": bson.M{"$exists": false}, "created_at": bson.M{"$lt": cutoff}},
+		{"detached": true},
+	}}
+	cur, err := db.AttachmentsCol.Find(ctx, filter, options.Find().SetLimit(attachmentGCBatch))
+	if err != nil {
+		log.Printf("attachments gc: %v", err)
+		return
+	}
+	var stale []models.Attachment
+	if err := cur.All(ctx, &stale); err != nil {
+		log.Printf("attachments gc: %v", err)
+		return
+	}
+	removed := 0
+	for _, a := range stale {
+		// La base d'abord, filtre repris: un fichier rattaché entre-temps reste intact.
+		res, err := db.AttachmentsCol.DeleteOne(ctx, bson.M{"_id": a.ID, "$or": filter["$or"]})
+		if err != nil || res.DeletedCount == 0 {
+			continue
+		}
+		if err := deleteAttachmentBlobs(ctx, a); err != nil {
+			log.Printf("attachments gc %s: %v", a.Key, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("attachments gc: %d fichiers supprimés", removed)
+	}
+}
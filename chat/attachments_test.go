@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+)
+
+func TestBaseType(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"image/png", "image/png"},
+		{"Image/JPEG; charset=binary", "image/jpeg"},
+		{" text/plain ;", "text/plain"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := baseType(tt.in); got != tt.want {
+			t.Errorf("baseType(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCleanFilename(t *testing.T) {
+	long := strings.Repeat("é", maxFilenameLen) + ".pdf"
+	tests := []struct{ in, want string }{
+		{"rapport.pdf", "rapport.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\moi\photo.jpg`, "photo.jpg"},
+		{"a\"b\x00c\n.txt", "abc.txt"},
+		{"  espaces.txt  ", "espaces.txt"},
+		{"", "fichier"},
+		{".", "fichier"},
+		{"/", "fichier"},
+		{long, string([]rune(long)[4:])},
+	}
+	for _, tt := range tests {
+		if got := cleanFilename(tt.in); got != tt.want {
+			t.Errorf("cleanFilename(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAttachmentSignature(t *testing.T) {
+	key := deriveKey([]byte("jwt-secret"), "attachment-url")
+	if string(key) == "jwt-secret" || len(key) != 32 {
+		t.Fatalf("deriveKey = %x; want clé HMAC-SHA256 distincte du secret", key)
+	}
+	if string(deriveKey([]byte("jwt-secret"), "autre")) == string(key) {
+		t.Errorf("deriveKey: même clé pour deux usages")
+	}
+
+	sig := attachmentSignature(key, "a1", 1700000000)
+	if len(sig) != 64 {
+		t.Errorf("attachmentSignature = %q; want 64 hexadécimaux", sig)
+	}
+	for name, other := range map[string]string{
+		"autre id":       attachmentSignature(key, "a2", 1700000000),
+		"autre échéance": attachmentSignature(key, "a1", 1700000001),
+		"autre clé":      attachmentSignature([]byte("x"), "a1", 1700000000),
+		"id qui déborde": attachmentSignature(key, "a1.1700000000", 0),
+	} {
+		if other == sig {
+			t.Errorf("attachmentSignature(%s) identique", name)
+		}
+	}
+}
+
+func TestAttachmentRefs(t *testing.T) {
+	m := models.Message{Attachments: []models.AttachmentRef{{
+		ID:         "a1",
+		Thumbnails: []models.Thumbnail{{Size: 64}, {Size: 256}},
+	}}}
+	refs := attachmentRefs(m)
+	if len(refs) != 1 || refs[0].URL != "/api/attachments/a1" {
+		t.Fatalf("attachmentRefs = %+v; want URL de téléchargement", refs)
+	}
+	for i, size := range []int{64, 256} {
+		if got, want := refs[0].Thumbnails[i].URL, thumbnailURL("a1", size); got != want || got == "" {
+			t.Errorf("vignette %d: URL = %q; want %q", size, got, want)
+		}
+	}
+	if m.Attachments[0].URL != "" || m.Attachments[0].Thumbnails[0].URL != "" {
+		t.Errorf("attachmentRefs a modifié le message d'origine")
+	}
+
+	for name, hidden := range map[string]models.Message{
+		"supprimé": {Deleted: true, Attachments: m.Attachments},
+		"masqué":   {Hidden: true, Attachments: m.Attachments},
+		"sans":     {},
+	} {
+		if got := attachmentRefs(hidden); got != nil {
+			t.Errorf("attachmentRefs(%s) = %+v; want nil", name, got)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/auth"
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Avatars. L'image téléversée est validée, recadrée en carré, réduite puis
+// réencodée (ce qui en retire toutes les métadonnées) et rangée dans le BlobStore.
+// Sans téléversement, un identicon est généré à partir de l'id: même id, même image.
+const (
+	avatarSide        = 256
+	avatarMaxBytes    = 5 << 20
+	identiconGrid     = 5
+	identiconDefault  = 128
+	identiconMinSide  = 32
+	identiconMaxSide  = 512
+	avatarCacheMaxAge = 300 // secondes, pour une URL sans version
+)
+
+// avatarTypes: formats acceptés au téléversement (décodables par la bibliothèque standard).
+var avatarTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// cropSquare découpe le carré demandé (coordonnées dans l'original), ou le carré central
+// si la demande est absente ou sort de l'image.
+func cropSquare(src *image.RGBA, x, y, side int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if side <= 0 || x < 0 || y < 0 || x+side > w || y+side > h {
+		side = min(w, h)
+		x, y = (w-side)/2, (h-side)/2
+	}
+	out := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(out, out.Bounds(), src, image.Pt(x, y), draw.Src)
+	return out
+}
+
+// identicon dessine une grille 5x5 symétrique dont motif et couleur viennent du hachage de seed.
+func identicon(seed string, side int) *image.Paletted {
+	sum := sha256.Sum256([]byte(seed))
+	fg := hueColor(float64(uint16(sum[29])<<8|uint16(sum[30])) / 65535 * 360)
+	bg := color.RGBA{0xF0, 0xF0, 0xF0, 0xFF}
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{bg, fg})
+
+	cell := side / (identiconGrid + 1)
+	margin := (side - cell*identiconGrid) / 2
+	bit := 0
+	for col := 0; col < (identiconGrid+1)/2; col++ {
+		for row := 0; row < identiconGrid; row++ {
+			on := sum[bit/8]>>(bit%8)&1 == 1
+			bit++
+			if !on {
+				continue
+			}
+			for _, c := range []int{col, identiconGrid - 1 - col} {
+				r := image.Rect(margin+c*cell, margin+row*cell, margin+(c+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, r, &image.Uniform{fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// hueColor: couleur saturée de teinte h (degrés), assez sombre pour contraster avec le fond.
+func hueColor(h float64) color.RGBA {
+	const s, l = 0.55, 0.5
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	var r, g, b float64
+	switch int(h/60) % 6 {
+	case 0:
+		r, g = c, x
+	case 1:
+		r, g = x, c
+	case 2:
+		g, b = c, x
+	case 3:
+		g, b = x, c
+	case 4:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	m := l - c/2
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 0xFF}
+}
+
+// uploadAvatarHandler: POST /api/me/avatar (multipart: file; facultatifs x, y, size = carré à garder, en pixels de l'original)
+func uploadAvatarHandler(c *gin.Context) {
+	me := authViewer(c)
+	uid, err := primitive.ObjectIDFromHex(me.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatarMaxBytes+64<<10)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large", "max_bytes": avatarMaxBytes})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+		return
+	}
+	if fh.Size > avatarMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large", "max_bytes": avatarMaxBytes})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+		return
+	}
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(f)
+	f.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+		return
+	}
+	data := buf.Bytes()
+
+	ctype := baseType(mimetype.Detect(data).String())
+	if !avatarTypes[ctype] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "file type not allowed", "content_type": ctype})
+		return
+	}
+	if _, _, err := checkImageBounds(data, attachmentLimits().maxPixels); err != nil {
+		if errors.Is(err, errImageTooLarge) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "image too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image"})
+		return
+	}
+	img, err := decodeImage(ctype, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image"})
+		return
+	}
+	if ctype == "image/jpeg" {
+		if o := jpegOrientation(data); o > 1 && o <= 8 {
+			img = orient(img, o)
+		}
+	}
+	x, _ := strconv.Atoi(c.PostForm("x"))
+	y, _ := strconv.Atoi(c.PostForm("y"))
+	side, _ := strconv.Atoi(c.PostForm("size"))
+	img = cropSquare(img, x, y, side)
+	if img.Bounds().Dx() > avatarSide {
+		img = resizeBox(img, avatarSide, avatarSide)
+	}
+
+	// PNG pour garder la transparence, JPEG pour les photos.
+	var out bytes.Buffer
+	ext, outType := ".jpg", "image/jpeg"
+	if ctype == "image/jpeg" {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: reencodeQuality})
+	} else {
+		ext, outType = ".png", "image/png"
+		err = png.Encode(&out, img)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "encode error"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	version := time.Now().UnixMilli()
+	key := auth.AvatarKeyPrefix + me.ID + "-" + strconv.FormatInt(version, 10) + ext
+	store := currentBlobStore()
+	if err := store.Put(ctx, key, bytes.NewReader(out.Bytes()), int64(out.Len()), outType); err != nil {
+		log.Printf("avatar put %s: %v", key, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "storage error"})
+		return
+	}
+	var previous models.User
+	err = db.UsersCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": uid},
+		bson.M{"$set": bson.M{"avatar": key, "avatar_version": version}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"avatar": 1}),
+	).Decode(&previous)
+	if err != nil {
+		_ = store.Delete(ctx, key)
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if strings.HasPrefix(previous.Avatar, auth.AvatarKeyPrefix) {
+		if err := store.Delete(ctx, previous.Avatar); err != nil {
+			log.Printf("avatar delete %s: %v", previous.Avatar, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"avatar": auth.AvatarURL(models.User{ID: uid, Avatar: key, AvatarVersion: version})})
+}
+
+// deleteAvatarHandler: DELETE /api/me/avatar — retour à l'avatar généré.
+func deleteAvatarHandler(c *gin.Context) {
+	uid, err := primitive.ObjectIDFromHex(authViewer(c).ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var previous models.User
+	err = db.UsersCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": uid},
+		bson.M{"$unset": bson.M{"avatar": "", "avatar_version": ""}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"avatar": 1}),
+	).Decode(&previous)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if strings.HasPrefix(previous.Avatar, auth.AvatarKeyPrefix) {
+		if err := currentBlobStore().Delete(ctx, previous.Avatar); err != nil {
+			log.Printf("avatar delete %s: %v", previous.Avatar, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"avatar": auth.AvatarURL(models.User{ID: uid})})
+}
+
+// avatarHandler: GET /api/avatars/:id[?v=&size=]
+// Avatar téléversé du compte s'il existe, identicon de :id sinon (id de compte ou pseudonyme d'invité).
+// Une URL versionnée (v = version courante) est mise en cache sans limite.
+func avatarHandler(c *gin.Context) {
+	seed := c.Param("id")
+	if oid, err := primitive.ObjectIDFromHex(seed); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var u models.User
+		err := db.UsersCol.FindOne(ctx, bson.M{"_id": oid},
+			options.FindOne().SetProjection(bson.M{"avatar": 1, "avatar_version": 1}),
+		).Decode(&u)
+		if err == nil && strings.HasPrefix(u.Avatar, auth.AvatarKeyPrefix) {
+			rc, err := currentBlobStore().Get(c.Request.Context(), u.Avatar)
+			if err == nil {
+				defer rc.Close()
+				cache := "public, max-age=" + strconv.Itoa(avatarCacheMaxAge)
+				if c.Query("v") == strconv.FormatInt(u.AvatarVersion, 10) {
+					cache = "public, max-age=31536000, immutable"
+				}
+				ctype := "image/jpeg"
+				if strings.HasSuffix(u.Avatar, ".png") {
+					ctype = "image/png"
+				}
+				c.DataFromReader(http.StatusOK, -1, ctype, rc, map[string]string{
+					"Cache-Control":          cache,
+					"X-Content-Type-Options": "nosniff",
+				})
+				return
+			}
+			log.Printf("avatar get %s: %v", u.Avatar, err)
+		}
+	}
+
+	side := identiconDefault
+	if n, err := strconv.Atoi(c.Query("size")); err == nil {
+		side = max(identiconMinSide, min(identiconMaxSide, n))
+	}
+	sum := sha256.Sum256([]byte(seed))
+	etag := `"` + hex.EncodeToString(sum[:8]) + "-" + strconv.Itoa(side) + `"`
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, identicon(seed, side)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "encode error"})
+		return
+	}
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(avatarCacheMaxAge))
+	c.Data(http.StatusOK, "image/png", out.Bytes())
+}
+
+// avatarFor: URL de l'avatar de l'auteur d'un message (sans version: toujours l'avatar courant).
+func avatarFor(m models.Message) string {
+	if m.UserID != "" {
+		return auth.DefaultAvatarURL(m.UserID)
+	}
+	return auth.DefaultAvatarURL(m.Sender)
+}
@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+)
+
+func TestCropSquare(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	tests := []struct {
+		name          string
+		x, y, side    int
+		wantSide      int
+		originX, orgY int // pixel source attendu en (0, 0)
+	}{
+		{"demandé", 5, 2, 10, 10, 5, 2},
+		{"centre par défaut", 0, 0, 0, 20, 10, 0},
+		{"hors image", 35, 0, 10, 20, 10, 0},
+		{"négatif", -1, 0, 10, 20, 10, 0},
+	}
+	for _, tt := range tests {
+		out := cropSquare(src, tt.x, tt.y, tt.side)
+		if b := out.Bounds(); b.Dx() != tt.wantSide || b.Dy() != tt.wantSide || b.Min != (image.Point{}) {
+			t.Errorf("cropSquare(%s) bounds = %v; want carré %d en 0,0", tt.name, b, tt.wantSide)
+			continue
+		}
+		if got := out.RGBAAt(0, 0); got.R != uint8(tt.originX) || got.G != uint8(tt.orgY) {
+			t.Errorf("cropSquare(%s) origine = %d,%d; want %d,%d", tt.name, got.R, got.G, tt.originX, tt.orgY)
+		}
+	}
+}
+
+func TestIdenticon(t *testing.T) {
+	a, b := identicon("u1", 128), identicon("u1", 128)
+	if string(a.Pix) != string(b.Pix) {
+		t.Errorf("identicon non déterministe")
+	}
+	if string(identicon("u2", 128).Pix) == string(a.Pix) {
+		t.Errorf("identicon identique pour deux graines")
+	}
+	if got := a.Bounds(); got.Dx() != 128 || got.Dy() != 128 {
+		t.Errorf("identicon bounds = %v; want 128x128", got)
+	}
+	// Symétrie verticale du motif, autour de la grille (marges inégales d'un pixel).
+	cell := 128 / (identiconGrid + 1)
+	margin := (128 - cell*identiconGrid) / 2
+	mirror := 2*margin + cell*identiconGrid - 1 // x et mirror-x sont symétriques
+	for y := 0; y < 128; y++ {
+		for x := margin; x < margin+cell*identiconGrid; x++ {
+			if a.ColorIndexAt(x, y) != a.ColorIndexAt(mirror-x, y) {
+				t.Fatalf("identicon asymétrique en (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func TestHueColor(t *testing.T) {
+	tests := []struct {
+		h    float64
+		want color.RGBA
+	}{
+		{0, color.RGBA{197, 57, 57, 255}},
+		{120, color.RGBA{57, 197, 57, 255}},
+		{240, color.RGBA{57, 57, 197, 255}},
+		{60, color.RGBA{197, 197, 57, 255}},
+		{360, color.RGBA{197, 57, 57, 255}},
+	}
+	for _, tt := range tests {
+		if got := hueColor(tt.h); got != tt.want {
+			t.Errorf("hueColor(%v) = %v; want %v", tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestAvatarFor(t *testing.T) {
+	tests := []struct {
+		m    models.Message
+		want string
+	}{
+		{models.Message{UserID: "u1", Sender: "alice"}, "/api/avatars/u1"},
+		{models.Message{Sender: "Invité 7"}, "/api/avatars/Invit%C3%A9%207"},
+	}
+	for _, tt := range tests {
+		if got := avatarFor(tt.m); got != tt.want {
+			t.Errorf("avatarFor(%+v) = %q; want %q", tt.m, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// BlobStore stocke le contenu des pièces jointes; les métadonnées restent dans Mongo.
+// Get renvoie errNotFound pour une clé absente.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	blobMu    sync.RWMutex
+	blobOnce  sync.Once
+	blobStore BlobStore
+)
+
+// SetBlobStore remplace le stockage des pièces jointes.
+// Sans appel, il est choisi par l'environnement (blobStoreFromEnv).
+func SetBlobStore(b BlobStore) {
+	blobOnce.Do(func() {})
+	blobMu.Lock()
+	blobStore = b
+	blobMu.Unlock()
+}
+
+func currentBlobStore() BlobStore {
+	blobOnce.Do(func() {
+		b := blobStoreFromEnv()
+		blobMu.Lock()
+		blobStore = b
+		blobMu.Unlock()
+	})
+	blobMu.RLock()
+	defer blobMu.RUnlock()
+	return blobStore
+}
+
+// blobStoreFromEnv: BLOB_BACKEND=s3 (S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY,
+// S3_SECRET_KEY; MinIO ou tout service compatible), disque local sinon (BLOB_DIR).
+// Une configuration S3 invalide arrête le serveur: un repli sur le disque local
+// donnerait à chaque instance son propre jeu de fichiers.
+func blobStoreFromEnv() BlobStore {
+	if os.Getenv("BLOB_BACKEND") == "s3" {
+		s, err := NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+		if err != nil {
+			log.Fatalf("❌ Stockage S3 invalide: %v", err)
+		}
+		return s
+	}
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = "data/blobs"
+	}
+	return NewLocalStore(dir)
+}
@@ -0,0 +1,243 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Blocages. La liste d'un utilisateur est chargée sur chacune de ses connexions
+// à l'ouverture, puis tenue à jour par un canal Redis: le filtrage à la diffusion
+// n'est qu'une lecture de map sous le verrou déjà tenu par le hub.
+const blocksChannel = "chat:blocks"
+
+const maxBlocks = 1000
+
+type blockSignal struct {
+	Blocker string `json:"blocker"`
+	Blocked string `json:"blocked"`
+	On      bool   `json:"on"`
+}
+
+var blocksOnce sync.Once
+
+func startBlocks() {
+	blocksOnce.Do(func() { go blocksRelay() })
+}
+
+func blocksRelay() {
+	sub := db.Rdb.Subscribe(context.Background(), blocksChannel)
+	for msg := range sub.Channel() {
+		var sig blockSignal
+		if err := json.Unmarshal([]byte(msg.Payload), &sig); err != nil {
+			continue
+		}
+		wsHub.setBlock(sig.Blocker, sig.Blocked, sig.On)
+	}
+}
+
+func publishBlock(ctx context.Context, sig blockSignal) {
+	payload, _ := json.Marshal(sig)
+	if err := db.Rdb.Publish(ctx, blocksChannel, payload).Err(); err != nil {
+		log.Printf("blocks publish: %v", err)
+		wsHub.setBlock(sig.Blocker, sig.Blocked, sig.On) // au moins cette instance
+	}
+}
+
+// setBlock met à jour les connexions locales du bloqueur.
+func (h *hub) setBlock(blocker, blocked string, on bool) {
+	h.mu.Lock()
+	for _, cl := range h.conns {
+		if cl.user.Authenticated && cl.user.ID == blocker {
+			if on {
+				cl.blocked[blocked] = true
+			} else {
+				delete(cl.blocked, blocked)
+			}
+		}
+	}
+	h.mu.Unlock()
+}
+
+func (h *hub) loadBlocks(c *websocket.Conn, ids []string) {
+	h.mu.Lock()
+	if cl, ok := h.conns[c]; ok {
+		for _, id := range ids {
+			cl.blocked[id] = true
+		}
+	}
+	h.mu.Unlock()
+}
+
+// sendEvent écrit directement (sans tampon de reprise), sauf message d'un utilisateur bloqué.
+func (h *hub) sendEvent(c *websocket.Conn, ev roomEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.conns[c]
+	if !ok {
+		return
+	}
+	if a := ev.eventAuthor(); a != "" && cl.blocked[a] {
+		return
+	}
+	h.write(c, ev)
+}
+
+// blockedIDs liste les utilisateurs bloqués par userID.
+func blockedIDs(ctx context.Context, userID string) ([]string, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	cur, err := db.BlocksCol.Find(ctx, bson.M{"blocker_id": userID}, options.Find().SetProjection(bson.M{"blocked_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var blocks []models.Block
+	if err := cur.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(blocks))
+	for i, b := range blocks {
+		ids[i] = b.BlockedID
+	}
+	return ids, nil
+}
+
+// isBlocked: blocker a-t-il bloqué blocked?
+func isBlocked(ctx context.Context, blocker, blocked string) bool {
+	if blocker == "" || blocked == "" {
+		return false
+	}
+	n, err := db.BlocksCol.CountDocuments(ctx, bson.M{"blocker_id": blocker, "blocked_id": blocked}, options.Count().SetLimit(1))
+	return err == nil && n > 0
+}
+
+// blockersOf renvoie, parmi ids, ceux qui ont bloqué author.
+func blockersOf(ctx context.Context, author string, ids []string) map[string]bool {
+	out := map[string]bool{}
+	if author == "" || len(ids) == 0 {
+		return out
+	}
+	cur, err := db.BlocksCol.Find(ctx, bson.M{"blocked_id": author, "blocker_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Printf("blockers of %s: %v", author, err)
+		return out
+	}
+	var blocks []models.Block
+	if err := cur.All(ctx, &blocks); err != nil {
+		return out
+	}
+	for _, b := range blocks {
+		out[b.BlockerID] = true
+	}
+	return out
+}
+
+// excludeBlocked retire du filtre les messages des utilisateurs bloqués par viewer.
+func excludeBlocked(ctx context.Context, filter bson.M, viewer WSUser) bool {
+	if !viewer.Authenticated {
+		return true
+	}
+	ids, err := blockedIDs(ctx, viewer.ID)
+	if err != nil {
+		log.Printf("blocked ids %s: %v", viewer.ID, err)
+		return false
+	}
+	if len(ids) > 0 {
+		filter["user_id"] = bson.M{"$nin": ids}
+	}
+	return true
+}
+
+// blockHandler: POST /api/blocks {user: id|username}
+func blockHandler(c *gin.Context) {
+	var req struct {
+		User string `json:"user"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.User == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user required"})
+		return
+	}
+	me := authViewer(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t, err := resolveTarget(ctx, req.User, "")
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	if t.user.ID == me.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot block yourself"})
+		return
+	}
+	if n, err := db.BlocksCol.CountDocuments(ctx, bson.M{"blocker_id": me.ID}); err == nil && n >= maxBlocks {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errLimit.Error()})
+		return
+	}
+	b := models.Block{
+		ID:        primitive.NewObjectID(),
+		BlockerID: me.ID,
+		BlockedID: t.user.ID,
+		Username:  t.user.Username,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := db.BlocksCol.InsertOne(ctx, b); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		// Déjà bloqué: on renvoie le blocage existant.
+		if err := db.BlocksCol.FindOne(ctx, bson.M{"blocker_id": me.ID, "blocked_id": t.user.ID}).Decode(&b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+	publishBlock(ctx, blockSignal{Blocker: me.ID, Blocked: t.user.ID, On: true})
+	c.JSON(http.StatusOK, b)
+}
+
+// unblockHandler: DELETE /api/blocks/:user (id)
+func unblockHandler(c *gin.Context) {
+	me := authViewer(c)
+	blocked := c.Param("user")
+	res, err := db.BlocksCol.DeleteOne(c, bson.M{"blocker_id": me.ID, "blocked_id": blocked})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not blocked"})
+		return
+	}
+	publishBlock(c, blockSignal{Blocker: me.ID, Blocked: blocked, On: false})
+	c.Status(http.StatusNoContent)
+}
+
+// listBlocksHandler: GET /api/blocks
+func listBlocksHandler(c *gin.Context) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := db.BlocksCol.Find(c, bson.M{"blocker_id": authViewer(c).ID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	blocks := []models.Block{}
+	if err := cur.All(c, &blocks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blocks": blocks})
+}
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gorilla/websocket"
+)
+
+func TestHubSetBlock(t *testing.T) {
+	h := &hub{conns: make(map[*websocket.Conn]*client), gone: make(map[*websocket.Conn]*client)}
+	tab1, tab2, other := &websocket.Conn{}, &websocket.Conn{}, &websocket.Conn{}
+	h.add(tab1, WSUser{ID: "alice", Authenticated: true})
+	h.add(tab2, WSUser{ID: "alice", Authenticated: true})
+	h.add(other, WSUser{ID: "carol", Authenticated: true})
+
+	h.setBlock("alice", "bob", true)
+	for name, c := range map[string]*websocket.Conn{"onglet 1": tab1, "onglet 2": tab2} {
+		if !h.conns[c].blocked["bob"] {
+			t.Errorf("%s: bob non bloqué", name)
+		}
+	}
+	if h.conns[other].blocked["bob"] {
+		t.Errorf("bob bloqué chez carol")
+	}
+
+	h.setBlock("alice", "bob", false)
+	if h.conns[tab1].blocked["bob"] || h.conns[tab2].blocked["bob"] {
+		t.Errorf("bob toujours bloqué après déblocage")
+	}
+
+	h.loadBlocks(other, []string{"dave", "erin"})
+	if b := h.conns[other].blocked; !b["dave"] || !b["erin"] || len(b) != 2 {
+		t.Errorf("loadBlocks = %v; want dave et erin", b)
+	}
+}
+
+func TestEventAuthor(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   roomEvent
+		want string
+	}{
+		{"message d'un compte", toWSMessage(models.Message{UserID: "u1", Sender: "alice"}), "u1"},
+		{"message d'un invité", toWSMessage(models.Message{Sender: "Invité-1"}), ""},
+		{"évènement", WSEvent{Type: "message.edited"}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.ev.eventAuthor(); got != tt.want {
+			t.Errorf("eventAuthor(%s) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,70 +16,430 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type WSMessage struct {
-	Username  string    `json:"username"`
-	Text      string    `json:"text"`
-	Timestamp time.Time `json:"timestamp"`
-	Room      string    `json:"room"`
+	ID         string         `json:"id,omitempty"`
+	Seq        int64          `json:"seq,omitempty"`
+	Username   string         `json:"username"`
+	Avatar     string         `json:"avatar,omitempty"`
+	Guest      bool           `json:"guest,omitempty"`
+	Text       string         `json:"text"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Room       string         `json:"room"`
+	EditedAt   *time.Time     `json:"edited_at,omitempty"`
+	Deleted    bool           `json:"deleted,omitempty"`
+	Hidden     bool           `json:"hidden,omitempty"` // masqué en attente de revue (texte vide)
+	Reactions  map[string]int `json:"reactions,omitempty"`
+	ReplyTo    string         `json:"reply_to,omitempty"`
+	Quote      *models.Quote  `json:"quote,omitempty"`
+	ThreadRoot string         `json:"thread_root,omitempty"`
+	ReplyCount int            `json:"reply_count,omitempty"`
+	Pinned     bool           `json:"pinned,omitempty"`
+
+	Announcement bool `json:"announcement,omitempty"` // affichée à part par les clients
+	Persistent   bool `json:"persistent,omitempty"`
+
+	Attachments []models.AttachmentRef `json:"attachments,omitempty"`
+	Poll        *models.Poll           `json:"poll,omitempty"`
+	Command     string                 `json:"command,omitempty"` // "me": action à la troisième personne
+
+	authorID string // non diffusé: filtrage des utilisateurs bloqués
 }
 
+// WSEvent est un évènement de salon autre qu'un message (édition, suppression, ...).
+// Type est namespacé ("message.edited", "message.deleted", ...), Data dépend du type.
+type WSEvent struct {
+	Type      string      `json:"type"`
+	Room      string      `json:"room"`
+	MessageID string      `json:"message_id,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// roomEvent est tout ce qui se diffuse dans un salon.
+// Seq vaut 0 pour ce qui n'est pas un message stocké; l'auteur (id du compte)
+// sert au filtrage des utilisateurs bloqués, vide s'il n'y a pas lieu.
+type roomEvent interface {
+	eventRoom() string
+	eventSeq() int64
+	eventAuthor() string
+}
+
+func (m WSMessage) eventRoom() string   { return m.Room }
+func (m WSMessage) eventSeq() int64     { return m.Seq }
+func (m WSMessage) eventAuthor() string { return m.authorID }
+func (e WSEvent) eventRoom() string     { return e.Room }
+func (e WSEvent) eventSeq() int64       { return 0 }
+func (e WSEvent) eventAuthor() string   { return "" }
+
+// WSAck confirme (ou refuse) un message envoyé par le client.
+// Type vaut "ack" quand le message est stocké, "nack" sinon (Reason renseigné).
+type WSAck struct {
+	Type        string     `json:"type"`
+	ClientMsgID string     `json:"client_msg_id"`
+	ID          string     `json:"id,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+	Room        string     `json:"room,omitempty"`
+	Seq         int64      `json:"seq,omitempty"`
+	Duplicate   bool       `json:"duplicate,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	RetryAfter  int64      `json:"retry_after_ms,omitempty"` // rate_limited, slow_mode
+	Text        string     `json:"text,omitempty"`           // contenu stocké, s'il a été expurgé
+}
+
+// WSError signale au client une requête refusée hors envoi de message.
+type WSError struct {
+	Type       string `json:"type"` // toujours "error"
+	Code       string `json:"code"`
+	Room       string `json:"room,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	RetryAfter int64  `json:"retry_after_ms,omitempty"`
+}
+
+// Raisons de nack envoyées au client.
+const (
+	nackEmptyText     = "empty_text"
+	nackQueueFull     = "queue_full"
+	nackStoreFailed   = "store_failed"
+	nackForbidden     = "forbidden"
+	nackBanned        = "banned"
+	nackMuted         = "muted"
+	nackLimited       = "rate_limited"
+	nackSlowMode      = "slow_mode"
+	nackGuestReadOnly = "guest_read_only"
+	nackRejected      = "content_rejected"
+	nackBlocked       = "blocked"
+	nackAttachment    = "invalid_attachment"
+	nackInvalidRoom   = "invalid_room"
+)
+
 type WSUser struct {
 	ID            string `json:"id,omitempty"`
 	Username      string `json:"username"`
 	Email         string `json:"email,omitempty"`
 	Avatar        string `json:"avatar,omitempty"`
 	Authenticated bool   `json:"authenticated"`
+	Guest         bool   `json:"guest,omitempty"` // pseudonyme attribué par le serveur
+	IP            string `json:"-"`               // identifie les invités pour les bans et mutes
 }
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// client est l'état d'une connexion WS.
+// replaying: salons en cours de reprise (resume); la diffusion live y est
+// mise en tampon jusqu'à la fin du rejeu depuis la base.
+// watching: utilisateurs dont la connexion suit la présence.
+// blocked: utilisateurs bloqués, dont les messages ne sont pas remis.
+// evicted: salons dont la connexion a été retirée (kick, ban), muets jusqu'au prochain subscribe.
+// rooms: salons suivis (general dès la connexion, puis trames subscribe/unsubscribe),
+// source des évènements join/leave des webhooks.
+type client struct {
+	id        string
+	user      WSUser
+	replaying map[string][]roomEvent
+	watching  map[string]bool
+	blocked   map[string]bool
+	evicted   map[string]bool
+	rooms     map[string]bool
+}
+
+// gone garde l'état des connexions retirées sur erreur d'écriture, jusqu'à ce que
+// leur boucle de lecture fasse le ménage (leaveAll).
 type hub struct {
 	mu    sync.Mutex
-	conns map[*websocket.Conn]WSUser
+	conns map[*websocket.Conn]*client
+	gone  map[*websocket.Conn]*client
 }
 
-var wsHub = &hub{conns: make(map[*websocket.Conn]WSUser)}
+var wsHub = &hub{conns: make(map[*websocket.Conn]*client), gone: make(map[*websocket.Conn]*client)}
 
-func (h *hub) add(c *websocket.Conn, u WSUser) {
+func (h *hub) add(c *websocket.Conn, u WSUser) string {
+	id := primitive.NewObjectID().Hex()
 	h.mu.Lock()
-	h.conns[c] = u
+	h.conns[c] = &client{
+		id:        id,
+		user:      u,
+		replaying: make(map[string][]roomEvent),
+		watching:  make(map[string]bool),
+		blocked:   make(map[string]bool),
+		evicted:   make(map[string]bool),
+		rooms:     make(map[string]bool),
+	}
 	h.mu.Unlock()
+	return id
 }
-func (h *hub) remove(c *websocket.Conn) (u WSUser) {
+func (h *hub) send(c *websocket.Conn, v interface{}) {
 	h.mu.Lock()
-	u = h.conns[c]
-	delete(h.conns, c)
-	h.mu.Unlock()
-	return
+	defer h.mu.Unlock()
+	if _, ok := h.conns[c]; !ok {
+		return // connexion déjà fermée
+	}
+	h.write(c, v)
+}
+
+// write écrit sur la connexion; h.mu doit être tenu.
+func (h *hub) write(c *websocket.Conn, v interface{}) {
+	if err := c.WriteJSON(v); err != nil {
+		log.Printf("WS write error: %v", err)
+		_ = c.Close()
+		if cl, ok := h.conns[c]; ok {
+			h.gone[c] = cl
+		}
+		delete(h.conns, c)
+	}
+}
+
+// deliver écrit l'évènement ou le met en tampon si le salon est en reprise; h.mu doit être tenu.
+// Les messages d'un utilisateur bloqué par la connexion sont écartés.
+func (h *hub) deliver(c *websocket.Conn, cl *client, ev roomEvent) {
+	if a := ev.eventAuthor(); a != "" && cl.blocked[a] {
+		return
+	}
+	if cl.evicted[ev.eventRoom()] {
+		return
+	}
+	if buf, ok := cl.replaying[ev.eventRoom()]; ok {
+		cl.replaying[ev.eventRoom()] = append(buf, ev)
+		return
+	}
+	h.write(c, ev)
 }
 func (h *hub) broadcast(msg WSMessage) {
 	h.mu.Lock()
-	for c := range h.conns {
-		if err := c.WriteJSON(msg); err != nil {
-			log.Printf("WS write error: %v", err)
+	for c, cl := range h.conns {
+		h.deliver(c, cl, msg)
+	}
+	h.mu.Unlock()
+}
+func (h *hub) broadcastExcept(msg WSMessage, except *websocket.Conn) {
+	h.broadcastTo(msg, except, nil)
+}
+
+// sendToUser écrit sur toutes les connexions de l'utilisateur, quel que soit le salon suivi.
+func (h *hub) sendToUser(userID string, v interface{}) {
+	h.mu.Lock()
+	for c, cl := range h.conns {
+		if cl.user.Authenticated && cl.user.ID == userID {
+			h.write(c, v)
+		}
+	}
+	h.mu.Unlock()
+}
+
+// sendMatching écrit sur les connexions dont l'utilisateur passe le filtre match.
+func (h *hub) sendMatching(match func(u WSUser) bool, v interface{}) {
+	h.mu.Lock()
+	for c, cl := range h.conns {
+		if match(cl.user) {
+			h.write(c, v)
+		}
+	}
+	h.mu.Unlock()
+}
+
+// disconnectMatching ferme les connexions dont l'utilisateur passe le filtre match;
+// la boucle de lecture de chaque connexion fait ensuite le ménage habituel.
+func (h *hub) disconnectMatching(match func(u WSUser) bool, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	deadline := time.Now().Add(time.Second)
+	h.mu.Lock()
+	for c, cl := range h.conns {
+		if match(cl.user) {
+			_ = c.WriteControl(websocket.CloseMessage, msg, deadline)
 			_ = c.Close()
-			delete(h.conns, c)
 		}
 	}
 	h.mu.Unlock()
 }
-func (h *hub) broadcastExcept(msg WSMessage, except *websocket.Conn) {
+
+// evict retire du salon les connexions dont l'utilisateur passe le filtre match
+// (évènement room.evicted, plus rien n'est remis du salon jusqu'à un nouvel
+// abonnement); la connexion reste ouverte pour les autres salons.
+func (h *hub) evict(room string, match func(u WSUser) bool, reason string) {
+	ev := WSEvent{Type: "room.evicted", Room: room, Data: gin.H{"reason": reason}, Timestamp: time.Now().UTC()}
 	h.mu.Lock()
-	for c := range h.conns {
-		if c == except {
+	for c, cl := range h.conns {
+		if match(cl.user) {
+			delete(cl.replaying, room)
+			delete(cl.rooms, room)
+			cl.evicted[room] = true
+			h.write(c, ev)
+		}
+	}
+	h.mu.Unlock()
+}
+
+// rejoin rouvre le salon à la connexion après une éviction (reprise).
+func (h *hub) rejoin(c *websocket.Conn, room string) {
+	h.mu.Lock()
+	if cl, ok := h.conns[c]; ok {
+		delete(cl.evicted, room)
+	}
+	h.mu.Unlock()
+}
+
+// sameUser: même compte, ou même pseudonyme d'invité.
+func sameUser(a, b WSUser) bool {
+	if a.Authenticated != b.Authenticated {
+		return false
+	}
+	if a.Authenticated {
+		return a.ID == b.ID
+	}
+	return a.Username == b.Username
+}
+
+// followedLocked: une autre connexion de u que except suit room; h.mu doit être tenu.
+func (h *hub) followedLocked(u WSUser, room string, except *websocket.Conn) bool {
+	for c, cl := range h.conns {
+		if c != except && cl.rooms[room] && sameUser(cl.user, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe ajoute room aux salons suivis par la connexion et lève une éviction;
+// first: aucune connexion de l'utilisateur ne le suivait encore (join).
+func (h *hub) subscribe(c *websocket.Conn, room string) (first bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.conns[c]
+	if !ok {
+		return false
+	}
+	delete(cl.evicted, room)
+	if cl.rooms[room] {
+		return false
+	}
+	first = !h.followedLocked(cl.user, room, c)
+	cl.rooms[room] = true
+	return first
+}
+
+// unsubscribe retire room des salons suivis; last: plus aucune connexion de
+// l'utilisateur ne le suit (leave).
+func (h *hub) unsubscribe(c *websocket.Conn, room string) (last bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.conns[c]
+	if !ok || !cl.rooms[room] {
+		return false
+	}
+	delete(cl.rooms, room)
+	return !h.followedLocked(cl.user, room, c)
+}
+
+// leaveAll retire la connexion du hub; rooms: salons qu'aucune autre connexion
+// de l'utilisateur ne suit (leave).
+func (h *hub) leaveAll(c *websocket.Conn) (u WSUser, rooms []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.conns[c]
+	if !ok {
+		cl, ok = h.gone[c]
+	}
+	delete(h.gone, c)
+	if !ok {
+		return u, nil
+	}
+	u = cl.user
+	for room := range cl.rooms {
+		if !h.followedLocked(u, room, c) {
+			rooms = append(rooms, room)
+		}
+	}
+	delete(h.conns, c)
+	return u, rooms
+}
+
+// anyMatching dit si une connexion ouverte passe le filtre match.
+func (h *hub) anyMatching(match func(u WSUser) bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.conns {
+		if match(cl.user) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriberIDs liste les utilisateurs authentifiés dont une connexion suit room
+// et qui passent le filtre allow (nil: tous).
+func (h *hub) subscriberIDs(room string, allow func(u WSUser) bool) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := map[string]bool{}
+	var ids []string
+	for _, cl := range h.conns {
+		u := cl.user
+		if !cl.rooms[room] || !u.Authenticated || u.ID == "" || seen[u.ID] || (allow != nil && !allow(u)) {
 			continue
 		}
-		if err := c.WriteJSON(msg); err != nil {
-			log.Printf("WS write error: %v", err)
-			_ = c.Close()
-			delete(h.conns, c)
+		seen[u.ID] = true
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+// sendToWatchers écrit aux connexions qui suivent la présence de userID.
+func (h *hub) sendToWatchers(userID string, v interface{}) {
+	h.mu.Lock()
+	for c, cl := range h.conns {
+		if cl.watching[userID] {
+			h.write(c, v)
+		}
+	}
+	h.mu.Unlock()
+}
+
+func (h *hub) setWatching(c *websocket.Conn, users []string) {
+	h.mu.Lock()
+	if cl, ok := h.conns[c]; ok {
+		cl.watching = make(map[string]bool, len(users))
+		for _, id := range users {
+			cl.watching[id] = true
+		}
+	}
+	h.mu.Unlock()
+}
+
+type localConnection struct {
+	userID, connID string
+	rooms          []string
+}
+
+// localConnections liste les connexions authentifiées portées par cette instance
+// et les salons qu'elles suivent.
+func (h *hub) localConnections() []localConnection {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]localConnection, 0, len(h.conns))
+	for _, cl := range h.conns {
+		if cl.user.Authenticated && cl.user.ID != "" {
+			lc := localConnection{userID: cl.user.ID, connID: cl.id}
+			for room := range cl.rooms {
+				lc.rooms = append(lc.rooms, room)
+			}
+			out = append(out, lc)
 		}
 	}
+	return out
+}
+
+// broadcastTo diffuse aux connexions dont l'utilisateur passe le filtre allow (nil: toutes).
+func (h *hub) broadcastTo(ev roomEvent, except *websocket.Conn, allow func(u WSUser) bool) {
+	h.mu.Lock()
+	for c, cl := range h.conns {
+		if c == except || (allow != nil && !allow(cl.user)) {
+			continue
+		}
+		h.deliver(c, cl, ev)
+	}
 	h.mu.Unlock()
 }
 
@@ -117,7 +476,10 @@ func extractUserFromRequest(r *http.Request) WSUser {
 			u.ID = user.ID.Hex()
 		}
 		u.Email = user.Email
-		u.Avatar = user.Avatar
+		u.Avatar = auth.AvatarURL(user)
+		if u.Avatar == "" {
+			u.Avatar = auth.DefaultAvatarURL(u.ID)
+		}
 		return u
 	}
 
@@ -155,17 +517,37 @@ func extractUserFromRequest(r *http.Request) WSUser {
 	}
 
 	log.Printf("[WS Auth] guest")
-	return WSUser{Username: "Invité", Authenticated: false}
+	return WSUser{Username: "Invité", Guest: true}
 }
 
 // ---------- Persistence async (non-bloquante) ----------
 
 type persistItem struct {
-	UserID    string
-	Username  string
-	Room      string
-	Text      string
-	Timestamp time.Time
+	ID           primitive.ObjectID
+	UserID       string
+	Username     string
+	Room         string
+	Text         string
+	Timestamp    time.Time
+	ClientMsgID  string
+	ReplyTo      string
+	ThreadRoot   string
+	Flags        []string // motifs de signalement des filtres: file de revue après stockage
+	Redacted     bool     // texte expurgé par les filtres: renvoyé dans l'ack
+	Attachments  []models.AttachmentRef
+	Announcement bool // annonce d'un modérateur (POST /api/rooms/:room/announcements)
+	Persistent   bool
+	Poll         *models.Poll    // sondage (POST /api/rooms/:room/polls)
+	Command      string          // réponse publique d'une commande slash ("me", ...)
+	Conn         *websocket.Conn // émetteur: reçoit l'ack, exclu de la diffusion
+	ReleaseSlot  func()          // rend le créneau de slow mode si le message n'est pas stocké
+}
+
+// releaseSlot: un message non stocké (doublon compris) ne coûte pas l'intervalle.
+func (it persistItem) releaseSlot() {
+	if it.ReleaseSlot != nil {
+		it.ReleaseSlot()
+	}
 }
 
 var (
@@ -173,106 +555,303 @@ var (
 	persistWorkerOnce sync.Once
 )
 
+// authorKey identifie l'auteur pour la déduplication (user, client_msg_id).
+// Les invités n'ont pas d'id: on retombe sur leur nom affiché.
+func authorKey(userID, username string) string {
+	if userID != "" {
+		return userID
+	}
+	return "guest:" + username
+}
+
 func startPersistenceWorker() {
 	persistWorkerOnce.Do(func() {
 		go func() {
 			for it := range persistQueue {
-				// Construit le document à insérer.
-				// On conserve les champs du modèle existant (sender/content/created_at)
-				// et on ajoute user_id/username/room pour requêtes futures.
-				doc := bson.M{
-					"sender":         it.Username,                                 // compat: nom de l'expéditeur
-					"content":        it.Text,                                     // compat
-					"created_at":     primitive.NewDateTimeFromTime(it.Timestamp), // compat
-					"user_id":        it.UserID,                                   // nouvel attribut
-					"username":       it.Username,                                 // redondant mais pratique
-					"room":           it.Room,                                     // salon
-					"created_at_iso": it.Timestamp,                                // lecture humaine si besoin
-				}
-
-				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-				_, err := db.MessagesCol.InsertOne(ctx, doc)
-				cancel()
-				if err != nil {
-					log.Printf("persist message failed: %v", err)
-				}
+				persistAndDeliver(it)
 			}
 		}()
 	})
 }
 
-// ------------------------------------------------------
-
-func RegisterWS(router *gin.Engine) {
-	// Démarre le worker de persistance une seule fois
-	startPersistenceWorker()
+// findDuplicate renvoie le message déjà stocké pour (auteur, client_msg_id), s'il existe.
+func findDuplicate(ctx context.Context, key, clientMsgID string) (models.Message, bool) {
+	var existing models.Message
+	err := db.MessagesCol.FindOne(ctx, bson.M{"author_key": key, "client_msg_id": clientMsgID}).Decode(&existing)
+	return existing, err == nil
+}
 
-	// Endpoint REST pour charger l'historique par room
-	router.GET("/api/messages", func(c *gin.Context) {
-		room := c.Query("room")
-		if room == "" {
-			room = "general"
-		}
-		limit := int64(100)
-		if s := c.Query("limit"); s != "" {
-			if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 500 {
-				limit = int64(n)
-			}
-		}
+func ackDuplicate(it persistItem, existing models.Message) {
+	ts := existing.CreatedAt.Time().UTC()
+	wsHub.send(it.Conn, WSAck{
+		Type:        "ack",
+		ClientMsgID: it.ClientMsgID,
+		ID:          existing.ID.Hex(),
+		Timestamp:   &ts,
+		Room:        existing.Room,
+		Seq:         existing.Seq,
+		Duplicate:   true,
+	})
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		defer cancel()
+// persistAndDeliver stocke le message puis le diffuse et l'acquitte.
+// La diffusion n'a lieu qu'une fois le message stocké: un renvoi après
+// reconnexion (même client_msg_id) est acquitté sans être rediffusé.
+func persistAndDeliver(it persistItem) {
+	key := authorKey(it.UserID, it.Username)
 
-		opts := options.Find().
-			SetSort(bson.D{{Key: "created_at", Value: 1}}). // ancien -> récent
-			SetLimit(limit)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-		cur, err := db.MessagesCol.Find(ctx, bson.M{"room": room}, opts)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	if it.ClientMsgID != "" {
+		if existing, ok := findDuplicate(ctx, key, it.ClientMsgID); ok {
+			it.releaseSlot()
+			ackDuplicate(it, existing)
 			return
 		}
-		defer func(cur *mongo.Cursor, ctx context.Context) {
-			err := cur.Close(ctx)
-			if err != nil {
+	}
 
-			}
-		}(cur, ctx)
+	quote, threadRoot, err := resolveThreading(ctx, it.Room, it.ReplyTo, it.ThreadRoot)
+	if err != nil {
+		it.releaseSlot()
+		if it.ClientMsgID != "" {
+			wsHub.send(it.Conn, WSAck{Type: "nack", ClientMsgID: it.ClientMsgID, Reason: err.Error()})
+		}
+		return
+	}
 
-		type msgDoc struct {
-			ID        primitive.ObjectID `bson:"_id"`
-			Sender    string             `bson:"sender"`
-			Content   string             `bson:"content"`
-			Room      string             `bson:"room"`
-			CreatedAt primitive.DateTime `bson:"created_at"`
+	// Numéro de séquence du salon, attribué au moment de la persistance.
+	seq, err := nextRoomSeq(ctx, it.Room)
+	if err != nil {
+		log.Printf("persist message failed (seq): %v", err)
+		it.releaseSlot()
+		if it.ClientMsgID != "" {
+			wsHub.send(it.Conn, WSAck{Type: "nack", ClientMsgID: it.ClientMsgID, Reason: nackStoreFailed})
 		}
+		return
+	}
 
-		out := make([]gin.H, 0, limit)
-		for cur.Next(ctx) {
-			var doc msgDoc
-			if err := cur.Decode(&doc); err != nil {
-				continue
+	msg := models.Message{
+		ID:           it.ID,
+		Sender:       it.Username,
+		Content:      it.Text,
+		CreatedAt:    primitive.NewDateTimeFromTime(it.Timestamp),
+		UserID:       it.UserID,
+		Username:     it.Username,
+		Room:         it.Room,
+		Seq:          seq,
+		CreatedAtISO: it.Timestamp,
+		AuthorKey:    key,
+		ClientMsgID:  it.ClientMsgID,
+		ReplyTo:      it.ReplyTo,
+		Quote:        quote,
+		ThreadRoot:   threadRoot,
+		Attachments:  it.Attachments,
+		Announcement: it.Announcement,
+		Persistent:   it.Persistent,
+		Poll:         it.Poll,
+		Command:      it.Command,
+	}
+	resolveMentions(ctx, &msg)
+
+	// Les fichiers sont pris avant l'insertion: deux messages ne peuvent pas se les partager.
+	if err := claimAttachments(ctx, msg); err != nil {
+		log.Printf("claim attachments %s: %v", msg.ID.Hex(), err)
+		it.releaseSlot()
+		if it.ClientMsgID != "" {
+			wsHub.send(it.Conn, WSAck{Type: "nack", ClientMsgID: it.ClientMsgID, Room: it.Room, Reason: nackAttachment})
+		}
+		return
+	}
+
+	if _, err := db.MessagesCol.InsertOne(ctx, msg); err != nil {
+		it.releaseSlot()
+		if len(msg.Attachments) > 0 {
+			releaseAttachments(ctx, msg.ID.Hex())
+		}
+		// Course avec une autre instance: l'index unique a tranché.
+		if it.ClientMsgID != "" && mongo.IsDuplicateKeyError(err) {
+			if existing, ok := findDuplicate(ctx, key, it.ClientMsgID); ok {
+				ackDuplicate(it, existing)
+				return
 			}
-			out = append(out, gin.H{
-				"id":        doc.ID.Hex(),
-				"username":  doc.Sender,
-				"text":      doc.Content,
-				"timestamp": doc.CreatedAt.Time().UTC().Format(time.RFC3339),
-				"room":      doc.Room,
-			})
-		}
-		if err := cur.Err(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
-			return
 		}
+		log.Printf("persist message failed: %v", err)
+		if it.ClientMsgID != "" {
+			wsHub.send(it.Conn, WSAck{Type: "nack", ClientMsgID: it.ClientMsgID, Reason: nackStoreFailed})
+		}
+		return
+	}
+	syncPreviews(ctx, &msg)
 
-		c.JSON(http.StatusOK, out)
-	})
+	if err := currentSearch().Index(ctx, msg); err != nil {
+		log.Printf("search index failed: %v", err)
+	}
+	if len(it.Flags) > 0 {
+		flagForReview(ctx, msg, it.Flags, false)
+	}
+
+	// Diffuse en temps réel (sauf à l'émetteur, qui gère un écho local côté client),
+	// uniquement aux lecteurs autorisés du salon.
+	allow, err := roomAudience(ctx, it.Room)
+	if err != nil {
+		log.Printf("room audience %s: %v", it.Room, err)
+		return
+	}
+	wsHub.broadcastTo(toWSMessage(msg), it.Conn, allow)
+	emitWebhook(msg.Room, hookMessage, toWSMessage(msg))
+	if msg.ThreadRoot != "" {
+		bumpThread(ctx, msg)
+	}
+	go notifyMentions(msg)
+
+	// Le message envoyé clôt l'éventuelle saisie en cours.
+	stopTyping(ctx, typingSignal{Room: msg.Room, UserKey: key, UserID: msg.UserID, Username: msg.Sender})
+
+	if it.ClientMsgID != "" {
+		ts := it.Timestamp
+		ack := WSAck{
+			Type:        "ack",
+			ClientMsgID: it.ClientMsgID,
+			ID:          it.ID.Hex(),
+			Timestamp:   &ts,
+			Room:        it.Room,
+			Seq:         seq,
+		}
+		if it.Redacted {
+			ack.Text = msg.Content
+		}
+		wsHub.send(it.Conn, ack)
+	}
+}
+
+// ------------------------------------------------------
+
+func RegisterWS(router *gin.Engine) {
+	// Démarre le worker de persistance une seule fois
+	startPersistenceWorker()
+	// Relais Redis des indicateurs de saisie et de la présence
+	startTyping()
+	startPresence()
+	// Écriture groupée des positions de lecture
+	startReadFlusher()
+	// Relais des blocages entre instances
+	startBlocks()
+	// Stockage des fichiers choisi au démarrage: une configuration invalide s'arrête ici
+	currentBlobStore()
+	// Collecte des pièces jointes orphelines
+	startAttachmentGC()
+	// Vignettes et empreintes des images
+	startThumbnailer()
+	// Messages programmés et rappels
+	startScheduler()
+	// Clôture des sondages échus
+	startPollCloser()
+	// Envoi des webhooks sortants
+	startWebhooks()
+
+	// Endpoint REST pour charger l'historique par room (pagination par curseur)
+	router.GET("/api/messages", historyHandler)
+
+	// Recherche plein texte (droits de l'appelant appliqués)
+	router.GET("/api/search/messages", searchHandler)
+
+	// Routes authentifiées: salons, conversations privées, édition/suppression
+	protected := router.Group("/api")
+	protected.Use(auth.AuthRequired)
+	{
+		protected.GET("/rooms", listRoomsHandler)
+		protected.POST("/rooms", createRoomHandler)
+		protected.POST("/rooms/:room/members", addMemberHandler)
+		protected.POST("/dm", dmHandler)
+		protected.PATCH("/messages/:id", editMessageHandler)
+		protected.DELETE("/messages/:id", deleteMessageHandler)
+		protected.POST("/messages/:id/reactions", addReactionHandler)
+		protected.DELETE("/messages/:id/reactions/:emoji", removeReactionHandler)
+		protected.PUT("/rooms/:room/settings", roomSettingsHandler)
+		protected.GET("/notifications", notificationsHandler)
+		protected.POST("/notifications/read", markNotificationsReadHandler)
+		protected.PUT("/presence/status", presenceStatusHandler)
+		protected.GET("/rooms/unread", unreadHandler)
+		protected.POST("/rooms/:room/read", markReadHandler)
+		protected.POST("/rooms/:room/moderation", moderateHandler)
+		protected.GET("/rooms/:room/moderation", moderationLogHandler)
+		protected.POST("/rooms/:room/moderators", roomModeratorHandler)
+		protected.PUT("/rooms/:room/slow-mode", slowModeHandler)
+		protected.PUT("/rooms/:room/guests", guestAccessHandler)
+		protected.GET("/moderation/reviews", reviewsHandler)
+		protected.POST("/moderation/reviews/:id", resolveReviewHandler)
+		protected.POST("/reports", createReportHandler)
+		protected.GET("/moderation/reports", listReportsHandler)
+		protected.PATCH("/moderation/reports/:id", updateReportHandler)
+		protected.GET("/blocks", listBlocksHandler)
+		protected.POST("/blocks", blockHandler)
+		protected.DELETE("/blocks/:user", unblockHandler)
+		protected.POST("/attachments", uploadAttachmentHandler)
+		protected.GET("/attachments/:id/url", attachmentURLHandler)
+		protected.DELETE("/attachments/:id", deleteAttachmentHandler)
+		protected.POST("/messages/:id/pin", pinHandler)
+		protected.DELETE("/messages/:id/pin", unpinHandler)
+		protected.POST("/rooms/:room/announcements", announceHandler)
+		protected.DELETE("/rooms/:room/announcements/:id", dismissAnnouncementHandler)
+		protected.POST("/me/avatar", uploadAvatarHandler)
+		protected.DELETE("/me/avatar", deleteAvatarHandler)
+		protected.GET("/scheduled", listScheduledHandler)
+		protected.POST("/scheduled", createScheduledHandler)
+		protected.PATCH("/scheduled/:id", updateScheduledHandler)
+		protected.DELETE("/scheduled/:id", cancelScheduledHandler)
+		protected.POST("/messages/:id/remind", remindHandler)
+		protected.POST("/rooms/:room/polls", createPollHandler)
+		protected.POST("/messages/:id/poll/votes", voteHandler)
+		protected.POST("/messages/:id/poll/close", closePollHandler)
+		protected.GET("/rooms/:room/commands", listCommandsHandler)
+		protected.POST("/rooms/:room/commands", createCommandHandler)
+		protected.DELETE("/rooms/:room/commands/:name", deleteCommandHandler)
+		protected.GET("/commands", listCommandsHandler)
+		protected.POST("/commands", createCommandHandler)
+		protected.DELETE("/commands/:name", deleteCommandHandler)
+		protected.GET("/rooms/:room/webhooks", listWebhooksHandler)
+		protected.POST("/rooms/:room/webhooks", createWebhookHandler)
+		protected.PATCH("/rooms/:room/webhooks/:id", updateWebhookHandler)
+		protected.DELETE("/rooms/:room/webhooks/:id", deleteWebhookHandler)
+		protected.GET("/rooms/:room/webhooks/:id/deliveries", deliveriesHandler)
+		protected.POST("/rooms/:room/webhooks/:id/deliveries/:delivery/retry", redeliverHandler)
+	}
+	router.GET("/api/attachments/:id", downloadAttachmentHandler)
+	router.GET("/api/attachments/:id/thumbnails/:size", thumbnailHandler)
+	router.GET("/api/avatars/:id", avatarHandler)
+	router.GET("/api/messages/:id/reactions", reactorsHandler)
+	router.GET("/api/messages/:id/thread", threadHandler)
+	router.GET("/api/rooms/:room/typing", typingSnapshotHandler)
+	router.GET("/api/presence", presenceHandler)
+	router.GET("/api/rooms/:room/roster", rosterHandler)
+	router.GET("/api/rooms/:room/pins", listPinsHandler)
+	router.GET("/api/messages/:id/poll", pollHandler)
 
 	// WebSocket temps réel
 	router.GET("/ws", func(c *gin.Context) {
 		log.Printf("[WS] Handshake from %s UA=%s", c.ClientIP(), c.Request.UserAgent())
 
+		// Identité avant l'upgrade: un invité refusé reçoit un 401/503 HTTP.
+		user := extractUserFromRequest(c.Request)
+		user.IP = c.ClientIP()
+		if !user.Authenticated {
+			if guestMode() == guestDisabled {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "guests disabled"})
+				return
+			}
+			handle, err := assignGuestHandle(c)
+			if err != nil {
+				log.Printf("guest handle: %v", err)
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no guest handle"})
+				return
+			}
+			user.Username = handle
+			user.Avatar = auth.DefaultAvatarURL(handle)
+			// Le pseudonyme a pu changer depuis (/nick): on libère le dernier.
+			defer func() { releaseGuestHandle(user.Username) }()
+		}
+
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			log.Printf("WS upgrade error: %v", err)
@@ -280,24 +859,44 @@ func RegisterWS(router *gin.Engine) {
 		}
 		log.Printf("WS connected: %s", c.ClientIP())
 
-		user := extractUserFromRequest(c.Request)
-		wsHub.add(conn, user)
+		blocked, err := blockedIDs(c, user.ID)
+		if err != nil {
+			log.Printf("load blocks %s: %v", user.ID, err)
+		}
+		connID := wsHub.add(conn, user)
+		wsHub.loadBlocks(conn, blocked)
+		presenceConnected(user, connID)
 		wsHub.broadcast(WSMessage{
 			Username:  "Serveur",
 			Text:      user.Username + " a rejoint le salon.",
 			Timestamp: time.Now(),
 			Room:      "general",
 		})
+		if wsHub.subscribe(conn, "general") {
+			presenceJoined(user, "general")
+			emitWebhook("general", hookJoin, memberEvent(user, "connect"))
+		}
 
-		for {
-			var in struct {
-				Text     string `json:"text"`
-				Room     string `json:"room"`
-				Username string `json:"username"` // facultatif, on privilégie l'identité auth
+		// Abonnement dès la poignée de main: /ws?room=... (instantané), &resume=<seq|id> (reprise)
+		if room := c.Query("room"); room != "" && validRoomName(room) && canReadRoom(c, user, room) {
+			subscribeRoom(conn, user, room)
+		}
+		if resume := c.Query("resume"); resume != "" {
+			if room := c.Query("room"); room == "" || validRoomName(room) && canReadRoom(c, user, room) {
+				resumeRoom(conn, room, resume)
+			} else {
+				wsHub.send(conn, WSError{Type: "error", Code: "forbidden", Room: room})
 			}
+		}
+
+		for {
+			var in wsInbound
 			if err := conn.ReadJSON(&in); err != nil {
-				left := wsHub.remove(conn)
+				left, rooms := wsHub.leaveAll(conn)
 				_ = conn.Close()
+				typingDisconnected(conn)
+				presenceDisconnected(user, connID)
+				presenceLeft(left, rooms...)
 				log.Printf("WS closed: %s (user=%s)", c.ClientIP(), left.Username)
 				wsHub.broadcast(WSMessage{
 					Username:  "Serveur",
@@ -305,45 +904,14 @@ func RegisterWS(router *gin.Engine) {
 					Timestamp: time.Now(),
 					Room:      "general",
 				})
-				return
-			}
-
-			room := in.Room
-			if room == "" {
-				room = "general"
-			}
-			// Identité: priorité à l'utilisateur authentifié
-			sender := user.Username
-			if sender == "" || sender == "Invité" {
-				if in.Username != "" {
-					sender = in.Username
-				} else {
-					sender = "Invité"
+				for _, room := range rooms {
+					emitWebhook(room, hookLeave, memberEvent(left, "disconnect"))
 				}
+				return
 			}
-
-			ts := time.Now().UTC()
-
-			// Diffuse en temps réel (sauf à l'émetteur, qui gère un écho local côté client)
-			wsHub.broadcastExcept(WSMessage{
-				Username:  sender,
-				Text:      in.Text,
-				Timestamp: ts,
-				Room:      room,
-			}, conn)
-
-			// Persistance asynchrone: ne bloque pas le flux WS.
-			select {
-			case persistQueue <- persistItem{
-				UserID:    user.ID, // vide si invité
-				Username:  sender,  // username affiché
-				Room:      room,
-				Text:      in.Text,
-				Timestamp: ts,
-			}:
-			default:
-				// File pleine: on drop et on log (stratégie simple, à ajuster si besoin)
-				log.Printf("persist queue full: dropping message from user=%s", sender)
+			handleInbound(conn, connID, user, in)
+			if u, ok := wsHub.userOf(conn); ok {
+				user = u // /nick
 			}
 		}
 	})
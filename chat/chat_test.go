@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuthorKey(t *testing.T) {
+	tests := []struct {
+		userID, username string
+		want             string
+	}{
+		{"64b7f0c2a1b2c3d4e5f60718", "alice", "64b7f0c2a1b2c3d4e5f60718"},
+		{"", "Invité-42", "guest:Invité-42"},
+		{"", "", "guest:"},
+	}
+	for _, tt := range tests {
+		if got := authorKey(tt.userID, tt.username); got != tt.want {
+			t.Errorf("authorKey(%q, %q) = %q; want %q", tt.userID, tt.username, got, tt.want)
+		}
+	}
+}
+
+func TestWSAckJSON(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		ack  WSAck
+		want string
+	}{
+		{
+			"ack",
+			WSAck{Type: "ack", ClientMsgID: "c1", ID: "m1", Timestamp: &at, Room: "general", Seq: 7},
+			`{"type":"ack","client_msg_id":"c1","id":"m1","timestamp":"2026-01-02T03:04:05Z","room":"general","seq":7}`,
+		},
+		{
+			"doublon",
+			WSAck{Type: "ack", ClientMsgID: "c1", ID: "m1", Room: "general", Seq: 7, Duplicate: true},
+			`{"type":"ack","client_msg_id":"c1","id":"m1","room":"general","seq":7,"duplicate":true}`,
+		},
+		{
+			"nack",
+			WSAck{Type: "nack", ClientMsgID: "c2", Reason: nackStoreFailed},
+			`{"type":"nack","client_msg_id":"c2","reason":"` + nackStoreFailed + `"}`,
+		},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.ack)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s: json = %s; want %s", tt.name, b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,729 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Louis-Bouhours/ecrireback/auth"
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Commandes slash. Un message qui commence par "/" est une commande, traitée dans
+// la boucle /ws avant toute persistance ou diffusion; "//" échappe le premier "/".
+// Chaque commande vérifie ses droits. La réponse est éphémère (évènement
+// command.reply à la seule connexion appelante) ou publique (message posté au nom
+// de l'appelant avec les contrôles habituels, Message.Command renseigné).
+// Les commandes externes (collection commands) sont transmises en POST signé,
+// hors de la boucle /ws.
+const (
+	maxTopicLength = 250
+
+	commandTimeout  = 5 * time.Second
+	maxCommandReply = 64 << 10
+
+	// En-têtes des appels sortants: signature = "sha256=" + HMAC-SHA256(secret, timestamp + "." + corps)
+	signatureHeader = "X-Ecrire-Signature"
+	timestampHeader = "X-Ecrire-Timestamp"
+)
+
+var (
+	commandNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+	nickRe        = regexp.MustCompile(`^[A-Za-z0-9_-]{2,20}$`)
+	commandClient = newOutboundClient(commandTimeout)
+)
+
+// commandCall est une invocation "/name args" dans room.
+type commandCall struct {
+	conn *websocket.Conn
+	user WSUser
+	room string
+	name string
+	args string
+	in   wsInbound // trame d'origine (client_msg_id, réponse, fil)
+}
+
+// commandReply: Public poste Text dans le salon, sinon seul l'appelant le reçoit.
+type commandReply struct {
+	Text   string
+	Public bool
+}
+
+// commandFailure est un refus expliqué à l'appelant.
+type commandFailure struct {
+	code string
+	text string
+}
+
+func (f *commandFailure) Error() string { return f.code }
+
+func commandFail(code, text string) error { return &commandFailure{code: code, text: text} }
+
+// slashCommand est une commande intégrée; allowed (facultatif) dit qui peut
+// l'appeler, et donc qui la voit dans /help. public: la commande a des effets
+// visibles des autres (message, annonce) et est refusée aux bannis et muets du salon.
+type slashCommand struct {
+	usage   string
+	help    string
+	public  bool
+	allowed func(ctx context.Context, call commandCall) bool
+	run     func(ctx context.Context, call commandCall) (commandReply, error)
+}
+
+var builtinCommands map[string]slashCommand
+
+func init() {
+	builtinCommands = map[string]slashCommand{
+		"me":     {usage: "/me <action>", help: "décrit une action à la troisième personne", public: true, run: meCommand},
+		"nick":   {usage: "/nick <pseudo>", help: "change le pseudonyme d'un invité", public: true, allowed: guestOnly, run: nickCommand},
+		"topic":  {usage: "/topic [sujet | -]", help: "affiche ou change le sujet du salon (modérateurs)", run: topicCommand},
+		"invite": {usage: "/invite @utilisateur", help: "ajoute un membre au salon privé (propriétaire)", allowed: accountOnly, run: inviteCommand},
+		"mute":   {usage: "/mute @utilisateur [durée] [motif]", help: "réduit au silence dans le salon (modérateurs)", allowed: moderatorOnly, run: muteCommand},
+		"help":   {usage: "/help", help: "liste les commandes disponibles", run: helpCommand},
+	}
+}
+
+func guestOnly(_ context.Context, call commandCall) bool { return call.user.Guest }
+
+func accountOnly(_ context.Context, call commandCall) bool {
+	return call.user.Authenticated && call.user.ID != ""
+}
+
+func moderatorOnly(ctx context.Context, call commandCall) bool {
+	return canModerate(ctx, call.user, call.room)
+}
+
+// parseCommand découpe "/name args"; ok est faux pour un texte ordinaire ou échappé ("//").
+func parseCommand(text string) (name, args string, ok bool) {
+	if !strings.HasPrefix(text, "/") || strings.HasPrefix(text, "//") {
+		return "", "", false
+	}
+	name = strings.TrimSpace(text[1:])
+	if i := strings.IndexFunc(name, unicode.IsSpace); i >= 0 {
+		name, args = name[:i], name[i:]
+	}
+	if name == "" {
+		return "", "", false
+	}
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// runCommand exécute la commande; une commande externe part dans sa propre goroutine.
+// Les sanctions sont vérifiées avant tout effet public: une commande externe
+// appelle un service tiers qui peut répondre dans le salon.
+func runCommand(call commandCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd, ok := builtinCommands[call.name]
+	if !ok {
+		ext, err := findExternalCommand(ctx, call.name, call.room)
+		if err == nil {
+			err = sanctionedCommand(ctx, call)
+		}
+		if err != nil {
+			if errors.Is(err, errNotFound) {
+				err = commandFail("unknown_command", "Commande inconnue : /"+call.name+". Tapez /help.")
+			}
+			commandError(call, "", err)
+			return
+		}
+		go dispatchExternal(ext, call)
+		return
+	}
+	if cmd.allowed != nil && !cmd.allowed(ctx, call) {
+		commandError(call, cmd.usage, errForbidden)
+		return
+	}
+	if cmd.public {
+		if err := sanctionedCommand(ctx, call); err != nil {
+			commandError(call, cmd.usage, err)
+			return
+		}
+	}
+	reply, err := cmd.run(ctx, call)
+	if err != nil {
+		commandError(call, cmd.usage, err)
+		return
+	}
+	deliverReply(call, reply)
+}
+
+// sanctionedCommand refuse la commande à un banni ou un muet du salon.
+func sanctionedCommand(ctx context.Context, call commandCall) error {
+	reason, until := sanctionReason(ctx, call.user, call.room)
+	return sanctionFailure(reason, until)
+}
+
+func sanctionFailure(reason, until string) error {
+	switch reason {
+	case nackBanned:
+		return commandFail(reason, "Vous êtes banni de ce salon.")
+	case nackMuted:
+		if until != "" {
+			return commandFail(reason, "Vous êtes réduit au silence dans ce salon jusqu'à "+until+".")
+		}
+		return commandFail(reason, "Vous êtes réduit au silence dans ce salon.")
+	}
+	return nil
+}
+
+// deliverReply remet la réponse; publique, elle suit le chemin d'un message ordinaire
+// (sanctions, mode lent, filtres, persistance et ack).
+func deliverReply(call commandCall, reply commandReply) {
+	if strings.TrimSpace(reply.Text) == "" {
+		return
+	}
+	if !reply.Public {
+		sendCommandReply(call, reply.Text, "")
+		return
+	}
+	in := call.in
+	in.Text, in.Room, in.command = reply.Text, call.room, call.name
+	handleChatMessage(call.conn, call.user, in)
+}
+
+func sendCommandReply(call commandCall, text, code string) {
+	data := gin.H{"command": call.name, "text": text}
+	if code != "" {
+		data["error"] = code
+	}
+	if call.in.ClientMsgID != "" {
+		data["client_msg_id"] = call.in.ClientMsgID
+	}
+	wsHub.send(call.conn, WSEvent{Type: "command.reply", Room: call.room, Data: data, Timestamp: time.Now().UTC()})
+}
+
+func commandError(call commandCall, usage string, err error) {
+	var f *commandFailure
+	switch {
+	case errors.As(err, &f):
+		sendCommandReply(call, f.text, f.code)
+	case errors.Is(err, errInvalid) && usage != "":
+		sendCommandReply(call, "Usage : "+usage, errorCode(err))
+	case errors.Is(err, errForbidden):
+		sendCommandReply(call, "Vous n'avez pas le droit d'utiliser /"+call.name+" ici.", errorCode(err))
+	default:
+		if errorCode(err) == "server_error" {
+			log.Printf("command /%s: %v", call.name, err)
+		}
+		sendCommandReply(call, "La commande /"+call.name+" a échoué.", errorCode(err))
+	}
+}
+
+func meCommand(_ context.Context, call commandCall) (commandReply, error) {
+	if call.args == "" {
+		return commandReply{}, errInvalid
+	}
+	return commandReply{Text: call.args, Public: true}, nil
+}
+
+// nickCommand renomme l'invité: le préfixe des invités est conservé, ce qui
+// interdit d'usurper un compte; l'unicité passe par la même réservation Redis.
+func nickCommand(ctx context.Context, call commandCall) (commandReply, error) {
+	if !nickRe.MatchString(call.args) {
+		return commandReply{}, errInvalid
+	}
+	handle := auth.GuestPrefix + call.args
+	if handle == call.user.Username {
+		return commandReply{Text: "Vous vous appelez déjà " + handle + "."}, nil
+	}
+	ok, err := db.Rdb.SetNX(ctx, guestHandleKey(handle), 1, guestHandleTTL).Result()
+	if err != nil {
+		return commandReply{}, err
+	}
+	taken := commandFail("nick_taken", "Le pseudo "+handle+" est déjà pris.")
+	if !ok {
+		return commandReply{}, taken
+	}
+	if n, err := db.UsersCol.CountDocuments(ctx, bson.M{"username": handle}); err != nil || n > 0 {
+		releaseGuestHandle(handle)
+		if err != nil {
+			return commandReply{}, err
+		}
+		return commandReply{}, taken
+	}
+
+	old := call.user.Username
+	u := call.user
+	u.Username, u.Avatar = handle, auth.DefaultAvatarURL(handle)
+	if !wsHub.setUser(call.conn, u) {
+		releaseGuestHandle(handle) // connexion fermée entre-temps
+		return commandReply{}, nil
+	}
+	releaseGuestHandle(old)
+	// Annonce dans le salon de la commande et dans chaque salon suivi par la connexion.
+	rooms := wsHub.roomsOf(call.conn)
+	if !contains(rooms, call.room) {
+		rooms = append(rooms, call.room)
+	}
+	for _, room := range rooms {
+		allow, err := roomAudience(ctx, room)
+		if err != nil {
+			log.Printf("nick notice %s: %v", room, err)
+			continue
+		}
+		wsHub.broadcastTo(WSMessage{
+			Username:  "Serveur",
+			Text:      old + " s'appelle désormais " + handle + ".",
+			Timestamp: time.Now(),
+			Room:      room,
+		}, nil, allow)
+	}
+	return commandReply{Text: "Vous vous appelez désormais " + handle + "."}, nil
+}
+
+// topicCommand affiche le sujet; avec un argument, le change ("-" l'efface).
+func topicCommand(ctx context.Context, call commandCall) (commandReply, error) {
+	if isDMRoom(call.room) {
+		return commandReply{}, commandFail("invalid_room", "Une conversation privée n'a pas de sujet.")
+	}
+	if call.args == "" {
+		r, err := cachedRoom(ctx, call.room)
+		if err != nil {
+			return commandReply{}, err
+		}
+		if r == nil || r.Topic == "" {
+			return commandReply{Text: "Aucun sujet pour " + call.room + "."}, nil
+		}
+		return commandReply{Text: "Sujet de " + call.room + " : " + r.Topic}, nil
+	}
+	if !canModerate(ctx, call.user, call.room) {
+		return commandReply{}, errForbidden
+	}
+	if err := sanctionedCommand(ctx, call); err != nil {
+		return commandReply{}, err
+	}
+	topic := call.args
+	if topic == "-" {
+		topic = ""
+	}
+	if len(topic) > maxTopicLength {
+		return commandReply{}, errInvalid
+	}
+	if topic != "" {
+		filtered := runFilters(ctx, FilterInput{
+			Text:      topic,
+			Room:      call.room,
+			UserID:    call.user.ID,
+			Username:  call.user.Username,
+			AuthorKey: rateSubject(call.user),
+		})
+		if filtered.Rejected {
+			return commandReply{}, errRejected
+		}
+		topic = filtered.Text
+	}
+	if err := ensureRoomDoc(ctx, call.room); err != nil {
+		return commandReply{}, err
+	}
+	update := bson.M{"$set": bson.M{"topic": topic}}
+	if topic == "" {
+		update = bson.M{"$unset": bson.M{"topic": ""}}
+	}
+	if _, err := db.RoomsCol.UpdateOne(ctx, bson.M{"name": call.room}, update); err != nil {
+		return commandReply{}, err
+	}
+	forgetRoom(call.room)
+	publish(ctx, WSEvent{
+		Type:      "room.topic",
+		Room:      call.room,
+		Data:      gin.H{"topic": topic, "by": call.user.Username},
+		Timestamp: time.Now().UTC(),
+	})
+	return commandReply{Text: "Sujet mis à jour."}, nil
+}
+
+// inviteCommand: mêmes droits que POST /api/rooms/:room/members (propriétaire du salon privé).
+func inviteCommand(ctx context.Context, call commandCall) (commandReply, error) {
+	name := strings.TrimPrefix(call.args, "@")
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return commandReply{}, errInvalid
+	}
+	r, err := loadRoom(ctx, call.room)
+	if err != nil {
+		return commandReply{}, err
+	}
+	if r == nil || !r.Private {
+		return commandReply{}, commandFail("not_private", call.room+" est ouvert à tous: pas d'invitation nécessaire.")
+	}
+	if r.OwnerID != call.user.ID {
+		return commandReply{}, errForbidden
+	}
+	var u models.User
+	if err := db.UsersCol.FindOne(ctx, bson.M{"username": name}).Decode(&u); err != nil {
+		return commandReply{}, commandFail("user_not_found", "Utilisateur introuvable : "+name+".")
+	}
+	if _, err := db.RoomsCol.UpdateOne(ctx, bson.M{"_id": r.ID}, bson.M{"$addToSet": bson.M{"members": u.ID.Hex()}}); err != nil {
+		return commandReply{}, err
+	}
+	forgetRoom(call.room)
+	emitWebhook(call.room, hookJoin, memberEvent(WSUser{ID: u.ID.Hex(), Username: u.Username, Authenticated: true}, "invite"))
+	wsHub.sendToUser(u.ID.Hex(), WSEvent{
+		Type:      "room.invited",
+		Room:      call.room,
+		Data:      gin.H{"by": call.user.Username},
+		Timestamp: time.Now().UTC(),
+	})
+	return commandReply{Text: u.Username + " a été ajouté à " + call.room + "."}, nil
+}
+
+// muteCommand: équivalent de l'action mute de POST /api/rooms/:room/moderation.
+// Un invité est désigné par son pseudo s'il est connecté à cette instance.
+func muteCommand(ctx context.Context, call commandCall) (commandReply, error) {
+	fields := strings.Fields(call.args)
+	if len(fields) == 0 {
+		return commandReply{}, errInvalid
+	}
+	ref := strings.TrimPrefix(fields[0], "@")
+	d, rest := defaultMuteDuration, fields[1:]
+	if len(rest) > 0 {
+		if pd, err := time.ParseDuration(rest[0]); err == nil && pd > 0 {
+			d, rest = pd, rest[1:]
+		}
+	}
+
+	t, err := resolveTarget(ctx, ref, "")
+	if err != nil {
+		g, ok := wsHub.guestNamed(ref)
+		if !ok {
+			return commandReply{}, commandFail("user_not_found", "Utilisateur introuvable : "+ref+".")
+		}
+		t = moderationTarget{ip: g.IP}
+	}
+	if t.user.ID != "" && (t.user.ID == call.user.ID || canModerate(ctx, t.user, call.room)) {
+		return commandReply{}, commandFail("forbidden", "Impossible de réduire au silence un modérateur.")
+	}
+
+	exp := primitive.NewDateTimeFromTime(time.Now().Add(d))
+	a := models.ModerationAction{
+		Type:         modMute,
+		Room:         call.room,
+		ActorID:      call.user.ID,
+		ActorName:    call.user.Username,
+		TargetUserID: t.user.ID,
+		TargetName:   ref,
+		TargetIP:     t.ip,
+		Reason:       strings.Join(rest, " "),
+		ExpiresAt:    &exp,
+	}
+	if a, err = recordModeration(ctx, a); err != nil {
+		return commandReply{}, err
+	}
+	invalidateSanctions(call.room)
+	notifyTarget(ctx, t, a)
+	return commandReply{Text: ref + " est réduit au silence jusqu'à " + exp.Time().UTC().Format(time.RFC3339) + "."}, nil
+}
+
+func helpCommand(ctx context.Context, call commandCall) (commandReply, error) {
+	names := make([]string, 0, len(builtinCommands))
+	for name := range builtinCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{"Commandes disponibles :"}
+	for _, name := range names {
+		cmd := builtinCommands[name]
+		if cmd.allowed == nil || cmd.allowed(ctx, call) {
+			lines = append(lines, cmd.usage+" — "+cmd.help)
+		}
+	}
+	ext, err := roomCommands(ctx, call.room)
+	if err != nil {
+		log.Printf("help %s: %v", call.room, err)
+	}
+	for _, cmd := range ext {
+		usage := "/" + cmd.Name
+		if cmd.Usage != "" {
+			usage += " " + cmd.Usage
+		}
+		lines = append(lines, usage+" — "+cmd.Description)
+	}
+	lines = append(lines, "// en début de message pour envoyer un texte commençant par /")
+	return commandReply{Text: strings.Join(lines, "\n")}, nil
+}
+
+// setUser remplace l'identité de la connexion (/nick); false si elle est fermée.
+func (h *hub) setUser(c *websocket.Conn, u WSUser) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.conns[c]
+	if ok {
+		cl.user = u
+	}
+	return ok
+}
+
+// userOf renvoie l'identité courante de la connexion.
+func (h *hub) userOf(c *websocket.Conn) (WSUser, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cl, ok := h.conns[c]; ok {
+		return cl.user, true
+	}
+	return WSUser{}, false
+}
+
+// roomsOf liste les salons suivis par la connexion.
+func (h *hub) roomsOf(c *websocket.Conn) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.conns[c]
+	if !ok {
+		return nil
+	}
+	rooms := make([]string, 0, len(cl.rooms))
+	for r := range cl.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+// guestNamed cherche un invité connecté à cette instance par son pseudo.
+func (h *hub) guestNamed(name string) (WSUser, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.conns {
+		if cl.user.Guest && cl.user.Username == name {
+			return cl.user, true
+		}
+	}
+	return WSUser{}, false
+}
+
+// ------------------------------------------------------
+// Commandes externes
+
+// findExternalCommand préfère la commande du salon à la commande globale.
+func findExternalCommand(ctx context.Context, name, room string) (models.Command, error) {
+	var cmd models.Command
+	err := db.CommandsCol.FindOne(ctx,
+		bson.M{"name": name, "room": bson.M{"$in": []string{room, ""}}},
+		options.FindOne().SetSort(bson.D{{Key: "room", Value: -1}}),
+	).Decode(&cmd)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return cmd, errNotFound
+	}
+	return cmd, err
+}
+
+// roomCommands liste les commandes externes utilisables dans le salon.
+func roomCommands(ctx context.Context, room string) ([]models.Command, error) {
+	cur, err := db.CommandsCol.Find(ctx,
+		bson.M{"room": bson.M{"$in": []string{room, ""}}},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "room", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var all []models.Command
+	if err := cur.All(ctx, &all); err != nil {
+		return nil, err
+	}
+	cmds := []models.Command{}
+	for _, cmd := range all {
+		if len(cmds) > 0 && cmds[len(cmds)-1].Name == cmd.Name {
+			continue // masquée par la commande du salon
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+// signPayload signe un appel sortant; le destinataire recalcule la signature
+// avec le secret partagé et rejette un horodatage trop ancien.
+func signPayload(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// dispatchExternal transmet l'appel à l'intégration; elle répond
+// {"text": "...", "public": bool}, ou un corps vide pour ne rien dire.
+func dispatchExternal(cmd models.Command, call commandCall) {
+	body, err := json.Marshal(gin.H{
+		"command":   cmd.Name,
+		"text":      call.args,
+		"room":      call.room,
+		"user_id":   call.user.ID,
+		"username":  call.user.Username,
+		"guest":     call.user.Guest,
+		"timestamp": time.Now().UTC(),
+	})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cmd.URL, bytes.NewReader(body))
+	if err != nil {
+		commandError(call, "", err)
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(signatureHeader, signPayload(cmd.Secret, ts, body))
+
+	failed := commandFail("command_failed", "La commande /"+cmd.Name+" n'a pas répondu.")
+	resp, err := commandClient.Do(req)
+	if err != nil {
+		log.Printf("command /%s: %v", cmd.Name, err)
+		commandError(call, "", failed)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("command /%s: status %d", cmd.Name, resp.StatusCode)
+		commandError(call, "", failed)
+		return
+	}
+	var out struct {
+		Text   string `json:"text"`
+		Public bool   `json:"public"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCommandReply)).Decode(&out); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("command /%s: %v", cmd.Name, err)
+		commandError(call, "", failed)
+		return
+	}
+	deliverReply(call, commandReply{Text: out.Text, Public: out.Public})
+}
+
+// commandScope: salon de la route, ou "" (global) pour /api/commands.
+// Les commandes d'un salon sont gérées par ses modérateurs, les globales par les modérateurs globaux.
+func commandScope(c *gin.Context) (string, bool) {
+	room := c.Param("room")
+	me := authViewer(c)
+	if room == "" {
+		return "", isGlobalModerator(c, me)
+	}
+	return room, canModerate(c, me, room)
+}
+
+// listCommandsHandler: GET /api/rooms/:room/commands | GET /api/commands
+func listCommandsHandler(c *gin.Context) {
+	room, ok := commandScope(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	cur, err := db.CommandsCol.Find(c, bson.M{"room": room}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	cmds := []models.Command{}
+	if err := cur.All(c, &cmds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"commands": cmds})
+}
+
+// createCommandHandler: POST /api/rooms/:room/commands | POST /api/commands
+// {name, url, description, usage} — le secret de signature n'est renvoyé qu'ici.
+func createCommandHandler(c *gin.Context) {
+	room, ok := commandScope(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var req struct {
+		Name        string `json:"name"`
+		URL         string `json:"url"`
+		Description string `json:"description"`
+		Usage       string `json:"usage"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.Name = strings.ToLower(strings.TrimPrefix(req.Name, "/"))
+	if !commandNameRe.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid name"})
+		return
+	}
+	if _, builtin := builtinCommands[req.Name]; builtin {
+		c.JSON(http.StatusConflict, gin.H{"error": "reserved name"})
+		return
+	}
+	if !validOutboundURL(req.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid url"})
+		return
+	}
+	secret, err := newSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "secret generation failed"})
+		return
+	}
+	cmd := models.Command{
+		ID:          primitive.NewObjectID(),
+		Name:        req.Name,
+		Room:        room,
+		URL:         req.URL,
+		Secret:      secret,
+		Description: req.Description,
+		Usage:       req.Usage,
+		CreatedBy:   authViewer(c).ID,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := db.CommandsCol.InsertOne(c, cmd); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "command exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"command": cmd, "secret": secret})
+}
+
+// deleteCommandHandler: DELETE /api/rooms/:room/commands/:name | DELETE /api/commands/:name
+func deleteCommandHandler(c *gin.Context) {
+	room, ok := commandScope(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	res, err := db.CommandsCol.DeleteOne(c, bson.M{"name": strings.ToLower(c.Param("name")), "room": room})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
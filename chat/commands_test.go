@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text       string
+		name, args string
+		ok         bool
+	}{
+		{"/me danse", "me", "danse", true},
+		{"/ME  danse  ", "me", "danse", true},
+		{"/help", "help", "", true},
+		{"/topic\tNouveau sujet", "topic", "Nouveau sujet", true},
+		{"/mute @bob 10m spam", "mute", "@bob 10m spam", true},
+		{"/  me danse", "me", "danse", true},
+		{"//me danse", "", "", false},
+		{"/", "", "", false},
+		{"/   ", "", "", false},
+		{"bonjour /me", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.text)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("parseCommand(%q) = %q, %q, %v; want %q, %q, %v", tt.text, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	tests := []struct {
+		secret, ts, body string
+		want             string
+	}{
+		// echo -n '1700000000.{"a":1}' | openssl dgst -sha256 -hmac s3cret
+		{"s3cret", "1700000000", `{"a":1}`, "sha256=1698a50bc74d1ff1db85c4e0a5297c2ad9fdba245d5737cdb789e4cc6e098940"},
+	}
+	for _, tt := range tests {
+		if got := signPayload(tt.secret, tt.ts, []byte(tt.body)); got != tt.want {
+			t.Errorf("signPayload(%q, %q, %q) = %q; want %q", tt.secret, tt.ts, tt.body, got, tt.want)
+		}
+	}
+
+	base := signPayload("s3cret", "1700000000", []byte(`{"a":1}`))
+	for _, other := range []string{
+		signPayload("autre", "1700000000", []byte(`{"a":1}`)),
+		signPayload("s3cret", "1700000001", []byte(`{"a":1}`)),
+		signPayload("s3cret", "1700000000", []byte(`{"a":2}`)),
+	} {
+		if other == base {
+			t.Errorf("signature inchangée malgré une entrée différente: %q", other)
+		}
+	}
+}
+
+func TestSanctionFailure(t *testing.T) {
+	tests := []struct {
+		reason, until string
+		code, text    string
+	}{
+		{"", "", "", ""},
+		{nackBanned, "", nackBanned, "Vous êtes banni de ce salon."},
+		{nackMuted, "", nackMuted, "Vous êtes réduit au silence dans ce salon."},
+		{nackMuted, "2026-01-01T12:00:00Z", nackMuted, "Vous êtes réduit au silence dans ce salon jusqu'à 2026-01-01T12:00:00Z."},
+	}
+	for _, tt := range tests {
+		err := sanctionFailure(tt.reason, tt.until)
+		var f *commandFailure
+		if tt.code == "" {
+			if err != nil {
+				t.Errorf("sanctionFailure(%q) = %v; want nil", tt.reason, err)
+			}
+			continue
+		}
+		if !errors.As(err, &f) || f.code != tt.code || f.text != tt.text {
+			t.Errorf("sanctionFailure(%q, %q) = %v; want %s %q", tt.reason, tt.until, err, tt.code, tt.text)
+		}
+	}
+}
+
+func TestPublicCommands(t *testing.T) {
+	// Les commandes aux effets visibles des autres passent par les sanctions.
+	for name, want := range map[string]bool{"me": true, "nick": true, "topic": false, "invite": false, "mute": false, "help": false} {
+		if got := builtinCommands[name].public; got != want {
+			t.Errorf("/%s public = %v; want %v", name, got, want)
+		}
+	}
+}
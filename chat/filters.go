@@ -0,0 +1,390 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+)
+
+// FilterInput est le message soumis à la chaîne de filtres, avant persistance et diffusion.
+type FilterInput struct {
+	Text      string
+	Room      string
+	UserID    string // vide pour un invité
+	Username  string
+	AuthorKey string // id du compte, "ip:<ip>" pour un invité
+	Edit      bool   // édition d'un message existant
+}
+
+// FilterAction est la décision d'un filtre.
+type FilterAction string
+
+const (
+	FilterAllow  FilterAction = "allow"
+	FilterReject FilterAction = "reject" // message refusé à l'émetteur
+	FilterRedact FilterAction = "redact" // Text remplace le contenu, la chaîne continue
+	FilterFlag   FilterAction = "flag"   // message publié et placé en file de revue
+)
+
+// FilterVerdict est la réponse d'un filtre.
+type FilterVerdict struct {
+	Action FilterAction
+	Text   string // FilterRedact: contenu expurgé
+	Reason string
+}
+
+// ContentFilter examine un message. Une erreur laisse passer le message
+// (un filtre en panne ne coupe pas le chat) et est journalisée.
+type ContentFilter interface {
+	Name() string
+	Check(ctx context.Context, in FilterInput) (FilterVerdict, error)
+}
+
+var (
+	filtersMu   sync.RWMutex
+	filtersOnce sync.Once
+	filters     []ContentFilter
+)
+
+// SetContentFilters remplace la chaîne de filtres (ordre d'exécution).
+// Sans appel, la chaîne est construite depuis l'environnement (filtersFromEnv).
+func SetContentFilters(fs ...ContentFilter) {
+	filtersOnce.Do(func() {})
+	filtersMu.Lock()
+	filters = fs
+	filtersMu.Unlock()
+}
+
+func currentFilters() []ContentFilter {
+	filtersOnce.Do(func() {
+		fs := filtersFromEnv()
+		filtersMu.Lock()
+		filters = fs
+		filtersMu.Unlock()
+	})
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
+	return filters
+}
+
+// filterResult est l'issue de la chaîne: contenu final, motifs de signalement, ou refus.
+type filterResult struct {
+	Text     string
+	Flags    []string
+	Rejected bool
+	Reason   string
+}
+
+// runFilters passe le message dans chaque filtre; un refus arrête la chaîne,
+// une expurgation est vue par les filtres suivants.
+func runFilters(ctx context.Context, in FilterInput) filterResult {
+	res := filterResult{Text: in.Text}
+	for _, f := range currentFilters() {
+		in.Text = res.Text
+		v, err := f.Check(ctx, in)
+		if err != nil {
+			log.Printf("content filter %s: %v", f.Name(), err)
+			continue
+		}
+		switch v.Action {
+		case FilterReject:
+			res.Rejected = true
+			res.Reason = f.Name() + ": " + v.Reason
+			return res
+		case FilterRedact:
+			if strings.TrimSpace(v.Text) != "" {
+				res.Text = v.Text
+			}
+		case FilterFlag:
+			res.Flags = append(res.Flags, f.Name()+": "+v.Reason)
+		}
+	}
+	return res
+}
+
+// filtersFromEnv construit la chaîne par défaut:
+//   - FILTER_WORDS (liste séparée par des virgules) et FILTER_PATTERNS (expressions
+//     régulières, une par ligne), action FILTER_ACTION (redact par défaut);
+//   - LINK_BLOCKLIST (domaines séparés par des virgules), refus;
+//   - heuristique anti-spam, toujours active;
+//   - CLASSIFIER_URL: classifieur HTTP local (CLASSIFIER_FLAG, CLASSIFIER_REJECT).
+func filtersFromEnv() []ContentFilter {
+	var fs []ContentFilter
+	action := FilterAction(strings.ToLower(strings.TrimSpace(os.Getenv("FILTER_ACTION"))))
+	if action != FilterReject && action != FilterFlag {
+		action = FilterRedact
+	}
+	words := splitList(os.Getenv("FILTER_WORDS"), ",")
+	var patterns []*regexp.Regexp
+	for _, p := range splitList(os.Getenv("FILTER_PATTERNS"), "\n") {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Printf("FILTER_PATTERNS: %q ignoré: %v", p, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	if len(words) > 0 || len(patterns) > 0 {
+		fs = append(fs, NewWordFilter(words, patterns, action))
+	}
+	if domains := splitList(os.Getenv("LINK_BLOCKLIST"), ","); len(domains) > 0 {
+		fs = append(fs, &LinkBlocklist{Domains: domains, Action: FilterReject})
+	}
+	fs = append(fs, &SpamFilter{})
+	if u := os.Getenv("CLASSIFIER_URL"); u != "" {
+		fs = append(fs, &HTTPClassifier{
+			URL:         u,
+			FlagAbove:   envFloat("CLASSIFIER_FLAG", 0.7),
+			RejectAbove: envFloat("CLASSIFIER_REJECT", 0.95),
+		})
+	}
+	return fs
+}
+
+func splitList(s, sep string) []string {
+	var out []string
+	for _, p := range strings.Split(s, sep) {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// redactSpans remplace chaque portion [start, end) par des astérisques (une par caractère).
+func redactSpans(text string, spans [][2]int) string {
+	if len(spans) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, s := range spans {
+		if s[0] < last {
+			continue // chevauchement
+		}
+		b.WriteString(text[last:s[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[s[0]:s[1]])))
+		last = s[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// WordFilter repère des mots interdits (mots entiers, casse ignorée) et des expressions régulières.
+type WordFilter struct {
+	words    *regexp.Regexp
+	patterns []*regexp.Regexp
+	action   FilterAction
+}
+
+func NewWordFilter(words []string, patterns []*regexp.Regexp, action FilterAction) *WordFilter {
+	f := &WordFilter{patterns: patterns, action: action}
+	if len(words) > 0 {
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			quoted[i] = regexp.QuoteMeta(w)
+		}
+		// \b ne connaît que l'ASCII: bornes explicites pour les lettres accentuées.
+		f.words = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)(?:$|[^\p{L}\p{N}_])`)
+	}
+	return f
+}
+
+func (f *WordFilter) Name() string { return "words" }
+
+func (f *WordFilter) Check(_ context.Context, in FilterInput) (FilterVerdict, error) {
+	var spans [][2]int
+	if f.words != nil {
+		// Les bornes consomment un caractère: on reprend juste après le mot trouvé.
+		for pos := 0; pos < len(in.Text); {
+			m := f.words.FindStringSubmatchIndex(in.Text[pos:])
+			if m == nil {
+				break
+			}
+			spans = append(spans, [2]int{pos + m[2], pos + m[3]})
+			pos += m[3]
+		}
+	}
+	for _, re := range f.patterns {
+		for _, m := range re.FindAllStringIndex(in.Text, -1) {
+			if m[1] > m[0] {
+				spans = append(spans, [2]int{m[0], m[1]})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return FilterVerdict{Action: FilterAllow}, nil
+	}
+	v := FilterVerdict{Action: f.action, Reason: fmt.Sprintf("%d forbidden term(s)", len(spans))}
+	if f.action == FilterRedact {
+		sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+		v.Text = redactSpans(in.Text, spans)
+	}
+	return v, nil
+}
+
+// LinkBlocklist refuse (ou expurge) les liens vers des domaines bloqués et leurs sous-domaines.
+type LinkBlocklist struct {
+	Domains []string
+	Action  FilterAction
+}
+
+var linkRe = regexp.MustCompile(`(?i)\b(?:https?://)?((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,})(?:[:/?#][^\s]*)?`)
+
+func (f *LinkBlocklist) Name() string { return "links" }
+
+func (f *LinkBlocklist) blocked(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range f.Domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *LinkBlocklist) Check(_ context.Context, in FilterInput) (FilterVerdict, error) {
+	var spans [][2]int
+	var hosts []string
+	for _, m := range linkRe.FindAllStringSubmatchIndex(in.Text, -1) {
+		host := in.Text[m[2]:m[3]]
+		if u, err := url.Parse("http://" + host); err == nil {
+			host = u.Hostname()
+		}
+		if f.blocked(host) {
+			spans = append(spans, [2]int{m[0], m[1]})
+			hosts = append(hosts, host)
+		}
+	}
+	if len(spans) == 0 {
+		return FilterVerdict{Action: FilterAllow}, nil
+	}
+	v := FilterVerdict{Action: f.Action, Reason: "blocked link " + strings.Join(hosts, ", ")}
+	if f.Action == FilterRedact {
+		v.Text = redactSpans(in.Text, spans)
+	}
+	return v, nil
+}
+
+// SpamFilter: le même texte répété RepeatLimit fois dans RepeatWindow est refusé
+// (compté dans Redis, toutes instances); un message qui mentionne plus de
+// MaxMentions personnes, ou un invité qui mentionne tout le salon, est signalé.
+type SpamFilter struct {
+	RepeatLimit  int64
+	RepeatWindow time.Duration
+	MaxMentions  int
+}
+
+func (f *SpamFilter) Name() string { return "spam" }
+
+func (f *SpamFilter) Check(ctx context.Context, in FilterInput) (FilterVerdict, error) {
+	limit, window, maxMentions := f.RepeatLimit, f.RepeatWindow, f.MaxMentions
+	if limit <= 0 {
+		limit = 3
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	if maxMentions <= 0 {
+		maxMentions = 5
+	}
+
+	names, all, here := parseMentions(in.Text)
+	if len(names) > maxMentions {
+		return FilterVerdict{Action: FilterFlag, Reason: strconv.Itoa(len(names)) + " mentions"}, nil
+	}
+	if in.UserID == "" && (all || here) {
+		return FilterVerdict{Action: FilterFlag, Reason: "guest mass mention"}, nil
+	}
+
+	if in.Edit {
+		return FilterVerdict{Action: FilterAllow}, nil
+	}
+	norm := strings.Join(strings.Fields(strings.ToLower(in.Text)), " ")
+	sum := sha1.Sum([]byte(norm))
+	key := "spam:repeat:" + in.AuthorKey + ":" + hex.EncodeToString(sum[:8])
+	n, err := db.Rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return FilterVerdict{}, err
+	}
+	if n == 1 {
+		db.Rdb.Expire(ctx, key, window)
+	}
+	if n >= limit {
+		return FilterVerdict{Action: FilterReject, Reason: "repeated message"}, nil
+	}
+	return FilterVerdict{Action: FilterAllow}, nil
+}
+
+// HTTPClassifier délègue à un classifieur externe (modèle de ML servi en local):
+// POST URL {"text", "room", "user_id"} -> {"score": 0..1, "label": "..."}.
+// Au-dessus de RejectAbove le message est refusé, au-dessus de FlagAbove il est signalé.
+type HTTPClassifier struct {
+	URL         string
+	FlagAbove   float64
+	RejectAbove float64
+	Timeout     time.Duration // 2s par défaut
+	Client      *http.Client
+}
+
+func (f *HTTPClassifier) Name() string { return "classifier" }
+
+func (f *HTTPClassifier) Check(ctx context.Context, in FilterInput) (FilterVerdict, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"text": in.Text, "room": in.Room, "user_id": in.UserID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(body))
+	if err != nil {
+		return FilterVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return FilterVerdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return FilterVerdict{}, fmt.Errorf("classifier status %d", resp.StatusCode)
+	}
+	var out struct {
+		Score float64 `json:"score"`
+		Label string  `json:"label"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return FilterVerdict{}, err
+	}
+	reason := fmt.Sprintf("%s (%.2f)", out.Label, out.Score)
+	switch {
+	case f.RejectAbove > 0 && out.Score >= f.RejectAbove:
+		return FilterVerdict{Action: FilterReject, Reason: reason}, nil
+	case f.FlagAbove > 0 && out.Score >= f.FlagAbove:
+		return FilterVerdict{Action: FilterFlag, Reason: reason}, nil
+	}
+	return FilterVerdict{Action: FilterAllow}, nil
+}
@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		s, sep string
+		want   []string
+	}{
+		{"", ",", nil},
+		{"a", ",", []string{"a"}},
+		{" a , ,b,, c ", ",", []string{"a", "b", "c"}},
+		{"x\n\n y \n", "\n", []string{"x", "y"}},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.s, tt.sep); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q, %q) = %q; want %q", tt.s, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestRedactSpans(t *testing.T) {
+	tests := []struct {
+		text  string
+		spans [][2]int
+		want  string
+	}{
+		{"rien", nil, "rien"},
+		{"un mot ici", [][2]int{{3, 6}}, "un *** ici"},
+		{"début fin", [][2]int{{0, 6}, {7, 10}}, "***** ***"},
+		{"chevauché", [][2]int{{0, 5}, {2, 6}}, "*****uché"},
+	}
+	for _, tt := range tests {
+		if got := redactSpans(tt.text, tt.spans); got != tt.want {
+			t.Errorf("redactSpans(%q, %v) = %q; want %q", tt.text, tt.spans, got, tt.want)
+		}
+	}
+}
+
+func TestWordFilter(t *testing.T) {
+	f := NewWordFilter([]string{"zut", "crétin"}, []*regexp.Regexp{regexp.MustCompile(`\d{4}-\d{4}`)}, FilterRedact)
+	tests := []struct {
+		text   string
+		action FilterAction
+		want   string
+	}{
+		{"bonjour", FilterAllow, ""},
+		{"zut alors", FilterRedact, "*** alors"},
+		{"ZUT!", FilterRedact, "***!"},
+		{"zutique", FilterAllow, ""},
+		{"Crétin, crétin", FilterRedact, "******, ******"},
+		{"crétinerie", FilterAllow, ""},
+		{"appelle 0612-3456 zut", FilterRedact, "appelle ********* ***"},
+	}
+	for _, tt := range tests {
+		v, err := f.Check(context.Background(), FilterInput{Text: tt.text})
+		if err != nil {
+			t.Fatalf("Check(%q): %v", tt.text, err)
+		}
+		if v.Action != tt.action || v.Text != tt.want {
+			t.Errorf("Check(%q) = %s %q; want %s %q", tt.text, v.Action, v.Text, tt.action, tt.want)
+		}
+	}
+
+	reject := NewWordFilter([]string{"zut"}, nil, FilterReject)
+	if v, _ := reject.Check(context.Background(), FilterInput{Text: "zut"}); v.Action != FilterReject || v.Text != "" {
+		t.Errorf("Check(refus) = %s %q; want reject sans texte", v.Action, v.Text)
+	}
+}
+
+func TestLinkBlocklist(t *testing.T) {
+	f := &LinkBlocklist{Domains: []string{"spam.example", ".evil.test"}, Action: FilterRedact}
+	tests := []struct {
+		text   string
+		action FilterAction
+		want   string
+	}{
+		{"voir https://ok.example/page", FilterAllow, ""},
+		{"voir https://spam.example/x?y=1 ici", FilterRedact, "voir ************************** ici"},
+		{"sous-domaine www.SPAM.example.", FilterRedact, "sous-domaine ****************."},
+		{"evil.test:8080/a", FilterRedact, "****************"},
+		{"notspam.example", FilterAllow, ""},
+	}
+	for _, tt := range tests {
+		v, err := f.Check(context.Background(), FilterInput{Text: tt.text})
+		if err != nil {
+			t.Fatalf("Check(%q): %v", tt.text, err)
+		}
+		if v.Action != tt.action || v.Text != tt.want {
+			t.Errorf("Check(%q) = %s %q; want %s %q", tt.text, v.Action, v.Text, tt.action, tt.want)
+		}
+	}
+}
+
+// stubFilter rend un verdict fixe et note le texte reçu.
+type stubFilter struct {
+	name    string
+	verdict FilterVerdict
+	err     error
+	seen    *[]string
+}
+
+func (f stubFilter) Name() string { return f.name }
+
+func (f stubFilter) Check(_ context.Context, in FilterInput) (FilterVerdict, error) {
+	*f.seen = append(*f.seen, f.name+":"+in.Text)
+	return f.verdict, f.err
+}
+
+func TestRunFilters(t *testing.T) {
+	var seen []string
+	redact := stubFilter{"redact", FilterVerdict{Action: FilterRedact, Text: "propre"}, nil, &seen}
+	blank := stubFilter{"blank", FilterVerdict{Action: FilterRedact, Text: "  "}, nil, &seen}
+	flag := stubFilter{"flag", FilterVerdict{Action: FilterFlag, Reason: "douteux"}, nil, &seen}
+	broken := stubFilter{"broken", FilterVerdict{Action: FilterReject}, errors.New("panne"), &seen}
+	reject := stubFilter{"reject", FilterVerdict{Action: FilterReject, Reason: "interdit"}, nil, &seen}
+	after := stubFilter{"after", FilterVerdict{Action: FilterAllow}, nil, &seen}
+	defer SetContentFilters()
+
+	SetContentFilters(redact, blank, broken, flag, after)
+	res := runFilters(context.Background(), FilterInput{Text: "sale"})
+	if res.Rejected || res.Text != "propre" || !reflect.DeepEqual(res.Flags, []string{"flag: douteux"}) {
+		t.Errorf("runFilters = %+v; want texte expurgé et un signalement", res)
+	}
+	if want := []string{"redact:sale", "blank:propre", "broken:propre", "flag:propre", "after:propre"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("textes vus = %q; want %q", seen, want)
+	}
+
+	seen = nil
+	SetContentFilters(flag, reject, after)
+	res = runFilters(context.Background(), FilterInput{Text: "sale"})
+	if !res.Rejected || res.Reason != "reject: interdit" {
+		t.Errorf("runFilters = %+v; want refus", res)
+	}
+	if len(seen) != 2 {
+		t.Errorf("textes vus = %q; want arrêt au refus", seen)
+	}
+}
@@ -0,0 +1,166 @@
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/auth"
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mode invité global (GUEST_MODE): pas d'invités, lecture seule, ou chat
+// sous un pseudonyme attribué par le serveur.
+const (
+	guestDisabled = "disabled"
+	guestReadOnly = "read-only"
+	guestChat     = "chat"
+)
+
+// Accès invité d'un salon (Room.GuestAccess); vide: celui du mode global.
+// Un salon peut restreindre le mode global, jamais l'élargir.
+const (
+	guestNone  = "none"
+	guestRead  = "read"
+	guestWrite = "write"
+)
+
+const guestHandleTTL = 24 * time.Hour
+
+var (
+	guestModeValue string
+	guestModeOnce  sync.Once
+)
+
+func guestMode() string {
+	guestModeOnce.Do(func() {
+		switch strings.ToLower(strings.TrimSpace(os.Getenv("GUEST_MODE"))) {
+		case guestDisabled:
+			guestModeValue = guestDisabled
+		case guestReadOnly, "readonly":
+			guestModeValue = guestReadOnly
+		default:
+			guestModeValue = guestChat
+		}
+	})
+	return guestModeValue
+}
+
+func guestHandleKey(handle string) string { return "guest:handle:" + handle }
+
+// assignGuestHandle réserve un pseudonyme "Invité-xxxxxx" unique parmi les invités
+// connectés (Redis, toutes instances). Le préfixe est interdit à l'inscription,
+// la base est tout de même vérifiée pour les comptes antérieurs.
+func assignGuestHandle(ctx context.Context) (string, error) {
+	buf := make([]byte, 3)
+	for i := 0; i < 5; i++ {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		handle := auth.GuestPrefix + hex.EncodeToString(buf)
+		ok, err := db.Rdb.SetNX(ctx, guestHandleKey(handle), 1, guestHandleTTL).Result()
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if n, err := db.UsersCol.CountDocuments(ctx, bson.M{"username": handle}); err == nil && n == 0 {
+			return handle, nil
+		}
+		// Le pseudonyme reste réservé dans Redis: il ne sera plus tiré.
+	}
+	return "", errors.New("no free guest handle")
+}
+
+func releaseGuestHandle(handle string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	db.Rdb.Del(ctx, guestHandleKey(handle))
+}
+
+// guestAccess renvoie l'accès effectif des invités au salon.
+func guestAccess(ctx context.Context, room string) string {
+	global := guestWrite
+	switch guestMode() {
+	case guestDisabled:
+		return guestNone
+	case guestReadOnly:
+		global = guestRead
+	}
+	r, err := cachedRoom(ctx, room)
+	if err != nil {
+		log.Printf("guest access %s: %v", room, err)
+		return guestNone
+	}
+	if r == nil {
+		return global
+	}
+	switch r.GuestAccess {
+	case guestNone:
+		return guestNone
+	case guestRead:
+		return guestRead
+	}
+	return global
+}
+
+// guestWrites liste les trames réservées aux invités qui peuvent écrire.
+func guestWrites(kind string) bool {
+	switch kind {
+	case "", "message", "edit", "delete", "react", "unreact", "typing.start", "typing.stop":
+		return true
+	}
+	return false
+}
+
+// guestAccessHandler: PUT /api/rooms/:room/guests {access: none|read|write|""}
+func guestAccessHandler(c *gin.Context) {
+	var req struct {
+		Access string `json:"access"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	switch req.Access {
+	case "", guestNone, guestRead, guestWrite:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid access"})
+		return
+	}
+	room := c.Param("room")
+	if isDMRoom(room) || !roomNameRe.MatchString(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room"})
+		return
+	}
+	if !canModerate(c, authViewer(c), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	_, err := db.RoomsCol.UpdateOne(c,
+		bson.M{"name": room},
+		bson.M{
+			"$set":         bson.M{"guest_access": req.Access},
+			"$setOnInsert": bson.M{"private": false, "created_at": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	forgetRoom(room)
+	c.JSON(http.StatusOK, gin.H{"room": room, "guest_access": req.Access, "effective": guestAccess(c, room)})
+}
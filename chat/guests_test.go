@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGuestMode(t *testing.T) {
+	tests := []struct {
+		env, want string
+	}{
+		{"", guestChat},
+		{"chat", guestChat},
+		{"disabled", guestDisabled},
+		{" DISABLED ", guestDisabled},
+		{"read-only", guestReadOnly},
+		{"readonly", guestReadOnly},
+		{"n'importe quoi", guestChat},
+	}
+	for _, tt := range tests {
+		t.Setenv("GUEST_MODE", tt.env)
+		guestModeOnce = sync.Once{} // relit l'environnement
+		if got := guestMode(); got != tt.want {
+			t.Errorf("guestMode(%q) = %q; want %q", tt.env, got, tt.want)
+		}
+	}
+	guestModeOnce = sync.Once{}
+}
+
+func TestGuestWrites(t *testing.T) {
+	for kind, want := range map[string]bool{
+		"":             true,
+		"message":      true,
+		"edit":         true,
+		"delete":       true,
+		"react":        true,
+		"unreact":      true,
+		"typing.start": true,
+		"typing.stop":  true,
+		"subscribe":    false,
+		"unsubscribe":  false,
+		"resume":       false,
+		"read":         false,
+	} {
+		if got := guestWrites(kind); got != want {
+			t.Errorf("guestWrites(%q) = %v; want %v", kind, got, want)
+		}
+	}
+}
+
+func TestGuestHandleKey(t *testing.T) {
+	if got, want := guestHandleKey("Invité-42"), "guest:handle:Invité-42"; got != want {
+		t.Errorf("guestHandleKey = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,280 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 500
+)
+
+// historyCursor repère un message dans l'ordre (created_at, _id) d'un salon.
+// Forme texte: "<unix_ms>_<id hex>"; un id de message seul est aussi accepté.
+type historyCursor struct {
+	At time.Time
+	ID primitive.ObjectID
+}
+
+func (hc historyCursor) String() string {
+	return strconv.FormatInt(hc.At.UnixMilli(), 10) + "_" + hc.ID.Hex()
+}
+
+func cursorOf(m models.Message) historyCursor {
+	return historyCursor{At: m.CreatedAt.Time(), ID: m.ID}
+}
+
+func parseHistoryCursor(ctx context.Context, room, s string) (historyCursor, error) {
+	if ms, hex, ok := strings.Cut(s, "_"); ok {
+		n, err := strconv.ParseInt(ms, 10, 64)
+		if err != nil {
+			return historyCursor{}, errors.New("curseur invalide")
+		}
+		oid, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return historyCursor{}, errors.New("curseur invalide")
+		}
+		return historyCursor{At: time.UnixMilli(n), ID: oid}, nil
+	}
+	oid, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		return historyCursor{}, errors.New("curseur invalide")
+	}
+	var m models.Message
+	if err := db.MessagesCol.FindOne(ctx, bson.M{"_id": oid, "room": room}).Decode(&m); err != nil {
+		return historyCursor{}, errors.New("message introuvable")
+	}
+	return cursorOf(m), nil
+}
+
+// pageFilter restreint base aux messages strictement avant (older) ou après le curseur.
+// inclusive inclut le message du curseur lui-même (mode around).
+func pageFilter(base bson.M, hc historyCursor, older, inclusive bool) bson.M {
+	cmpAt, cmpID := "$gt", "$gt"
+	if older {
+		cmpAt, cmpID = "$lt", "$lt"
+	}
+	if inclusive {
+		cmpID += "e"
+	}
+	at := primitive.NewDateTimeFromTime(hc.At)
+	filter := bson.M{"$or": bson.A{
+		bson.M{"created_at": bson.M{cmpAt: at}},
+		bson.M{"created_at": at, "_id": bson.M{cmpID: hc.ID}},
+	}}
+	for k, v := range base {
+		filter[k] = v
+	}
+	return filter
+}
+
+// fetchPage lit jusqu'à limit messages dans le sens demandé et indique s'il en reste.
+// Le résultat est toujours rendu dans l'ordre chronologique.
+func fetchPage(ctx context.Context, filter bson.M, older bool, limit int) ([]models.Message, bool, error) {
+	dir := 1
+	if older {
+		dir = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: dir}, {Key: "_id", Value: dir}}).
+		SetLimit(int64(limit + 1))
+	cur, err := db.MessagesCol.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	var msgs []models.Message
+	if err := cur.All(ctx, &msgs); err != nil {
+		return nil, false, err
+	}
+	more := len(msgs) > limit
+	if more {
+		msgs = msgs[:limit]
+	}
+	if older {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	return msgs, more, nil
+}
+
+// historyItem est la représentation d'un message dans les réponses d'historique.
+func historyItem(m models.Message) gin.H {
+	item := gin.H{
+		"id":        m.ID.Hex(),
+		"seq":       m.Seq,
+		"username":  m.Sender,
+		"avatar":    avatarFor(m),
+		"text":      m.Content,
+		"timestamp": m.CreatedAt.Time().UTC().Format(time.RFC3339),
+		"room":      m.Room,
+		"edited_at": nil,
+		"reactions": reactionCounts(m),
+	}
+	if m.UserID == "" {
+		item["guest"] = true
+	}
+	if m.ReplyTo != "" {
+		item["reply_to"] = m.ReplyTo
+		item["quote"] = m.Quote
+	}
+	if m.ThreadRoot != "" {
+		item["thread_root"] = m.ThreadRoot
+	}
+	if len(m.Mentions) > 0 {
+		item["mentions"] = m.Mentions
+	}
+	if m.PinnedAt != nil {
+		item["pinned"] = true
+	}
+	if m.Announcement {
+		item["announcement"] = true
+		if m.Persistent {
+			item["persistent"] = true
+		}
+	}
+	if m.Poll != nil {
+		item["poll"] = pollView(m.Poll)
+	}
+	if m.Command != "" {
+		item["command"] = m.Command
+	}
+	if refs := attachmentRefs(m); len(refs) > 0 {
+		item["attachments"] = refs
+	}
+	if m.ReplyCount > 0 {
+		item["reply_count"] = m.ReplyCount
+		if m.LastReplyAt != nil {
+			item["last_reply_at"] = m.LastReplyAt.Time().UTC().Format(time.RFC3339)
+		}
+	}
+	if m.EditedAt != nil {
+		item["edited_at"] = m.EditedAt.Time().UTC().Format(time.RFC3339)
+	}
+	if m.Deleted {
+		item["deleted"] = true
+	}
+	if m.Hidden {
+		item["hidden"] = true
+		item["text"] = ""
+	}
+	return item
+}
+
+// historyHandler: GET /api/messages?room=&limit=&before=|after=|around=
+//   - sans curseur: les messages les plus récents (chargement du scrollback)
+//   - before: page plus ancienne que le curseur
+//   - after: page plus récente que le curseur
+//   - around: le message ciblé entouré de messages avant/après (saut vers un message)
+//
+// Les réponses de fil sont exclues (voir /api/messages/:id/thread) sauf include_replies=true.
+//
+// Les messages sont renvoyés en ordre chronologique; "prev" (plus ancien) et
+// "next" (plus récent) valent null quand il n'y a plus rien dans ce sens.
+func historyHandler(c *gin.Context) {
+	room := c.Query("room")
+	if room == "" {
+		room = "general"
+	}
+	limit := defaultHistoryLimit
+	if s := c.Query("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= maxHistoryLimit {
+			limit = n
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	viewer := requestViewer(c.Request)
+	if !canReadRoom(ctx, viewer, room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	base := bson.M{"room": room}
+	if c.Query("include_replies") != "true" {
+		base["thread_root"] = bson.M{"$exists": false}
+	}
+	if !excludeBlocked(ctx, base, viewer) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	var (
+		msgs                []models.Message
+		hasOlder, hasNewer  bool
+		err                 error
+		before, after, near = c.Query("before"), c.Query("after"), c.Query("around")
+	)
+	switch {
+	case before != "":
+		hc, perr := parseHistoryCursor(ctx, room, before)
+		if perr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": perr.Error()})
+			return
+		}
+		msgs, hasOlder, err = fetchPage(ctx, pageFilter(base, hc, true, false), true, limit)
+		hasNewer = true
+	case after != "":
+		hc, perr := parseHistoryCursor(ctx, room, after)
+		if perr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": perr.Error()})
+			return
+		}
+		msgs, hasNewer, err = fetchPage(ctx, pageFilter(base, hc, false, false), false, limit)
+		hasOlder = true
+	case near != "":
+		hc, perr := parseHistoryCursor(ctx, room, near)
+		if perr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": perr.Error()})
+			return
+		}
+		// Le message ciblé est inclus dans la moitié "ancienne".
+		var newer []models.Message
+		msgs, hasOlder, err = fetchPage(ctx, pageFilter(base, hc, true, true), true, (limit+1)/2)
+		if err == nil {
+			newer, hasNewer, err = fetchPage(ctx, pageFilter(base, hc, false, false), false, limit/2)
+			msgs = append(msgs, newer...)
+		}
+	default:
+		msgs, hasOlder, err = fetchPage(ctx, base, true, limit)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	out := make([]gin.H, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, historyItem(m))
+	}
+	var prev, next interface{}
+	if len(msgs) > 0 {
+		if hasOlder {
+			prev = cursorOf(msgs[0]).String()
+		}
+		if hasNewer {
+			next = cursorOf(msgs[len(msgs)-1]).String()
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room":     room,
+		"messages": out,
+		"prev":     prev,
+		"next":     next,
+	})
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHistoryCursorRoundTrip(t *testing.T) {
+	at := time.UnixMilli(1767225600123)
+	hc := historyCursor{At: at, ID: primitive.NewObjectIDFromTimestamp(at)}
+	got, err := parseHistoryCursor(context.Background(), "general", hc.String())
+	if err != nil {
+		t.Fatalf("parseHistoryCursor(%q): %v", hc.String(), err)
+	}
+	if !got.At.Equal(hc.At) || got.ID != hc.ID {
+		t.Errorf("parseHistoryCursor(%q) = %+v; want %+v", hc.String(), got, hc)
+	}
+
+	// Formes invalides rejetées sans requête en base.
+	for _, s := range []string{"", "abc_" + hc.ID.Hex(), "123_zz", "pas-un-id"} {
+		if _, err := parseHistoryCursor(context.Background(), "general", s); err == nil {
+			t.Errorf("parseHistoryCursor(%q) err = nil; want erreur", s)
+		}
+	}
+}
+
+func TestPageFilter(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	hc := historyCursor{At: at, ID: primitive.NewObjectIDFromTimestamp(at)}
+	dt := primitive.NewDateTimeFromTime(at)
+	tests := []struct {
+		name             string
+		older, inclusive bool
+		cmpAt, cmpID     string
+	}{
+		{"plus anciens", true, false, "$lt", "$lt"},
+		{"plus récents", false, false, "$gt", "$gt"},
+		{"autour, avant", true, true, "$lt", "$lte"},
+		{"autour, après", false, true, "$gt", "$gte"},
+	}
+	for _, tt := range tests {
+		got := pageFilter(bson.M{"room": "general"}, hc, tt.older, tt.inclusive)
+		want := bson.M{
+			"room": "general",
+			"$or": bson.A{
+				bson.M{"created_at": bson.M{tt.cmpAt: dt}},
+				bson.M{"created_at": dt, "_id": bson.M{tt.cmpID: hc.ID}},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("pageFilter(%s) = %v; want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestHistoryItem(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := models.Message{
+		ID:        primitive.NewObjectIDFromTimestamp(at),
+		Seq:       3,
+		Sender:    "bob",
+		Content:   "salut",
+		Room:      "general",
+		CreatedAt: primitive.NewDateTimeFromTime(at),
+	}
+
+	item := historyItem(m)
+	if item["text"] != "salut" || item["timestamp"] != "2026-03-01T12:00:00Z" || item["guest"] != true {
+		t.Errorf("historyItem = %v; texte, horodatage ou invité incorrects", item)
+	}
+	if v, ok := item["edited_at"]; !ok || v != nil {
+		t.Errorf("historyItem[edited_at] = %v, %v; want nil présent", v, ok)
+	}
+	for _, k := range []string{"deleted", "hidden", "reply_to", "thread_root", "reply_count"} {
+		if _, ok := item[k]; ok {
+			t.Errorf("historyItem[%s] présent pour un message simple", k)
+		}
+	}
+
+	m.UserID = "u1"
+	m.Hidden = true
+	m.ReplyTo = "r1"
+	m.Quote = &models.Quote{ID: "r1", Sender: "alice", Excerpt: "cité"}
+	item = historyItem(m)
+	if _, ok := item["guest"]; ok {
+		t.Errorf("historyItem[guest] présent pour un compte")
+	}
+	if item["hidden"] != true || item["text"] != "" || item["reply_to"] != "r1" {
+		t.Errorf("historyItem(masqué, réponse) = %v", item)
+	}
+}
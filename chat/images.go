@@ -0,0 +1,460 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+)
+
+// Traitement des images téléversées: suppression des métadonnées (EXIF, GPS, XMP,
+// commentaires), garde contre les bombes de décompression, réduction et
+// empreinte BlurHash. Uniquement la bibliothèque standard: le WebP est nettoyé
+// mais ni décodé ni réduit.
+
+const (
+	maxImageSide      = 20000
+	placeholderSide   = 32 // l'empreinte est calculée sur une réduction, pas sur l'original
+	reencodeQuality   = 92
+	thumbnailQuality  = 80
+	blurhashAlphabet  = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+	exifOrientationID = 0x0112
+)
+
+var (
+	errImageTooLarge = errors.New("image_too_large")
+	errBadImage      = errors.New("invalid_image")
+)
+
+// decodableImage: formats que la bibliothèque standard sait décoder.
+func decodableImage(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// checkImageBounds lit seulement l'en-tête: une image trop grande est refusée avant tout décodage.
+func checkImageBounds(data []byte, maxPixels int64) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, errBadImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return 0, 0, errBadImage
+	}
+	if cfg.Width > maxImageSide || cfg.Height > maxImageSide || int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return 0, 0, errImageTooLarge
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// sanitizeImage retire les métadonnées sans recompresser, sauf pour un JPEG
+// orienté par EXIF: l'orientation est alors appliquée aux pixels puis réencodée.
+// Renvoie le contenu nettoyé et, si connues, les dimensions affichées.
+func sanitizeImage(contentType string, data []byte, maxPixels int64) ([]byte, int, int, error) {
+	var w, h int
+	if decodableImage(contentType) {
+		var err error
+		if w, h, err = checkImageBounds(data, maxPixels); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	switch contentType {
+	case "image/jpeg":
+		if o := jpegOrientation(data); o > 1 && o <= 8 {
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, 0, 0, errBadImage
+			}
+			out := orient(toRGBA(img), o)
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: reencodeQuality}); err != nil {
+				return nil, 0, 0, err
+			}
+			b := out.Bounds()
+			return buf.Bytes(), b.Dx(), b.Dy(), nil
+		}
+		out, err := stripJPEG(data)
+		return out, w, h, err
+	case "image/png":
+		out, err := stripPNG(data)
+		return out, w, h, err
+	case "image/webp":
+		out, err := stripWebP(data)
+		return out, 0, 0, err
+	}
+	return data, w, h, nil
+}
+
+// stripJPEG recopie les segments en omettant APP1 (EXIF, XMP), APP3-APP13, APP15
+// et COM. APP0 (JFIF), APP2 (profil ICC) et APP14 (Adobe, transformée couleur) sont gardés.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errBadImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, errBadImage
+		}
+		// Octets de remplissage 0xFF
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, errBadImage
+		}
+		marker := data[i+1]
+		if marker == 0xD9 || marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, errBadImage
+		}
+		// La longueur inclut ses deux octets: en dessous, le segment est invalide.
+		n := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) {
+			return nil, errBadImage
+		}
+		if marker == 0xDA {
+			// Début des données compressées: le reste est recopié tel quel.
+			return append(out, data[i:]...), nil
+		}
+		drop := marker == 0xE1 || marker >= 0xE3 && marker <= 0xED || marker == 0xEF || marker == 0xFE
+		if !drop {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// jpegOrientation lit le tag Orientation de l'IFD0 EXIF, 1 s'il est absent.
+func jpegOrientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + n
+		if n < 2 || end > len(data) {
+			break
+		}
+		seg := data[i+4 : end]
+		if marker == 0xE1 && len(seg) > 14 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+func tiffOrientation(t []byte) int {
+	var bo binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 1
+	}
+	off := int(bo.Uint32(t[4:8]))
+	if off+2 > len(t) {
+		return 1
+	}
+	count := int(bo.Uint16(t[off : off+2]))
+	for k := 0; k < count; k++ {
+		e := off + 2 + 12*k
+		if e+12 > len(t) {
+			return 1
+		}
+		if bo.Uint16(t[e:e+2]) == exifOrientationID {
+			return int(bo.Uint16(t[e+8 : e+10]))
+		}
+	}
+	return 1
+}
+
+// stripPNG omet les blocs de métadonnées (eXIf, textes, horodatage); les CRC
+// étant par bloc, les blocs gardés sont recopiés tels quels.
+func stripPNG(data []byte) ([]byte, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(sig) || string(data[:len(sig)]) != sig {
+		return nil, errBadImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:len(sig)]...)
+	i := len(sig)
+	for i+12 <= len(data) {
+		n := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + n
+		if end > len(data) {
+			return nil, errBadImage
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		if string(data[i+4:i+8]) == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+	return nil, errBadImage
+}
+
+// stripWebP omet les blocs EXIF et XMP du conteneur RIFF et met à jour
+// les indicateurs VP8X et la taille RIFF.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errBadImage
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	i := 12
+	for i+8 <= len(data) {
+		n := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + n + n%2
+		if end > len(data) {
+			return nil, errBadImage
+		}
+		switch fourcc := string(data[i : i+4]); fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+	return out
+}
+
+// orient applique une orientation EXIF (2 à 8) aux pixels.
+func orient(src *image.RGBA, o int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			si, di := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// fitWithin réduit (w, h) pour que le plus grand côté vaille au plus side.
+func fitWithin(w, h, side int) (int, int) {
+	if w <= side && h <= side {
+		return w, h
+	}
+	if w >= h {
+		return side, max(1, h*side/w)
+	}
+	return max(1, w*side/h), side
+}
+
+// resizeBox réduit par moyenne de zone: chaque pixel de destination est la moyenne
+// des pixels sources qu'il recouvre (suffisant pour des vignettes, jamais d'agrandissement).
+func resizeBox(src *image.RGBA, dw, dh int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			di := dst.PixOffset(x, y)
+			dst.Pix[di], dst.Pix[di+1], dst.Pix[di+2], dst.Pix[di+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// decodeImage décode une image déjà validée par checkImageBounds (première image d'un GIF).
+func decodeImage(contentType string, data []byte) (*image.RGBA, error) {
+	var (
+		img image.Image
+		err error
+	)
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, errBadImage
+	}
+	if err != nil {
+		return nil, errBadImage
+	}
+	return toRGBA(img), nil
+}
+
+// encodeThumbnail: JPEG pour les photos, PNG sinon (transparence).
+func encodeThumbnail(img *image.RGBA, sourceType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if sourceType == "image/jpeg" {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), "image/png", err
+}
+
+// blurHash calcule l'empreinte BlurHash (https://blurha.sh) de l'image,
+// avec 4x3 composantes (3x4 en portrait).
+func blurHash(img *image.RGBA) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if pw, ph := fitWithin(w, h, placeholderSide); pw != w || ph != h {
+		img, w, h = resizeBox(img, pw, ph), pw, ph
+	}
+	cx, cy := 4, 3
+	if h > w {
+		cx, cy = 3, 4
+	}
+
+	factors := make([][3]float64, 0, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					p := img.PixOffset(x, y)
+					for c := 0; c < 3; c++ {
+						f[c] += basis * srgbToLinear(img.Pix[p+c])
+					}
+				}
+			}
+			scale := norm / float64(w*h)
+			f[0], f[1], f[2] = f[0]*scale, f[1]*scale, f[2]*scale
+			factors = append(factors, f)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(base83((cx-1)+(cy-1)*9, 1))
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actual := 0.0
+		for _, f := range factors[1:] {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maxValue = float64(quantised+1) / 166
+		sb.WriteString(base83(quantised, 1))
+	} else {
+		sb.WriteString(base83(0, 1))
+	}
+	dc := factors[0]
+	sb.WriteString(base83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, f := range factors[1:] {
+		q := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		sb.WriteString(base83(q(f[0])*19*19+q(f[1])*19+q(f[2]), 2))
+	}
+	return sb.String()
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(f float64) int {
+	v := math.Max(0, math.Min(1, f))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func base83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = blurhashAlphabet[value%83]
+		value /= 83
+	}
+	return string(out)
+}
@@ -0,0 +1,287 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// jpegSegment construit un segment marqueur + longueur + charge utile.
+func jpegSegment(marker byte, payload []byte) []byte {
+	out := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(out[2:], uint16(len(payload)+2))
+	return append(out, payload...)
+}
+
+// exifOrientation construit la charge utile APP1 d'un EXIF portant seulement Orientation.
+func exifOrientation(order binary.ByteOrder, o uint16) []byte {
+	tiff := make([]byte, 26)
+	if order == binary.BigEndian {
+		copy(tiff, "MM")
+	} else {
+		copy(tiff, "II")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)                  // IFD0
+	order.PutUint16(tiff[8:], 1)                  // une entrée
+	order.PutUint16(tiff[10:], exifOrientationID) // tag
+	order.PutUint16(tiff[12:], 3)                 // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], o)
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+func buildJPEG(segments ...[]byte) []byte {
+	out := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	// Début du balayage, données compressées, fin d'image.
+	out = append(out, jpegSegment(0xDA, []byte{1, 2, 3})...)
+	return append(out, 0x11, 0x22, 0xFF, 0x00, 0x33, 0xFF, 0xD9)
+}
+
+func TestStripJPEG(t *testing.T) {
+	app0 := jpegSegment(0xE0, []byte("JFIF\x00\x01\x02"))
+	icc := jpegSegment(0xE2, []byte("ICC_PROFILE\x00"))
+	adobe := jpegSegment(0xEE, []byte("Adobe"))
+	exif := jpegSegment(0xE1, exifOrientation(binary.BigEndian, 6))
+	xmp := jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>"))
+	iptc := jpegSegment(0xED, []byte("Photoshop 3.0\x00"))
+	comment := jpegSegment(0xFE, []byte("GPS 48.85,2.35"))
+	dqt := jpegSegment(0xDB, []byte{0, 1, 2})
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"sans métadonnées", buildJPEG(app0, dqt), buildJPEG(app0, dqt)},
+		{"exif, xmp, iptc, commentaire", buildJPEG(app0, exif, xmp, dqt, iptc, comment), buildJPEG(app0, dqt)},
+		{"icc et adobe gardés", buildJPEG(app0, icc, exif, adobe, dqt), buildJPEG(app0, icc, adobe, dqt)},
+		{"remplissage 0xFF", append([]byte{0xFF, 0xD8, 0xFF}, buildJPEG(comment, dqt)[2:]...), buildJPEG(dqt)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripJPEG(tt.in)
+			if err != nil {
+				t.Fatalf("stripJPEG: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripJPEG =\n% x\nwant\n% x", got, tt.want)
+			}
+		})
+	}
+
+	for name, in := range map[string][]byte{
+		"vide":            nil,
+		"pas un jpeg":     []byte("\x89PNG\r\n\x1a\n"),
+		"segment tronqué": append([]byte{0xFF, 0xD8}, jpegSegment(0xE1, make([]byte, 40))[:20]...),
+		"octet parasite":  {0xFF, 0xD8, 0x00, 0x01},
+		"longueur < 2":    {0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xD9},
+	} {
+		if _, err := stripJPEG(in); !errors.Is(err, errBadImage) {
+			t.Errorf("stripJPEG(%s) err = %v; want errBadImage", name, err)
+		}
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want int
+	}{
+		{"sans exif", buildJPEG(jpegSegment(0xE0, []byte("JFIF\x00"))), 1},
+		{"big endian", buildJPEG(jpegSegment(0xE1, exifOrientation(binary.BigEndian, 6))), 6},
+		{"little endian", buildJPEG(jpegSegment(0xE1, exifOrientation(binary.LittleEndian, 8))), 8},
+		{"après app0", buildJPEG(jpegSegment(0xE0, []byte("JFIF\x00")), jpegSegment(0xE1, exifOrientation(binary.BigEndian, 3))), 3},
+		{"xmp seul", buildJPEG(jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"))), 1},
+		{"tronqué", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x40}, 1},
+		{"longueur invalide", buildJPEG(jpegSegment(0xE0, []byte("JFIF\x00")), []byte{0xFF, 0xE1, 0x00, 0x01}), 1},
+		{"longueur nulle", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x00, 0xFF, 0xD9}, 1},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.in); got != tt.want {
+			t.Errorf("jpegOrientation(%s) = %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	out := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	copy(out[4:], kind)
+	out = append(out, data...)
+	return append(out, 0xDE, 0xAD, 0xBE, 0xEF) // CRC non vérifié par stripPNG
+}
+
+func buildPNG(chunks ...[]byte) []byte {
+	out := []byte("\x89PNG\r\n\x1a\n")
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return out
+}
+
+func TestStripPNG(t *testing.T) {
+	ihdr := pngChunk("IHDR", make([]byte, 13))
+	idat := pngChunk("IDAT", []byte{1, 2, 3, 4})
+	iend := pngChunk("IEND", nil)
+	iccp := pngChunk("iCCP", []byte("profil"))
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"sans métadonnées", buildPNG(ihdr, idat, iend), buildPNG(ihdr, idat, iend)},
+		{
+			"textes, exif, horodatage",
+			buildPNG(ihdr, pngChunk("tEXt", []byte("Author\x00moi")), pngChunk("eXIf", []byte("MM")), iccp,
+				pngChunk("zTXt", []byte("x")), pngChunk("iTXt", []byte("y")), pngChunk("tIME", make([]byte, 7)), idat, iend),
+			buildPNG(ihdr, iccp, idat, iend),
+		},
+		{"après IEND ignoré", buildPNG(ihdr, idat, iend, pngChunk("tEXt", []byte("x"))), buildPNG(ihdr, idat, iend)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripPNG(tt.in)
+			if err != nil {
+				t.Fatalf("stripPNG: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripPNG =\n% x\nwant\n% x", got, tt.want)
+			}
+		})
+	}
+
+	for name, in := range map[string][]byte{
+		"pas un png":      []byte("GIF89a"),
+		"sans IEND":       buildPNG(ihdr, idat),
+		"bloc tronqué":    buildPNG(ihdr, pngChunk("IDAT", make([]byte, 10))[:14]),
+		"signature seule": buildPNG(),
+	} {
+		if _, err := stripPNG(in); !errors.Is(err, errBadImage) {
+			t.Errorf("stripPNG(%s) err = %v; want errBadImage", name, err)
+		}
+	}
+}
+
+func riffChunk(fourcc string, data []byte) []byte {
+	out := make([]byte, 8, 9+len(data))
+	copy(out, fourcc)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func buildWebP(chunks ...[]byte) []byte {
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := func(flags byte) []byte { return riffChunk("VP8X", []byte{flags, 0, 0, 0, 9, 0, 0, 9, 0, 0}) }
+	image := riffChunk("VP8L", []byte{0x2F, 1, 2}) // taille impaire: octet de bourrage
+	icc := riffChunk("ICCP", []byte("profil"))
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"simple", buildWebP(image), buildWebP(image)},
+		{
+			"exif et xmp",
+			buildWebP(vp8x(0x20|0x08|0x04), icc, image, riffChunk("EXIF", []byte("MM\x00*")), riffChunk("XMP ", []byte("<x/>"))),
+			buildWebP(vp8x(0x20), icc, image),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripWebP(tt.in)
+			if err != nil {
+				t.Fatalf("stripWebP: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripWebP =\n% x\nwant\n% x", got, tt.want)
+			}
+		})
+	}
+
+	for name, in := range map[string][]byte{
+		"pas un webp":  []byte("RIFF\x04\x00\x00\x00WAVE"),
+		"trop court":   []byte("RIFF"),
+		"bloc tronqué": append(buildWebP(), riffChunk("VP8L", make([]byte, 20))[:12]...),
+	} {
+		if _, err := stripWebP(in); !errors.Is(err, errBadImage) {
+			t.Errorf("stripWebP(%s) err = %v; want errBadImage", name, err)
+		}
+	}
+}
+
+func TestFitWithin(t *testing.T) {
+	tests := []struct {
+		w, h, side   int
+		wantW, wantH int
+	}{
+		{100, 50, 200, 100, 50},
+		{200, 200, 200, 200, 200},
+		{400, 200, 100, 100, 50},
+		{200, 400, 100, 50, 100},
+		{300, 300, 32, 32, 32},
+		{10000, 10, 100, 100, 1},
+		{10, 10000, 100, 1, 100},
+	}
+	for _, tt := range tests {
+		if w, h := fitWithin(tt.w, tt.h, tt.side); w != tt.wantW || h != tt.wantH {
+			t.Errorf("fitWithin(%d, %d, %d) = %d, %d; want %d, %d", tt.w, tt.h, tt.side, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func uniformImage(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestBlurHash(t *testing.T) {
+	grad := image.NewRGBA(image.Rect(0, 0, 16, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 16; x++ {
+			grad.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 20), 128, 255})
+		}
+	}
+	// Valeurs de référence calculées avec l'algorithme de https://blurha.sh.
+	tests := []struct {
+		name string
+		img  *image.RGBA
+		want string
+	}{
+		{"paysage noir", uniformImage(8, 6, color.RGBA{0, 0, 0, 255}), "L00000fQfQfQfQfQfQfQfQfQfQfQ"},
+		{"portrait blanc", uniformImage(6, 8, color.RGBA{255, 255, 255, 255}), "TsTSUA~qfQ_3t7fQfQfQfQ_3t7fQ"},
+		{"rouge réduit à 32x21", uniformImage(300, 200, color.RGBA{255, 0, 0, 255}), "LFTI:j;$fQ;$|co1fQo1fQfQfQfQ"},
+		{"dégradé", grad, "LsGuUU2@wxozqlR-jte=g0fjfQfj"},
+	}
+	for _, tt := range tests {
+		if got := blurHash(tt.img); got != tt.want {
+			t.Errorf("blurHash(%s) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// wsInbound est une trame reçue du client. Sans type (ou "message"), c'est un message de chat.
+// Autres types: resume, edit, delete, react, unreact, typing.start, typing.stop,
+// presence.subscribe, read, subscribe (instantané du salon: épingles, annonces), unsubscribe,
+// pin, unpin, poll.vote.
+type wsInbound struct {
+	Type        string   `json:"type"`
+	ID          string   `json:"id"` // message visé (edit, delete, react, unreact, read)
+	Emoji       string   `json:"emoji"`
+	Resume      string   `json:"resume"`
+	Text        string   `json:"text"`
+	Room        string   `json:"room"`
+	ClientMsgID string   `json:"client_msg_id"` // facultatif, active ack/nack et déduplication
+	ReplyTo     string   `json:"reply_to"`      // facultatif: message cité
+	ThreadRoot  string   `json:"thread_root"`   // facultatif: racine du fil de discussion
+	Users       []string `json:"users"`         // presence.subscribe: ids suivis
+	Seq         int64    `json:"seq"`           // read: dernier message lu (ou id)
+	Attachments []string `json:"attachments"`   // facultatif: ids renvoyés par POST /api/attachments
+	Options     []int    `json:"options"`       // poll.vote: indices des options choisies
+
+	command string // réponse publique d'une commande slash (non lu du client)
+}
+
+// subscribeRoom abonne la connexion au salon (trame subscribe, ?room= de la poignée
+// de main): join pour les webhooks à la première connexion de l'utilisateur, puis instantané.
+func subscribeRoom(conn *websocket.Conn, user WSUser, room string) {
+	if wsHub.subscribe(conn, room) {
+		presenceJoined(user, room)
+		emitWebhook(room, hookJoin, memberEvent(user, "subscribe"))
+	}
+	sendRoomSnapshot(conn, room)
+}
+
+// handleInbound traite une trame reçue sur la connexion.
+func handleInbound(conn *websocket.Conn, connID string, user WSUser, in wsInbound) {
+	if in.Room != "" && !validRoomName(in.Room) {
+		reject(conn, in, in.Room, nackInvalidRoom, "", 0)
+		return
+	}
+	if in.Room != "" && !canReadRoom(context.Background(), user, in.Room) {
+		reject(conn, in, in.Room, nackForbidden, "", 0)
+		return
+	}
+	if user.Guest && guestWrites(in.Type) {
+		room := in.Room
+		if room == "" {
+			room = "general"
+		}
+		if guestAccess(context.Background(), room) != guestWrite {
+			reject(conn, in, room, nackGuestReadOnly, "", 0)
+			return
+		}
+	}
+
+	// Les trames qui produisent une diffusion passent par les seaux à jetons.
+	switch in.Type {
+	case "", "message", "edit", "delete", "react", "unreact", "poll.vote":
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		ok, wait := allowFrame(ctx, connID, user)
+		if !ok {
+			room := in.Room
+			if room == "" {
+				room = "general"
+			}
+			reject(conn, in, room, nackLimited, in.Type, wait)
+			recordFlood(ctx, user, room)
+		}
+		cancel()
+		if !ok {
+			return
+		}
+	}
+
+	var err error
+	switch in.Type {
+	case "resume":
+		resumeRoom(conn, in.Room, in.Resume)
+		return
+	case "subscribe":
+		room := in.Room
+		if room == "" {
+			room = "general"
+		}
+		subscribeRoom(conn, user, room)
+		return
+	case "unsubscribe":
+		room := in.Room
+		if room == "" {
+			room = "general"
+		}
+		if wsHub.unsubscribe(conn, room) {
+			presenceLeft(user, room)
+			emitWebhook(room, hookLeave, memberEvent(user, "unsubscribe"))
+		}
+		return
+	case "pin":
+		_, err = pinMessage(context.Background(), user, in.ID)
+	case "unpin":
+		_, err = unpinMessage(context.Background(), user, in.ID)
+	case "presence.subscribe":
+		watchPresence(conn, in.Users)
+		return
+	case "read":
+		_, err = markRead(context.Background(), user, in.Room, in.ID, in.Seq)
+	case "typing.start", "typing.stop":
+		handleTyping(conn, user, in.Room, in.Type == "typing.start")
+		return
+	case "edit":
+		_, err = editMessage(context.Background(), user, in.ID, in.Text)
+	case "delete":
+		_, err = deleteMessage(context.Background(), user, in.ID)
+	case "react":
+		_, _, err = addReaction(context.Background(), user, in.ID, in.Emoji)
+	case "unreact":
+		_, _, err = removeReaction(context.Background(), user, in.ID, in.Emoji)
+	case "poll.vote":
+		_, err = castVote(context.Background(), user, in.ID, in.Options)
+	default:
+		handleChatMessage(conn, user, in)
+		return
+	}
+	if err != nil {
+		wsHub.send(conn, WSError{Type: "error", Code: errorCode(err), Detail: in.Type + " " + in.ID})
+	}
+}
+
+// reject refuse une trame: nack si le client attend un ack, évènement error sinon.
+func reject(conn *websocket.Conn, in wsInbound, room, code, detail string, retryAfter time.Duration) {
+	if in.ClientMsgID != "" {
+		wsHub.send(conn, WSAck{Type: "nack", ClientMsgID: in.ClientMsgID, Room: room, Reason: code, RetryAfter: retryAfter.Milliseconds()})
+		return
+	}
+	wsHub.send(conn, WSError{Type: "error", Code: code, Room: room, Detail: detail, RetryAfter: retryAfter.Milliseconds()})
+}
+
+// handleChatMessage met un message de chat en file de persistance.
+func handleChatMessage(conn *websocket.Conn, user WSUser, in wsInbound) {
+	room := in.Room
+	if room == "" {
+		room = "general"
+	}
+	// Identité: celle de la connexion (compte, ou pseudonyme d'invité attribué à la connexion)
+	sender := user.Username
+
+	// Commandes slash: traitées ici, avant toute persistance ou diffusion.
+	if in.command == "" {
+		if name, args, ok := parseCommand(in.Text); ok && len(in.Attachments) == 0 {
+			runCommand(commandCall{conn: conn, user: user, room: room, name: name, args: args, in: in})
+			return
+		}
+		if strings.HasPrefix(in.Text, "//") {
+			in.Text = in.Text[1:]
+		}
+	}
+
+	if strings.TrimSpace(in.Text) == "" && len(in.Attachments) == 0 {
+		if in.ClientMsgID != "" {
+			wsHub.send(conn, WSAck{Type: "nack", ClientMsgID: in.ClientMsgID, Reason: nackEmptyText})
+		}
+		return
+	}
+
+	// Bans et mutes sont vérifiés avant toute persistance ou diffusion.
+	if reason, until := sanctionReason(context.Background(), user, room); reason != "" {
+		reject(conn, in, room, reason, until, 0)
+		return
+	}
+	if a, b, ok := dmParticipants(room); ok {
+		other := a
+		if other == user.ID {
+			other = b
+		}
+		if isBlocked(context.Background(), other, user.ID) {
+			reject(conn, in, room, nackBlocked, "", 0)
+			return
+		}
+	}
+	// Un renvoi d'un message déjà stocké retrouve son ack d'origine, sans
+	// repasser par le slow mode.
+	if in.ClientMsgID != "" {
+		if existing, ok := findDuplicate(context.Background(), authorKey(user.ID, sender), in.ClientMsgID); ok {
+			ackDuplicate(persistItem{ClientMsgID: in.ClientMsgID, Conn: conn}, existing)
+			return
+		}
+	}
+	wait, releaseSlot := slowModeWait(context.Background(), user, room)
+	if wait > 0 {
+		reject(conn, in, room, nackSlowMode, "", wait)
+		return
+	}
+
+	attachments, err := pendingAttachments(context.Background(), user, in.Attachments)
+	if err != nil {
+		releaseSlot()
+		reject(conn, in, room, nackAttachment, errorCode(err), 0)
+		return
+	}
+
+	// Chaîne de filtres: refus, expurgation ou signalement pour revue.
+	filtered := runFilters(context.Background(), FilterInput{
+		Text:      in.Text,
+		Room:      room,
+		UserID:    user.ID,
+		Username:  sender,
+		AuthorKey: rateSubject(user),
+	})
+	if filtered.Rejected {
+		releaseSlot()
+		reject(conn, in, room, nackRejected, filtered.Reason, 0)
+		return
+	}
+
+	// Identifiant et horodatage canoniques attribués dès réception;
+	// la diffusion et l'ack sont faits par le worker une fois le message stocké.
+	select {
+	case persistQueue <- persistItem{
+		ID:          primitive.NewObjectID(),
+		UserID:      user.ID, // vide si invité
+		Username:    sender,  // username affiché
+		Room:        room,
+		Text:        filtered.Text,
+		Timestamp:   time.Now().UTC(),
+		ClientMsgID: in.ClientMsgID,
+		ReplyTo:     in.ReplyTo,
+		ThreadRoot:  in.ThreadRoot,
+		Flags:       filtered.Flags,
+		Redacted:    filtered.Text != in.Text,
+		Attachments: attachments,
+		Command:     in.command,
+		Conn:        conn,
+		ReleaseSlot: releaseSlot,
+	}:
+	default:
+		// File pleine: on drop et on log (stratégie simple, à ajuster si besoin)
+		releaseSlot()
+		if in.ClientMsgID != "" {
+			wsHub.send(conn, WSAck{Type: "nack", ClientMsgID: in.ClientMsgID, Reason: nackQueueFull})
+		}
+		log.Printf("persist queue full: dropping message from user=%s", sender)
+	}
+}
@@ -0,0 +1,301 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Au plus ce nombre de @username distincts sont résolus par message.
+const maxMentionsPerMessage = 50
+
+// @nom précédé d'un début de texte ou d'un séparateur (pas d'adresse email).
+var mentionRe = regexp.MustCompile(`(?:^|[^\w@])@([\p{L}\p{N}_.\-]+)`)
+
+// parseMentions extrait les @username distincts et les mentions collectives @room/@here.
+func parseMentions(text string) (names []string, room, here bool) {
+	seen := map[string]bool{}
+	for _, m := range mentionRe.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimRight(m[1], ".-")
+		switch strings.ToLower(name) {
+		case "":
+			continue
+		case "room", "channel", "everyone":
+			room = true
+			continue
+		case "here":
+			here = true
+			continue
+		}
+		if !seen[name] && len(names) < maxMentionsPerMessage {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, room, here
+}
+
+// resolveMentions renseigne les mentions du message à partir de son contenu.
+// Les @username inconnus de UsersCol sont ignorés.
+func resolveMentions(ctx context.Context, m *models.Message) {
+	names, room, here := parseMentions(m.Content)
+	m.MentionRoom, m.MentionHere = room, here
+	if len(names) == 0 {
+		return
+	}
+	cur, err := db.UsersCol.Find(ctx, bson.M{"username": bson.M{"$in": names}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Printf("resolve mentions: %v", err)
+		return
+	}
+	var users []models.User
+	if err := cur.All(ctx, &users); err != nil {
+		log.Printf("resolve mentions: %v", err)
+		return
+	}
+	for _, u := range users {
+		m.Mentions = append(m.Mentions, u.ID.Hex())
+	}
+}
+
+// mentionRecipients calcule les destinataires et le type de mention de chacun.
+// @here: utilisateurs connectés qui suivent le salon; @room: en plus, les membres
+// du salon privé ou du DM (un salon public n'a pas d'autre liste que ses abonnés).
+func mentionRecipients(ctx context.Context, m models.Message) (map[string]string, error) {
+	allow, err := roomAudience(ctx, m.Room)
+	if err != nil {
+		return nil, err
+	}
+	kinds := map[string]string{}
+	if m.MentionRoom || m.MentionHere {
+		kind := "here"
+		if m.MentionRoom {
+			kind = "room"
+		}
+		for _, id := range wsHub.subscriberIDs(m.Room, allow) {
+			kinds[id] = kind
+		}
+		if m.MentionRoom {
+			for _, id := range roomMemberIDs(ctx, m.Room) {
+				kinds[id] = kind
+			}
+		}
+	}
+	for _, id := range m.Mentions {
+		if allow == nil || allow(WSUser{ID: id, Authenticated: true}) {
+			kinds[id] = "user" // la mention directe prime
+		}
+	}
+	delete(kinds, m.UserID)
+	delete(kinds, "")
+	return kinds, nil
+}
+
+// roomMemberIDs: participants d'un DM ou membres d'un salon privé (vide pour un salon public).
+func roomMemberIDs(ctx context.Context, room string) []string {
+	if a, b, ok := dmParticipants(room); ok {
+		return []string{a, b}
+	}
+	r, err := loadRoom(ctx, room)
+	if err != nil || r == nil || !r.Private {
+		return nil
+	}
+	return append([]string{r.OwnerID}, r.Members...)
+}
+
+// mutedUsers renvoie, parmi ids, ceux qui ont rendu le salon muet.
+func mutedUsers(ctx context.Context, room string, ids []string) map[string]bool {
+	out := map[string]bool{}
+	cur, err := db.RoomSettingsCol.Find(ctx, bson.M{"room": room, "muted": true, "user_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Printf("muted users %s: %v", room, err)
+		return out
+	}
+	var settings []models.RoomSettings
+	if err := cur.All(ctx, &settings); err != nil {
+		return out
+	}
+	for _, s := range settings {
+		out[s.UserID] = true
+	}
+	return out
+}
+
+// notifyMentions stocke une notification par destinataire et la pousse à toutes
+// ses connexions, qu'il suive le salon ou non. Un salon muet ne notifie pas.
+func notifyMentions(m models.Message) {
+	if len(m.Mentions) == 0 && !m.MentionRoom && !m.MentionHere {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kinds, err := mentionRecipients(ctx, m)
+	if err != nil {
+		log.Printf("mention recipients %s: %v", m.ID.Hex(), err)
+		return
+	}
+	ids := make([]string, 0, len(kinds))
+	for id := range kinds {
+		ids = append(ids, id)
+	}
+	muted := mutedUsers(ctx, m.Room, ids)
+	for id := range blockersOf(ctx, m.UserID, ids) {
+		muted[id] = true // l'auteur est bloqué par le destinataire
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var notifs []models.Notification
+	var docs []interface{}
+	for _, id := range ids {
+		if muted[id] {
+			continue
+		}
+		n := models.Notification{
+			ID:        primitive.NewObjectID(),
+			UserID:    id,
+			Type:      "mention",
+			Kind:      kinds[id],
+			MessageID: m.ID.Hex(),
+			Room:      m.Room,
+			From:      m.Sender,
+			Excerpt:   excerpt(m.Content, quoteExcerptLen),
+			CreatedAt: now,
+		}
+		notifs = append(notifs, n)
+		docs = append(docs, n)
+	}
+	if len(docs) == 0 {
+		return
+	}
+	if _, err := db.NotificationsCol.InsertMany(ctx, docs); err != nil {
+		log.Printf("store notifications %s: %v", m.ID.Hex(), err)
+		return
+	}
+	for _, n := range notifs {
+		wsHub.sendToUser(n.UserID, WSEvent{
+			Type:      "notification",
+			Room:      n.Room,
+			MessageID: n.MessageID,
+			Data:      n,
+			Timestamp: now.Time().UTC(),
+		})
+	}
+}
+
+func unreadCount(ctx context.Context, userID string) (int64, error) {
+	return db.NotificationsCol.CountDocuments(ctx, bson.M{"user_id": userID, "read": false})
+}
+
+// notificationsHandler: GET /api/notifications?unread=true&limit=&before=<id>
+func notificationsHandler(c *gin.Context) {
+	me := authViewer(c)
+	filter := bson.M{"user_id": me.ID}
+	if c.Query("unread") == "true" {
+		filter["read"] = false
+	}
+	if before := c.Query("before"); before != "" {
+		oid, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": oid}
+	}
+	limit := 50
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cur, err := db.NotificationsCol.Find(c, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	notifs := []models.Notification{}
+	if err := cur.All(c, &notifs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	unread, err := unreadCount(c, me.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	var next interface{}
+	if len(notifs) == limit {
+		next = notifs[len(notifs)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": notifs, "unread_count": unread, "next": next})
+}
+
+// markNotificationsReadHandler: POST /api/notifications/read {ids: [...]} ou {all: true}
+func markNotificationsReadHandler(c *gin.Context) {
+	var req struct {
+		IDs []string `json:"ids"`
+		All bool     `json:"all"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (!req.All && len(req.IDs) == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids or all required"})
+		return
+	}
+	me := authViewer(c)
+	filter := bson.M{"user_id": me.ID, "read": false}
+	if !req.All {
+		oids := make([]primitive.ObjectID, 0, len(req.IDs))
+		for _, id := range req.IDs {
+			if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+				oids = append(oids, oid)
+			}
+		}
+		filter["_id"] = bson.M{"$in": oids}
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	res, err := db.NotificationsCol.UpdateMany(c, filter, bson.M{"$set": bson.M{"read": true, "read_at": now}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	unread, _ := unreadCount(c, me.ID)
+	c.JSON(http.StatusOK, gin.H{"updated": res.ModifiedCount, "unread_count": unread})
+}
+
+// roomSettingsHandler: PUT /api/rooms/:room/settings {muted}
+func roomSettingsHandler(c *gin.Context) {
+	var req struct {
+		Muted bool `json:"muted"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	me := authViewer(c)
+	room := c.Param("room")
+	if !canReadRoom(c, me, room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	settings := models.RoomSettings{UserID: me.ID, Room: room, Muted: req.Muted}
+	_, err := db.RoomSettingsCol.UpdateOne(c,
+		bson.M{"user_id": me.ID, "room": room},
+		bson.M{"$set": bson.M{"muted": req.Muted}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		text      string
+		names     []string
+		room, all bool
+	}{
+		{"bonjour", nil, false, false},
+		{"@alice tu viens ?", []string{"alice"}, false, false},
+		{"cc @alice, @bob.", []string{"alice", "bob"}, false, false},
+		{"@alice @alice @Alice", []string{"alice", "Alice"}, false, false},
+		{"@Élodie et @jean-luc", []string{"Élodie", "jean-luc"}, false, false},
+		{"écris à moi@example.com", nil, false, false},
+		{"@@alice", nil, false, false},
+		{"@here réunion", nil, false, true},
+		{"@room @channel @everyone", nil, true, false},
+		{"@HERE @Room @bob", []string{"bob"}, true, true},
+		{"fin de phrase @bob-", []string{"bob"}, false, false},
+	}
+	for _, tt := range tests {
+		names, room, here := parseMentions(tt.text)
+		if !reflect.DeepEqual(names, tt.names) || room != tt.room || here != tt.all {
+			t.Errorf("parseMentions(%q) = %v, %v, %v; want %v, %v, %v", tt.text, names, room, here, tt.names, tt.room, tt.all)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < maxMentionsPerMessage+10; i++ {
+		fmt.Fprintf(&b, "@u%d ", i)
+	}
+	if names, _, _ := parseMentions(b.String()); len(names) != maxMentionsPerMessage {
+		t.Errorf("parseMentions: %d noms; want plafond %d", len(names), maxMentionsPerMessage)
+	}
+}
+
+func TestHubSubscriberIDs(t *testing.T) {
+	h := &hub{conns: make(map[*websocket.Conn]*client), gone: make(map[*websocket.Conn]*client)}
+	tab1, tab2, bob, carol, guest := &websocket.Conn{}, &websocket.Conn{}, &websocket.Conn{}, &websocket.Conn{}, &websocket.Conn{}
+	h.add(tab1, WSUser{ID: "alice", Authenticated: true})
+	h.add(tab2, WSUser{ID: "alice", Authenticated: true})
+	h.add(bob, WSUser{ID: "bob", Authenticated: true})
+	h.add(carol, WSUser{ID: "carol", Authenticated: true})
+	h.add(guest, WSUser{Username: "Invité-1"})
+	for _, c := range []*websocket.Conn{tab1, tab2, bob, guest} {
+		h.subscribe(c, "dev")
+	}
+	h.subscribe(carol, "general")
+
+	tests := []struct {
+		name  string
+		room  string
+		allow func(WSUser) bool
+		want  []string
+	}{
+		{"abonnés, sans doublon ni invité", "dev", nil, []string{"alice", "bob"}},
+		{"filtre allow", "dev", func(u WSUser) bool { return u.ID == "bob" }, []string{"bob"}},
+		{"autre salon", "general", nil, []string{"carol"}},
+		{"salon sans abonné", "random", nil, nil},
+	}
+	for _, tt := range tests {
+		got := h.subscriberIDs(tt.room, tt.allow)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("subscriberIDs(%s) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
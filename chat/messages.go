@@ -0,0 +1,263 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errNotFound  = errors.New("not_found")
+	errForbidden = errors.New("forbidden")
+	errInvalid   = errors.New("invalid")
+	errConflict  = errors.New("conflict")
+	errLimit     = errors.New("limit_reached")
+	errRejected  = errors.New("content_rejected") // refusé par la chaîne de filtres
+)
+
+// errorStatus traduit les erreurs des opérations sur les messages en statut HTTP.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errConflict):
+		return http.StatusConflict
+	case errors.Is(err, errLimit), errors.Is(err, errRejected):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCode est le code renvoyé au client WS pour une erreur d'opération.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, errForbidden), errors.Is(err, errInvalid), errors.Is(err, errConflict), errors.Is(err, errLimit), errors.Is(err, errRejected):
+		return err.Error()
+	default:
+		return "server_error"
+	}
+}
+
+// isGlobalModerator: rôle "moderator" ou "admin" sur le compte.
+func isGlobalModerator(ctx context.Context, u WSUser) bool {
+	if !u.Authenticated || u.ID == "" {
+		return false
+	}
+	oid, err := primitive.ObjectIDFromHex(u.ID)
+	if err != nil {
+		return false
+	}
+	var user models.User
+	if err := db.UsersCol.FindOne(ctx, bson.M{"_id": oid}).Decode(&user); err != nil {
+		return false
+	}
+	return user.Role == "moderator" || user.Role == "admin"
+}
+
+func loadMessage(ctx context.Context, id string) (models.Message, error) {
+	var m models.Message
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return m, errInvalid
+	}
+	if err := db.MessagesCol.FindOne(ctx, bson.M{"_id": oid}).Decode(&m); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return m, errNotFound
+		}
+		return m, err
+	}
+	return m, nil
+}
+
+func isAuthor(u WSUser, m models.Message) bool {
+	return u.Authenticated && u.ID != "" && m.UserID == u.ID
+}
+
+// editMessage remplace le contenu d'un message de l'auteur et archive la version précédente.
+func editMessage(ctx context.Context, actor WSUser, id, text string) (models.Message, error) {
+	if strings.TrimSpace(text) == "" {
+		return models.Message{}, errInvalid
+	}
+	m, err := loadMessage(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, errNotFound
+	}
+	if !isAuthor(actor, m) {
+		return m, errForbidden
+	}
+	if m.Poll != nil {
+		return m, errConflict // la question d'un sondage ne change plus après publication
+	}
+	if m.Hidden {
+		return m, errConflict // masqué en attente de revue: l'édition le republierait
+	}
+	if reason, _ := sanctionReason(ctx, actor, m.Room); reason != "" {
+		return m, errForbidden // banni ou muet: l'édition republierait du contenu
+	}
+	filtered := runFilters(ctx, FilterInput{
+		Text:      text,
+		Room:      m.Room,
+		UserID:    actor.ID,
+		Username:  actor.Username,
+		AuthorKey: rateSubject(actor),
+		Edit:      true,
+	})
+	if filtered.Rejected {
+		return m, errRejected
+	}
+	text = filtered.Text
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	// Filtre sur l'ancien contenu: une édition concurrente fait échouer celle-ci.
+	res, err := db.MessagesCol.UpdateOne(ctx,
+		bson.M{"_id": m.ID, "content": m.Content, "deleted": bson.M{"$ne": true}, "hidden": bson.M{"$ne": true}},
+		bson.M{
+			"$set":  bson.M{"content": text, "edited_at": now},
+			"$push": bson.M{"edits": models.MessageEdit{Content: m.Content, EditedAt: now, EditorID: actor.ID}},
+		},
+	)
+	if err != nil {
+		return m, err
+	}
+	if res.MatchedCount == 0 {
+		return m, errConflict
+	}
+	m.Edits = append(m.Edits, models.MessageEdit{Content: m.Content, EditedAt: now, EditorID: actor.ID})
+	m.Content = text
+	m.EditedAt = &now
+
+	if err := currentSearch().Index(ctx, m); err != nil {
+		log.Printf("search index failed: %v", err)
+	}
+	if len(filtered.Flags) > 0 {
+		flagForReview(ctx, m, filtered.Flags, true)
+	}
+	publish(ctx, WSEvent{
+		Type:      "message.edited",
+		Room:      m.Room,
+		MessageID: m.ID.Hex(),
+		Data:      gin.H{"text": m.Content, "edited_at": now.Time().UTC()},
+		Timestamp: time.Now().UTC(),
+	})
+	return m, nil
+}
+
+// deleteMessage laisse un tombstone: contenu et historique vidés, document conservé.
+// L'auteur peut supprimer son message, un modérateur (global ou du salon) n'importe lequel.
+func deleteMessage(ctx context.Context, actor WSUser, id string) (models.Message, error) {
+	m, err := loadMessage(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, errNotFound
+	}
+	if !isAuthor(actor, m) && !canModerate(ctx, actor, m.Room) {
+		return m, errForbidden
+	}
+	return tombstone(ctx, m, actor.ID)
+}
+
+// tombstone vide le message, le retire de la recherche et diffuse la suppression.
+func tombstone(ctx context.Context, m models.Message, actorID string) (models.Message, error) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	// Filtre sur deleted: une suppression concurrente ne décompte pas deux fois la réponse.
+	res, err := db.MessagesCol.UpdateOne(ctx,
+		bson.M{"_id": m.ID, "deleted": bson.M{"$ne": true}},
+		bson.M{
+			"$set":   bson.M{"content": "", "deleted": true, "deleted_at": now, "deleted_by": actorID},
+			"$unset": bson.M{"edits": "", "attachments": "", "poll": ""},
+		},
+	)
+	if err != nil {
+		return m, err
+	}
+	if res.MatchedCount == 0 {
+		return m, errNotFound
+	}
+	m.Content, m.Edits, m.Deleted, m.DeletedAt, m.DeletedBy = "", nil, true, &now, actorID
+	if m.PinnedAt != nil && dropPin(ctx, m, actorID) {
+		m.PinnedAt, m.PinnedBy = nil, ""
+	}
+	if len(m.Attachments) > 0 {
+		detachAttachments(ctx, m.ID.Hex())
+		m.Attachments = nil
+	}
+	if m.Poll != nil {
+		if _, err := db.PollVotesCol.DeleteMany(ctx, bson.M{"message_id": m.ID.Hex()}); err != nil {
+			log.Printf("delete poll votes %s: %v", m.ID.Hex(), err)
+		}
+		m.Poll = nil
+	}
+
+	if m.ThreadRoot != "" {
+		unbumpThread(ctx, m)
+	}
+	setQuoteExcerpts(ctx, m.ID.Hex(), "")
+	if err := currentSearch().Remove(ctx, m.ID.Hex()); err != nil {
+		log.Printf("search remove failed: %v", err)
+	}
+	publish(ctx, WSEvent{
+		Type:      "message.deleted",
+		Room:      m.Room,
+		MessageID: m.ID.Hex(),
+		Data:      gin.H{"deleted_at": now.Time().UTC()},
+		Timestamp: time.Now().UTC(),
+	})
+	return m, nil
+}
+
+// publish diffuse un évènement aux lecteurs autorisés du salon.
+func publish(ctx context.Context, ev WSEvent) {
+	allow, err := roomAudience(ctx, ev.Room)
+	if err != nil {
+		log.Printf("room audience %s: %v", ev.Room, err)
+		return
+	}
+	wsHub.broadcastTo(ev, nil, allow)
+}
+
+// editMessageHandler: PATCH /api/messages/:id {text}
+func editMessageHandler(c *gin.Context) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	m, err := editMessage(c, authViewer(c), c.Param("id"), req.Text)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusOK, historyItem(m))
+}
+
+// deleteMessageHandler: DELETE /api/messages/:id
+func deleteMessageHandler(c *gin.Context) {
+	m, err := deleteMessage(c, authViewer(c), c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusOK, historyItem(m))
+}
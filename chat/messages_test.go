@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+)
+
+func TestErrorStatusAndCode(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errNotFound, http.StatusNotFound, "not_found"},
+		{errForbidden, http.StatusForbidden, "forbidden"},
+		{errInvalid, http.StatusBadRequest, "invalid"},
+		{errConflict, http.StatusConflict, "conflict"},
+		{errLimit, http.StatusUnprocessableEntity, "limit_reached"},
+		{errRejected, http.StatusUnprocessableEntity, "content_rejected"},
+		{fmt.Errorf("edit: %w", errForbidden), http.StatusForbidden, "edit: forbidden"},
+		{errors.New("mongo: connexion perdue"), http.StatusInternalServerError, "server_error"},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.status {
+			t.Errorf("errorStatus(%v) = %d; want %d", tt.err, got, tt.status)
+		}
+		if got := errorCode(tt.err); got != tt.code {
+			t.Errorf("errorCode(%v) = %q; want %q", tt.err, got, tt.code)
+		}
+	}
+}
+
+func TestIsAuthor(t *testing.T) {
+	m := models.Message{UserID: "u1", Sender: "alice"}
+	tests := []struct {
+		name string
+		u    WSUser
+		want bool
+	}{
+		{"auteur", WSUser{ID: "u1", Authenticated: true}, true},
+		{"autre compte", WSUser{ID: "u2", Authenticated: true}, false},
+		{"non authentifié", WSUser{ID: "u1"}, false},
+		{"invité homonyme", WSUser{Username: "alice", Guest: true}, false},
+	}
+	for _, tt := range tests {
+		if got := isAuthor(tt.u, m); got != tt.want {
+			t.Errorf("isAuthor(%s) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+	if isAuthor(WSUser{Authenticated: true}, models.Message{Sender: "invité"}) {
+		t.Errorf("isAuthor(compte sans id, message d'invité) = true; want false")
+	}
+}
@@ -0,0 +1,509 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Types d'actions de modération.
+const (
+	modKick   = "kick"
+	modBan    = "ban"
+	modUnban  = "unban"
+	modMute   = "mute"
+	modUnmute = "unmute"
+	modPurge  = "purge" // suppression des messages récents de la cible
+)
+
+const (
+	defaultMuteDuration = 10 * time.Minute
+	defaultPurgeWindow  = time.Hour
+	maxPurgeMessages    = 200
+	sanctionCacheTTL    = 5 * time.Second
+)
+
+// roomSanctions sont les bans et mutes actifs d'un salon, gardés en cache quelques
+// secondes: ils sont vérifiés à chaque message et à chaque diffusion.
+type roomSanctions struct {
+	bannedUsers map[string]bool
+	bannedIPs   map[string]bool
+	mutedUsers  map[string]time.Time // zéro: sans échéance
+	mutedIPs    map[string]time.Time
+	loadedAt    time.Time
+}
+
+var (
+	sanctionsMu    sync.Mutex
+	sanctionsCache = map[string]*roomSanctions{}
+)
+
+// banned: par id pour un compte, par IP pour un invité.
+func (s *roomSanctions) banned(u WSUser) bool {
+	if u.Authenticated {
+		return s.bannedUsers[u.ID]
+	}
+	return u.IP != "" && s.bannedIPs[u.IP]
+}
+
+// muted renvoie l'échéance du mute en cours (zéro si sans échéance).
+func (s *roomSanctions) muted(u WSUser) (time.Time, bool) {
+	var until time.Time
+	var ok bool
+	if u.Authenticated {
+		until, ok = s.mutedUsers[u.ID]
+	} else if u.IP != "" {
+		until, ok = s.mutedIPs[u.IP]
+	}
+	if ok && !until.IsZero() && time.Now().After(until) {
+		return time.Time{}, false
+	}
+	return until, ok
+}
+
+func activeSanctionFilter(room string) bson.M {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	return bson.M{
+		"room":    room,
+		"type":    bson.M{"$in": bson.A{modBan, modMute}},
+		"revoked": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": now}},
+		},
+	}
+}
+
+// bannedRooms liste les salons dont u est banni (par id pour un compte, par IP pour un invité).
+func bannedRooms(ctx context.Context, u WSUser) ([]string, error) {
+	filter := activeSanctionFilter("")
+	delete(filter, "room")
+	filter["type"] = modBan
+	switch {
+	case u.Authenticated && u.ID != "":
+		filter["target_user_id"] = u.ID
+	case !u.Authenticated && u.IP != "":
+		filter["target_ip"] = u.IP
+	default:
+		return nil, nil
+	}
+	rooms, err := db.ModerationCol.Distinct(ctx, "room", filter)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(rooms))
+	for _, r := range rooms {
+		if name, ok := r.(string); ok {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// sanctionsFor renvoie les sanctions actives du salon (cache de sanctionCacheTTL).
+func sanctionsFor(ctx context.Context, room string) (*roomSanctions, error) {
+	sanctionsMu.Lock()
+	s, ok := sanctionsCache[room]
+	sanctionsMu.Unlock()
+	if ok && time.Since(s.loadedAt) < sanctionCacheTTL {
+		return s, nil
+	}
+
+	cur, err := db.ModerationCol.Find(ctx, activeSanctionFilter(room))
+	if err != nil {
+		return nil, err
+	}
+	var actions []models.ModerationAction
+	if err := cur.All(ctx, &actions); err != nil {
+		return nil, err
+	}
+	s = &roomSanctions{
+		bannedUsers: map[string]bool{},
+		bannedIPs:   map[string]bool{},
+		mutedUsers:  map[string]time.Time{},
+		mutedIPs:    map[string]time.Time{},
+		loadedAt:    time.Now(),
+	}
+	for _, a := range actions {
+		var until time.Time
+		if a.ExpiresAt != nil {
+			until = a.ExpiresAt.Time()
+		}
+		switch {
+		case a.Type == modBan && a.TargetUserID != "":
+			s.bannedUsers[a.TargetUserID] = true
+		case a.Type == modBan && a.TargetIP != "":
+			s.bannedIPs[a.TargetIP] = true
+		case a.Type == modMute && a.TargetUserID != "":
+			s.mutedUsers[a.TargetUserID] = until
+		case a.Type == modMute && a.TargetIP != "":
+			s.mutedIPs[a.TargetIP] = until
+		}
+	}
+	sanctionsMu.Lock()
+	sanctionsCache[room] = s
+	sanctionsMu.Unlock()
+	return s, nil
+}
+
+func invalidateSanctions(room string) {
+	sanctionsMu.Lock()
+	delete(sanctionsCache, room)
+	sanctionsMu.Unlock()
+}
+
+// sanctionReason renvoie "banned" ou "muted" (avec l'échéance, RFC 3339) si
+// l'utilisateur ne peut pas écrire dans le salon, "" sinon.
+func sanctionReason(ctx context.Context, u WSUser, room string) (reason, until string) {
+	if isDMRoom(room) {
+		return "", ""
+	}
+	s, err := sanctionsFor(ctx, room)
+	if err != nil {
+		log.Printf("sanctions %s: %v", room, err)
+		return "", ""
+	}
+	if s.banned(u) {
+		return nackBanned, ""
+	}
+	if t, ok := s.muted(u); ok {
+		if !t.IsZero() {
+			until = t.UTC().Format(time.RFC3339)
+		}
+		return nackMuted, until
+	}
+	return "", ""
+}
+
+// canModerate: modérateur global, propriétaire ou modérateur du salon.
+func canModerate(ctx context.Context, u WSUser, room string) bool {
+	if !u.Authenticated || u.ID == "" {
+		return false
+	}
+	if isGlobalModerator(ctx, u) {
+		return true
+	}
+	if isDMRoom(room) {
+		return false
+	}
+	r, err := loadRoom(ctx, room)
+	if err != nil || r == nil {
+		return false
+	}
+	return r.OwnerID == u.ID || contains(r.Moderators, u.ID)
+}
+
+// moderationTarget désigne un compte (par id ou username) ou une IP d'invité.
+type moderationTarget struct {
+	user WSUser
+	ip   string
+}
+
+func (t moderationTarget) matches(u WSUser) bool {
+	if t.user.ID != "" {
+		return u.Authenticated && u.ID == t.user.ID
+	}
+	return !u.Authenticated && u.IP == t.ip
+}
+
+func resolveTarget(ctx context.Context, ref, ip string) (moderationTarget, error) {
+	if ref == "" {
+		if ip == "" {
+			return moderationTarget{}, errInvalid
+		}
+		return moderationTarget{ip: ip}, nil
+	}
+	filter := bson.M{"username": ref}
+	if oid, err := primitive.ObjectIDFromHex(ref); err == nil {
+		filter = bson.M{"_id": oid}
+	}
+	var u models.User
+	if err := db.UsersCol.FindOne(ctx, filter).Decode(&u); err != nil {
+		return moderationTarget{}, errNotFound
+	}
+	return moderationTarget{user: WSUser{ID: u.ID.Hex(), Username: u.Username, Authenticated: true}}, nil
+}
+
+// targetInRoom: la cible a un lien avec le salon (membre, propriétaire, auteur d'un
+// message); pour une IP d'invité, une connexion ouverte depuis cette IP.
+func targetInRoom(ctx context.Context, room string, t moderationTarget) bool {
+	if t.user.ID == "" {
+		return wsHub.anyMatching(t.matches)
+	}
+	if r, err := loadRoom(ctx, room); err == nil && r != nil && (r.OwnerID == t.user.ID || contains(r.Members, t.user.ID)) {
+		return true
+	}
+	n, err := db.MessagesCol.CountDocuments(ctx, bson.M{"room": room, "user_id": t.user.ID}, options.Count().SetLimit(1))
+	return err == nil && n > 0
+}
+
+// recordModeration journalise l'action.
+func recordModeration(ctx context.Context, a models.ModerationAction) (models.ModerationAction, error) {
+	a.ID = primitive.NewObjectID()
+	a.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	_, err := db.ModerationCol.InsertOne(ctx, a)
+	return a, err
+}
+
+// notifyTarget prévient la cible en direct et, pour un compte, dans sa boîte de réception.
+func notifyTarget(ctx context.Context, t moderationTarget, a models.ModerationAction) {
+	data := gin.H{"action": a.Type, "reason": a.Reason, "by": a.ActorName}
+	if a.ExpiresAt != nil {
+		data["expires_at"] = a.ExpiresAt.Time().UTC()
+	}
+	ev := WSEvent{Type: "moderation.action", Room: a.Room, Data: data, Timestamp: time.Now().UTC()}
+	wsHub.sendMatching(t.matches, ev)
+	if t.user.ID == "" {
+		return
+	}
+	n := models.Notification{
+		UserID:    t.user.ID,
+		Type:      "moderation",
+		Kind:      a.Type,
+		Room:      a.Room,
+		From:      a.ActorName,
+		Excerpt:   a.Reason,
+		CreatedAt: a.CreatedAt,
+	}
+	if _, err := db.NotificationsCol.InsertOne(ctx, n); err != nil {
+		log.Printf("moderation notification: %v", err)
+	}
+}
+
+// purgeRecent supprime les messages récents de la cible dans le salon.
+func purgeRecent(ctx context.Context, actorID, room string, t moderationTarget, window time.Duration) (int64, error) {
+	filter := bson.M{
+		"room":       room,
+		"deleted":    bson.M{"$ne": true},
+		"created_at": bson.M{"$gte": primitive.NewDateTimeFromTime(time.Now().Add(-window))},
+	}
+	if t.user.ID != "" {
+		filter["user_id"] = t.user.ID
+	} else {
+		return 0, errInvalid // les messages d'invités ne portent pas d'IP
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(maxPurgeMessages)
+	cur, err := db.MessagesCol.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var msgs []models.Message
+	if err := cur.All(ctx, &msgs); err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, m := range msgs {
+		if _, err := tombstone(ctx, m, actorID); err != nil {
+			if errors.Is(err, errNotFound) {
+				continue // supprimé entre-temps
+			}
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// revokeSanction lève les bans ou mutes actifs visant la cible.
+func revokeSanction(ctx context.Context, room, kind string, t moderationTarget) error {
+	filter := activeSanctionFilter(room)
+	filter["type"] = kind
+	if t.user.ID != "" {
+		filter["target_user_id"] = t.user.ID
+	} else {
+		filter["target_ip"] = t.ip
+	}
+	_, err := db.ModerationCol.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+// moderateHandler: POST /api/rooms/:room/moderation
+// {action: kick|ban|unban|mute|unmute|purge, user: id|username, ip, reason, duration, window}
+func moderateHandler(c *gin.Context) {
+	var req struct {
+		Action   string `json:"action"`
+		User     string `json:"user"`
+		IP       string `json:"ip"` // invités uniquement
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"` // ban/mute: "30m", "24h"... (vide: ban définitif, mute par défaut)
+		Window   string `json:"window"`   // purge: période couverte (défaut 1h)
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	room := c.Param("room")
+	actor := authViewer(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !canModerate(ctx, actor, room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	t, err := resolveTarget(ctx, req.User, req.IP)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	if t.user.ID != "" && (t.user.ID == actor.ID || canModerate(ctx, t.user, room)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot moderate a moderator"})
+		return
+	}
+	global := isGlobalModerator(ctx, actor)
+	if !global && req.Action != modUnban && req.Action != modUnmute && !targetInRoom(ctx, room, t) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "target not in room"})
+		return
+	}
+
+	a := models.ModerationAction{
+		Type:         req.Action,
+		Room:         room,
+		ActorID:      actor.ID,
+		ActorName:    actor.Username,
+		TargetUserID: t.user.ID,
+		TargetName:   t.user.Username,
+		TargetIP:     t.ip,
+		Reason:       req.Reason,
+	}
+	var d time.Duration
+	if req.Duration != "" {
+		if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duration"})
+			return
+		}
+	}
+
+	switch req.Action {
+	case modKick, modBan:
+		if req.Action == modBan && d > 0 {
+			exp := primitive.NewDateTimeFromTime(time.Now().Add(d))
+			a.ExpiresAt = &exp
+		}
+		// Un kick laisse l'adhésion intacte: seul le ban retire la cible des membres.
+		if req.Action == modBan && t.user.ID != "" {
+			_, err = db.RoomsCol.UpdateOne(ctx, bson.M{"name": room}, bson.M{"$pull": bson.M{"members": t.user.ID}})
+			forgetRoom(room)
+		}
+	case modMute:
+		if d == 0 {
+			d = defaultMuteDuration
+		}
+		exp := primitive.NewDateTimeFromTime(time.Now().Add(d))
+		a.ExpiresAt = &exp
+	case modUnban:
+		err = revokeSanction(ctx, room, modBan, t)
+	case modUnmute:
+		err = revokeSanction(ctx, room, modMute, t)
+	case modPurge:
+		window := defaultPurgeWindow
+		if req.Window != "" {
+			if window, err = time.ParseDuration(req.Window); err != nil || window <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window"})
+				return
+			}
+		}
+		a.Affected, err = purgeRecent(ctx, actor.ID, room, t, window)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown action"})
+		return
+	}
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+
+	if a, err = recordModeration(ctx, a); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	invalidateSanctions(room)
+	notifyTarget(ctx, t, a)
+
+	// Kick et ban retirent la cible du salon (room.evicted); elle peut revenir après
+	// un kick, un ban la tient à l'écart via le filtre d'audience. Seul un modérateur
+	// global coupe ses connexions au serveur.
+	if req.Action == modKick || req.Action == modBan {
+		if global {
+			wsHub.disconnectMatching(t.matches, websocket.ClosePolicyViolation, req.Action+": "+room)
+		} else {
+			wsHub.evict(room, t.matches, req.Action)
+		}
+		if t.user.ID != "" {
+			presenceLeft(t.user, room)
+			emitWebhook(room, hookLeave, memberEvent(t.user, req.Action))
+		}
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// moderationLogHandler: GET /api/rooms/:room/moderation?limit= — journal, réservé aux modérateurs.
+func moderationLogHandler(c *gin.Context) {
+	room := c.Param("room")
+	if !canModerate(c, authViewer(c), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	limit := 50
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	cur, err := db.ModerationCol.Find(c, bson.M{"room": room}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	actions := []models.ModerationAction{}
+	if err := cur.All(c, &actions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "actions": actions})
+}
+
+// roomModeratorHandler: POST /api/rooms/:room/moderators {username} — réservé au propriétaire.
+func roomModeratorHandler(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
+		return
+	}
+	r, err := loadRoom(c, c.Param("room"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if r == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	if r.OwnerID != authViewer(c).ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var u models.User
+	if err := db.UsersCol.FindOne(c, bson.M{"username": req.Username}).Decode(&u); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if _, err := db.RoomsCol.UpdateOne(c, bson.M{"_id": r.ID}, bson.M{"$addToSet": bson.M{"moderators": u.ID.Hex()}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": r.Name, "moderator": u.ID.Hex()})
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRoomSanctions(t *testing.T) {
+	s := &roomSanctions{
+		bannedUsers: map[string]bool{"u1": true},
+		bannedIPs:   map[string]bool{"10.0.0.1": true},
+		mutedUsers: map[string]time.Time{
+			"u2": {},
+			"u3": time.Now().Add(time.Hour),
+			"u4": time.Now().Add(-time.Minute),
+		},
+		mutedIPs: map[string]time.Time{"10.0.0.2": {}},
+	}
+	tests := []struct {
+		name          string
+		u             WSUser
+		banned, muted bool
+	}{
+		{"compte banni", WSUser{ID: "u1", Authenticated: true, IP: "10.0.0.9"}, true, false},
+		{"invité banni par IP", WSUser{Username: "x", IP: "10.0.0.1"}, true, false},
+		{"compte sur une IP bannie", WSUser{ID: "u9", Authenticated: true, IP: "10.0.0.1"}, false, false},
+		{"invité sans IP", WSUser{Username: "x"}, false, false},
+		{"mute sans échéance", WSUser{ID: "u2", Authenticated: true}, false, true},
+		{"mute en cours", WSUser{ID: "u3", Authenticated: true}, false, true},
+		{"mute expiré", WSUser{ID: "u4", Authenticated: true}, false, false},
+		{"invité muet", WSUser{Username: "y", IP: "10.0.0.2"}, false, true},
+	}
+	for _, tt := range tests {
+		if got := s.banned(tt.u); got != tt.banned {
+			t.Errorf("banned(%s) = %v; want %v", tt.name, got, tt.banned)
+		}
+		if _, got := s.muted(tt.u); got != tt.muted {
+			t.Errorf("muted(%s) = %v; want %v", tt.name, got, tt.muted)
+		}
+	}
+}
+
+func TestModerationTargetMatches(t *testing.T) {
+	account := moderationTarget{user: WSUser{ID: "u1", Authenticated: true}}
+	guest := moderationTarget{ip: "10.0.0.1"}
+	tests := []struct {
+		name string
+		t    moderationTarget
+		u    WSUser
+		want bool
+	}{
+		{"même compte", account, WSUser{ID: "u1", Authenticated: true}, true},
+		{"autre compte", account, WSUser{ID: "u2", Authenticated: true}, false},
+		{"id non authentifié", account, WSUser{ID: "u1"}, false},
+		{"invité de l'IP", guest, WSUser{Username: "g", IP: "10.0.0.1"}, true},
+		{"compte sur l'IP", guest, WSUser{ID: "u3", Authenticated: true, IP: "10.0.0.1"}, false},
+		{"autre IP", guest, WSUser{Username: "g", IP: "10.0.0.2"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.t.matches(tt.u); got != tt.want {
+			t.Errorf("matches(%s) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestActiveSanctionFilter(t *testing.T) {
+	f := activeSanctionFilter("jeux")
+	if f["room"] != "jeux" {
+		t.Errorf("room = %v; want jeux", f["room"])
+	}
+	if types, _ := f["type"].(bson.M)["$in"].(bson.A); len(types) != 2 || types[0] != modBan || types[1] != modMute {
+		t.Errorf("type = %v; want ban et mute", f["type"])
+	}
+	if or, _ := f["$or"].(bson.A); len(or) != 2 {
+		t.Errorf("$or = %v; want sans échéance ou non expirée", f["$or"])
+	}
+}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Appels HTTP sortants vers des URL fournies par les utilisateurs (webhooks,
+// commandes externes). L'adresse est contrôlée à la connexion, après résolution:
+// une URL validée à l'enregistrement ne peut pas viser le réseau interne par un
+// changement de DNS ou une redirection. Pas de proxy: il ferait la connexion à notre place.
+var errBlockedAddress = errors.New("blocked address")
+
+// cgnat (100.64.0.0/10) n'est pas couvert par netip.Addr.IsPrivate.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// blockedAddr: boucle locale, réseaux privés, lien local, non spécifiée, multicast.
+func blockedAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return !a.IsValid() || a.IsLoopback() || a.IsPrivate() || a.IsUnspecified() ||
+		a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() || a.IsInterfaceLocalMulticast() ||
+		a.IsMulticast() || cgnat.Contains(a) || (a.Is4() && a.As4()[0] == 0)
+}
+
+// checkDialAddr est le Control du net.Dialer: address est l'IP résolue.
+func checkDialAddr(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBlockedAddress, address)
+	}
+	if blockedAddr(ap.Addr()) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, ap.Addr())
+	}
+	return nil
+}
+
+// newOutboundClient: client HTTP sans proxy ni redirection, limité aux adresses
+// publiques; timeout nul: pas de délai propre, celui du contexte s'applique.
+func newOutboundClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkDialAddr}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConnsPerHost:   4,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		// L'URL enregistrée est la seule destination.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// validOutboundURL: URL absolue http(s), sans identifiants; un hôte littéral
+// (IP, localhost) est refusé dès l'enregistrement s'il est interne.
+func validOutboundURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if a, err := netip.ParseAddr(host); err == nil && blockedAddr(a) {
+		return false
+	}
+	return true
+}
+
+// outboundError réduit l'erreur d'un appel sortant à une catégorie: le détail
+// (adresses, ports, messages du système) ne part que dans les logs.
+func outboundError(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, errBlockedAddress):
+		return "blocked_address"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	default:
+		return "connection_failed"
+	}
+}
@@ -0,0 +1,351 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Épinglage et annonces. La liste des épingles vit sur le document du salon:
+// le plafond maxPins est garanti par la condition de la mise à jour elle-même.
+// Une annonce est un message stocké comme les autres, marqué pour l'affichage;
+// "persistante", elle est remise dans l'instantané de chaque abonnement au salon.
+const (
+	maxPins               = 50
+	maxAnnouncementLength = 4000
+	snapshotAnnouncements = 10
+)
+
+// ensureRoomDoc crée le document d'un salon public qui n'en a pas encore (general).
+func ensureRoomDoc(ctx context.Context, room string) error {
+	_, err := db.RoomsCol.UpdateOne(ctx,
+		bson.M{"name": room},
+		bson.M{"$setOnInsert": bson.M{"private": false, "created_at": primitive.NewDateTimeFromTime(time.Now())}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// pinMessage épingle un message (modérateurs du salon); errConflict s'il l'est déjà, errLimit au-delà de maxPins.
+func pinMessage(ctx context.Context, u WSUser, id string) (models.Message, error) {
+	m, err := loadMessage(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, errNotFound
+	}
+	if isDMRoom(m.Room) {
+		return m, errInvalid
+	}
+	if !canModerate(ctx, u, m.Room) {
+		return m, errForbidden
+	}
+	if err := ensureRoomDoc(ctx, m.Room); err != nil {
+		return m, err
+	}
+	pin := models.Pin{MessageID: id, PinnedBy: u.ID, PinnedAt: primitive.NewDateTimeFromTime(time.Now())}
+	res, err := db.RoomsCol.UpdateOne(ctx,
+		bson.M{
+			"name":                            m.Room,
+			"pins.message_id":                 bson.M{"$ne": id},
+			"pins." + strconv.Itoa(maxPins-1): bson.M{"$exists": false},
+		},
+		bson.M{"$push": bson.M{"pins": pin}},
+	)
+	if err != nil {
+		return m, err
+	}
+	if res.MatchedCount == 0 {
+		if n, _ := db.RoomsCol.CountDocuments(ctx, bson.M{"name": m.Room, "pins.message_id": id}); n > 0 {
+			return m, errConflict
+		}
+		return m, errLimit
+	}
+	forgetRoom(m.Room)
+
+	if _, err := db.MessagesCol.UpdateOne(ctx,
+		bson.M{"_id": m.ID},
+		bson.M{"$set": bson.M{"pinned_at": pin.PinnedAt, "pinned_by": pin.PinnedBy}},
+	); err != nil {
+		log.Printf("pin %s: %v", id, err)
+	}
+	m.PinnedAt, m.PinnedBy = &pin.PinnedAt, pin.PinnedBy
+	publish(ctx, WSEvent{
+		Type:      "message.pinned",
+		Room:      m.Room,
+		MessageID: id,
+		Data:      pinItem(m),
+		Timestamp: time.Now().UTC(),
+	})
+	return m, nil
+}
+
+// unpinMessage retire l'épingle (modérateurs du salon).
+func unpinMessage(ctx context.Context, u WSUser, id string) (models.Message, error) {
+	m, err := loadMessage(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if !canModerate(ctx, u, m.Room) {
+		return m, errForbidden
+	}
+	if !dropPin(ctx, m, u.ID) {
+		return m, errNotFound
+	}
+	m.PinnedAt, m.PinnedBy = nil, ""
+	return m, nil
+}
+
+// dropPin retire le message des épingles de son salon et l'annonce; false s'il n'était pas épinglé.
+func dropPin(ctx context.Context, m models.Message, actorID string) bool {
+	id := m.ID.Hex()
+	res, err := db.RoomsCol.UpdateOne(ctx,
+		bson.M{"name": m.Room},
+		bson.M{"$pull": bson.M{"pins": bson.M{"message_id": id}}},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		if err != nil {
+			log.Printf("unpin %s: %v", id, err)
+		}
+		return false
+	}
+	forgetRoom(m.Room)
+	if _, err := db.MessagesCol.UpdateOne(ctx,
+		bson.M{"_id": m.ID},
+		bson.M{"$unset": bson.M{"pinned_at": "", "pinned_by": ""}},
+	); err != nil {
+		log.Printf("unpin %s: %v", id, err)
+	}
+	publish(ctx, WSEvent{
+		Type:      "message.unpinned",
+		Room:      m.Room,
+		MessageID: id,
+		Data:      gin.H{"unpinned_by": actorID},
+		Timestamp: time.Now().UTC(),
+	})
+	return true
+}
+
+// pinItem: représentation d'historique du message, avec son épinglage.
+func pinItem(m models.Message) gin.H {
+	item := historyItem(m)
+	if m.PinnedAt != nil {
+		item["pinned_by"] = m.PinnedBy
+		item["pinned_at"] = m.PinnedAt.Time().UTC().Format(time.RFC3339)
+	}
+	return item
+}
+
+// roomPins renvoie les messages épinglés du salon, le plus récemment épinglé d'abord.
+func roomPins(ctx context.Context, room string) ([]gin.H, error) {
+	var r models.Room
+	err := db.RoomsCol.FindOne(ctx, bson.M{"name": room}, options.FindOne().SetProjection(bson.M{"pins": 1})).Decode(&r)
+	if err != nil || len(r.Pins) == 0 {
+		return []gin.H{}, nil // salon sans document: aucune épingle
+	}
+	oids := make([]primitive.ObjectID, 0, len(r.Pins))
+	for _, p := range r.Pins {
+		if oid, err := primitive.ObjectIDFromHex(p.MessageID); err == nil {
+			oids = append(oids, oid)
+		}
+	}
+	cur, err := db.MessagesCol.Find(ctx, bson.M{"_id": bson.M{"$in": oids}, "deleted": bson.M{"$ne": true}})
+	if err != nil {
+		return nil, err
+	}
+	var msgs []models.Message
+	if err := cur.All(ctx, &msgs); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Message, len(msgs))
+	for _, m := range msgs {
+		byID[m.ID.Hex()] = m
+	}
+	out := make([]gin.H, 0, len(r.Pins))
+	for i := len(r.Pins) - 1; i >= 0; i-- {
+		p := r.Pins[i]
+		m, ok := byID[p.MessageID]
+		if !ok {
+			continue
+		}
+		m.PinnedAt, m.PinnedBy = &p.PinnedAt, p.PinnedBy
+		out = append(out, pinItem(m))
+	}
+	return out, nil
+}
+
+// persistentAnnouncements: annonces persistantes du salon, la plus récente d'abord.
+func persistentAnnouncements(ctx context.Context, room string) ([]gin.H, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: -1}}).SetLimit(snapshotAnnouncements)
+	cur, err := db.MessagesCol.Find(ctx, bson.M{
+		"room":         room,
+		"announcement": true,
+		"persistent":   true,
+		"deleted":      bson.M{"$ne": true},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []models.Message
+	if err := cur.All(ctx, &msgs); err != nil {
+		return nil, err
+	}
+	out := make([]gin.H, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, historyItem(m))
+	}
+	return out, nil
+}
+
+// sendRoomSnapshot envoie à la connexion l'état du salon à l'abonnement: épingles et annonces persistantes.
+func sendRoomSnapshot(conn *websocket.Conn, room string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pins, err := roomPins(ctx, room)
+	if err != nil {
+		log.Printf("room snapshot %s: %v", room, err)
+		wsHub.send(conn, WSError{Type: "error", Code: "server_error", Room: room, Detail: "subscribe"})
+		return
+	}
+	announcements, err := persistentAnnouncements(ctx, room)
+	if err != nil {
+		log.Printf("room snapshot %s: %v", room, err)
+		wsHub.send(conn, WSError{Type: "error", Code: "server_error", Room: room, Detail: "subscribe"})
+		return
+	}
+	data := gin.H{"pins": pins, "announcements": announcements}
+	if r, err := cachedRoom(ctx, room); err == nil && r != nil && r.Topic != "" {
+		data["topic"] = r.Topic
+	}
+	wsHub.send(conn, WSEvent{
+		Type:      "room.snapshot",
+		Room:      room,
+		Data:      data,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// pinHandler: POST /api/messages/:id/pin
+func pinHandler(c *gin.Context) {
+	m, err := pinMessage(c, authViewer(c), c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusOK, pinItem(m))
+}
+
+// unpinHandler: DELETE /api/messages/:id/pin
+func unpinHandler(c *gin.Context) {
+	if _, err := unpinMessage(c, authViewer(c), c.Param("id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listPinsHandler: GET /api/rooms/:room/pins
+func listPinsHandler(c *gin.Context) {
+	room := c.Param("room")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !canReadRoom(ctx, requestViewer(c.Request), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	pins, err := roomPins(ctx, room)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "pins": pins, "limit": maxPins})
+}
+
+// announceHandler: POST /api/rooms/:room/announcements {text, persistent}
+// L'annonce suit le chemin des messages (séquence, diffusion, recherche).
+func announceHandler(c *gin.Context) {
+	var req struct {
+		Text       string `json:"text"`
+		Persistent bool   `json:"persistent"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Text) == "" || len(req.Text) > maxAnnouncementLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text required"})
+		return
+	}
+	room := c.Param("room")
+	if isDMRoom(room) || !roomNameRe.MatchString(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room"})
+		return
+	}
+	me := authViewer(c)
+	if !canModerate(c, me, room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	it := persistItem{
+		ID:           primitive.NewObjectID(),
+		UserID:       me.ID,
+		Username:     me.Username,
+		Room:         room,
+		Text:         req.Text,
+		Timestamp:    time.Now().UTC(),
+		Announcement: true,
+		Persistent:   req.Persistent,
+	}
+	persistAndDeliver(it)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := loadMessage(ctx, it.ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": nackStoreFailed})
+		return
+	}
+	c.JSON(http.StatusCreated, historyItem(m))
+}
+
+// dismissAnnouncementHandler: DELETE /api/rooms/:room/announcements/:id
+// L'annonce reste dans l'historique mais n'est plus remise à l'abonnement.
+func dismissAnnouncementHandler(c *gin.Context) {
+	room := c.Param("room")
+	if !canModerate(c, authViewer(c), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	res, err := db.MessagesCol.UpdateOne(c,
+		bson.M{"_id": oid, "room": room, "announcement": true, "persistent": true},
+		bson.M{"$unset": bson.M{"persistent": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "announcement not found"})
+		return
+	}
+	publish(c, WSEvent{
+		Type:      "announcement.dismissed",
+		Room:      room,
+		MessageID: oid.Hex(),
+		Timestamp: time.Now().UTC(),
+	})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPinItem(t *testing.T) {
+	at := time.Date(2026, 4, 1, 9, 30, 0, 0, time.UTC)
+	pinned := primitive.NewDateTimeFromTime(at)
+	m := models.Message{
+		ID:        primitive.NewObjectIDFromTimestamp(at),
+		Sender:    "alice",
+		UserID:    "u1",
+		Content:   "À lire",
+		Room:      "general",
+		CreatedAt: primitive.NewDateTimeFromTime(at.Add(-time.Hour)),
+	}
+
+	item := pinItem(m)
+	for _, k := range []string{"pinned", "pinned_by", "pinned_at"} {
+		if _, ok := item[k]; ok {
+			t.Errorf("pinItem(non épinglé)[%s] présent", k)
+		}
+	}
+
+	m.PinnedAt, m.PinnedBy = &pinned, "mod1"
+	m.Announcement, m.Persistent = true, true
+	item = pinItem(m)
+	if item["pinned"] != true || item["pinned_by"] != "mod1" || item["pinned_at"] != "2026-04-01T09:30:00Z" {
+		t.Errorf("pinItem = %v; want épinglé par mod1 à 09:30", item)
+	}
+	if item["announcement"] != true || item["persistent"] != true || item["text"] != "À lire" {
+		t.Errorf("pinItem = %v; want annonce persistante", item)
+	}
+
+	m.Persistent = false
+	if _, ok := historyItem(m)["persistent"]; ok {
+		t.Errorf("historyItem[persistent] présent pour une annonce simple")
+	}
+}
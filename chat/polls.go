@@ -0,0 +1,419 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Sondages. Un sondage est un message (Message.Poll) créé par REST; on vote par
+// REST ou par la trame "poll.vote". Chaque vote est un document de poll_votes
+// (index unique: un vote par utilisateur), les compteurs sont tenus sur le message.
+// La clôture est une transition closed false -> true: une seule instance la publie.
+const (
+	minPollOptions     = 2
+	maxPollOptions     = 10
+	maxPollQuestionLen = 300
+	maxPollOptionLen   = 100
+
+	pollSweep = 2 * time.Second
+)
+
+var pollCloserOnce sync.Once
+
+// startPollCloser clôt les sondages arrivés à échéance.
+func startPollCloser() {
+	pollCloserOnce.Do(func() {
+		go func() {
+			t := time.NewTicker(pollSweep)
+			defer t.Stop()
+			for range t.C {
+				for closeDuePoll() {
+				}
+			}
+		}()
+	})
+}
+
+// closeDuePoll clôt un sondage échu; false s'il n'y en a plus.
+func closeDuePoll() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var m models.Message
+	err := db.MessagesCol.FindOneAndUpdate(ctx,
+		bson.M{"poll.closed": false, "poll.closes_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"poll.closed": true, "poll.closed_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&m)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("poll closer: %v", err)
+		}
+		return false
+	}
+	publishPoll(ctx, "poll.closed", m)
+	return true
+}
+
+// pollView est le sondage tel que diffusé: compteurs retirés tant qu'ils sont masqués.
+func pollView(p *models.Poll) *models.Poll {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	if p.HideResults && !p.Closed {
+		v.Options = make([]models.PollOption, len(p.Options))
+		for i, o := range p.Options {
+			v.Options[i] = models.PollOption{Text: o.Text}
+		}
+		v.ResultsHidden = true
+	}
+	return &v
+}
+
+func publishPoll(ctx context.Context, kind string, m models.Message) {
+	publish(ctx, WSEvent{
+		Type:      kind,
+		Room:      m.Room,
+		MessageID: m.ID.Hex(),
+		Data:      gin.H{"poll": pollView(m.Poll)},
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// pollOpen: ni clôturé, ni échu en attente du passage du worker.
+func pollOpen(p *models.Poll) bool {
+	return !p.Closed && (p.ClosesAt == nil || p.ClosesAt.Time().After(time.Now()))
+}
+
+// castVote enregistre le vote de l'utilisateur (indices des options choisies).
+func castVote(ctx context.Context, actor WSUser, id string, choices []int) (models.Message, error) {
+	if !actor.Authenticated || actor.ID == "" {
+		return models.Message{}, errForbidden
+	}
+	m, err := loadMessage(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted || m.Poll == nil {
+		return m, errNotFound
+	}
+	if !canReadRoom(ctx, actor, m.Room) {
+		return m, errForbidden
+	}
+	if reason, _ := sanctionReason(ctx, actor, m.Room); reason != "" {
+		return m, errForbidden
+	}
+	if !pollOpen(m.Poll) {
+		return m, errConflict
+	}
+	if len(choices) == 0 || (!m.Poll.Multiple && len(choices) > 1) {
+		return m, errInvalid
+	}
+	seen := map[int]bool{}
+	inc := bson.M{"poll.voters": 1}
+	for _, i := range choices {
+		if i < 0 || i >= len(m.Poll.Options) || seen[i] {
+			return m, errInvalid
+		}
+		seen[i] = true
+		inc["poll.options."+strconv.Itoa(i)+".votes"] = 1
+	}
+	sort.Ints(choices)
+
+	vote := models.PollVote{
+		ID:        primitive.NewObjectID(),
+		MessageID: m.ID.Hex(),
+		UserID:    actor.ID,
+		Username:  actor.Username,
+		Options:   choices,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := db.PollVotesCol.InsertOne(ctx, vote); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return m, errConflict // déjà voté
+		}
+		return m, err
+	}
+	var updated models.Message
+	err = db.MessagesCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": m.ID, "poll.closed": false, "deleted": bson.M{"$ne": true}},
+		bson.M{"$inc": inc},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		// Clôturé ou supprimé entre-temps: le vote ne compte pas.
+		if _, derr := db.PollVotesCol.DeleteOne(ctx, bson.M{"_id": vote.ID}); derr != nil {
+			log.Printf("poll vote rollback %s: %v", vote.ID.Hex(), derr)
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return m, errConflict
+		}
+		return m, err
+	}
+	publishPoll(ctx, "poll.updated", updated)
+	return updated, nil
+}
+
+// closePoll clôt le sondage avant l'échéance: auteur ou modérateur du salon.
+func closePoll(ctx context.Context, actor WSUser, id string) (models.Message, error) {
+	m, err := loadMessage(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted || m.Poll == nil {
+		return m, errNotFound
+	}
+	if !isAuthor(actor, m) && !canModerate(ctx, actor, m.Room) {
+		return m, errForbidden
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var updated models.Message
+	err = db.MessagesCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": m.ID, "poll.closed": false},
+		bson.M{"$set": bson.M{"poll.closed": true, "poll.closed_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return m, errConflict
+		}
+		return m, err
+	}
+	publishPoll(ctx, "poll.closed", updated)
+	return updated, nil
+}
+
+// myVote renvoie les choix de l'utilisateur, nil s'il n'a pas voté.
+func myVote(ctx context.Context, u WSUser, messageID string) []int {
+	if !u.Authenticated || u.ID == "" {
+		return nil
+	}
+	var v models.PollVote
+	if err := db.PollVotesCol.FindOne(ctx, bson.M{"message_id": messageID, "user_id": u.ID}).Decode(&v); err != nil {
+		return nil
+	}
+	return v.Options
+}
+
+// createPollHandler: POST /api/rooms/:room/polls
+// {question, options[], multiple, anonymous, hide_results, closes_at|closes_in}
+func createPollHandler(c *gin.Context) {
+	var req struct {
+		Question    string   `json:"question"`
+		Options     []string `json:"options"`
+		Multiple    bool     `json:"multiple"`
+		Anonymous   bool     `json:"anonymous"`
+		HideResults bool     `json:"hide_results"`
+		ClosesAt    string   `json:"closes_at"`
+		ClosesIn    string   `json:"closes_in"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" || len(req.Question) > maxPollQuestionLen || strings.Contains(req.Question, "\n") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "question required"})
+		return
+	}
+	if len(req.Options) < minPollOptions || len(req.Options) > maxPollOptions {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "2 to 10 options required"})
+		return
+	}
+	seen := map[string]bool{}
+	for i, o := range req.Options {
+		o = strings.TrimSpace(o)
+		if o == "" || len(o) > maxPollOptionLen || strings.Contains(o, "\n") || seen[strings.ToLower(o)] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid option", "option": i})
+			return
+		}
+		seen[strings.ToLower(o)] = true
+		req.Options[i] = o
+	}
+	var closesAt *primitive.DateTime
+	if req.ClosesAt != "" || req.ClosesIn != "" {
+		due, err := dueTime(req.ClosesAt, req.ClosesIn)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid close time"})
+			return
+		}
+		dt := primitive.NewDateTimeFromTime(due)
+		closesAt = &dt
+	}
+
+	room := c.Param("room")
+	if !isDMRoom(room) && !roomNameRe.MatchString(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room"})
+		return
+	}
+	me := authViewer(c)
+	if !canReadRoom(c, me, room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if reason, until := sanctionReason(c, me, room); reason != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": reason, "until": until})
+		return
+	}
+	if a, b, ok := dmParticipants(room); ok {
+		other := a
+		if other == me.ID {
+			other = b
+		}
+		if isBlocked(c, other, me.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": nackBlocked})
+			return
+		}
+	}
+
+	// Mêmes limites qu'un message envoyé sur la socket: seau de l'utilisateur
+	// (la requête HTTP tient lieu de connexion) puis mode lent du salon.
+	if ok, wait := allowFrame(c, "http:"+rateSubject(me), me); !ok {
+		recordFlood(c, me, room)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": nackLimited, "retry_after_ms": wait.Milliseconds()})
+		return
+	}
+	wait, releaseSlot := slowModeWait(c, me, room)
+	if wait > 0 {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": nackSlowMode, "retry_after_ms": wait.Milliseconds()})
+		return
+	}
+
+	// Question et options passent ensemble dans la chaîne de filtres, une par ligne.
+	text := req.Question + "\n" + strings.Join(req.Options, "\n")
+	filtered := runFilters(c, FilterInput{
+		Text:      text,
+		Room:      room,
+		UserID:    me.ID,
+		Username:  me.Username,
+		AuthorKey: rateSubject(me),
+	})
+	if filtered.Rejected {
+		releaseSlot()
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": nackRejected, "reason": filtered.Reason})
+		return
+	}
+	if lines := strings.Split(filtered.Text, "\n"); filtered.Text != text && len(lines) == len(req.Options)+1 {
+		req.Question, req.Options = lines[0], lines[1:]
+	}
+
+	poll := &models.Poll{
+		Question:    req.Question,
+		Options:     make([]models.PollOption, len(req.Options)),
+		Multiple:    req.Multiple,
+		Anonymous:   req.Anonymous,
+		HideResults: req.HideResults,
+		ClosesAt:    closesAt,
+	}
+	for i, o := range req.Options {
+		poll.Options[i] = models.PollOption{Text: o}
+	}
+	it := persistItem{
+		ID:          primitive.NewObjectID(),
+		UserID:      me.ID,
+		Username:    me.Username,
+		Room:        room,
+		Text:        poll.Question,
+		Timestamp:   time.Now().UTC(),
+		Flags:       filtered.Flags,
+		Poll:        poll,
+		ReleaseSlot: releaseSlot,
+	}
+	persistAndDeliver(it)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := loadMessage(ctx, it.ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": nackStoreFailed})
+		return
+	}
+	c.JSON(http.StatusCreated, historyItem(m))
+}
+
+// voteHandler: POST /api/messages/:id/poll/votes {options: [indices]}
+func voteHandler(c *gin.Context) {
+	var req struct {
+		Options []int `json:"options"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	m, err := castVote(c, authViewer(c), c.Param("id"), req.Options)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": m.ID.Hex(), "poll": pollView(m.Poll), "my_vote": req.Options})
+}
+
+// closePollHandler: POST /api/messages/:id/poll/close
+func closePollHandler(c *gin.Context) {
+	m, err := closePoll(c, authViewer(c), c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": m.ID.Hex(), "poll": pollView(m.Poll)})
+}
+
+// pollHandler: GET /api/messages/:id/poll — résultats, vote de l'appelant et,
+// pour un sondage nominatif dont les résultats sont visibles, les votants par option.
+func pollHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	viewer := requestViewer(c.Request)
+	m, err := loadMessage(ctx, c.Param("id"))
+	if err != nil || m.Deleted || m.Poll == nil || !canReadRoom(ctx, viewer, m.Room) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "poll not found"})
+		return
+	}
+	view := pollView(m.Poll)
+	out := gin.H{"id": m.ID.Hex(), "room": m.Room, "poll": view, "my_vote": myVote(ctx, viewer, m.ID.Hex())}
+	if m.Poll.Anonymous || view.ResultsHidden {
+		c.JSON(http.StatusOK, out)
+		return
+	}
+
+	cur, err := db.PollVotesCol.Find(ctx, bson.M{"message_id": m.ID.Hex()},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	var votes []models.PollVote
+	if err := cur.All(ctx, &votes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	voters := make([][]gin.H, len(m.Poll.Options))
+	for i := range voters {
+		voters[i] = []gin.H{}
+	}
+	for _, v := range votes {
+		for _, i := range v.Options {
+			if i >= 0 && i < len(voters) {
+				voters[i] = append(voters[i], gin.H{"user_id": v.UserID, "username": v.Username})
+			}
+		}
+	}
+	out["voters"] = voters
+	c.JSON(http.StatusOK, out)
+}
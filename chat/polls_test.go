@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+)
+
+func TestPollView(t *testing.T) {
+	poll := func(hide, closed bool) *models.Poll {
+		return &models.Poll{
+			Question:    "Pizza ou sushi ?",
+			Options:     []models.PollOption{{Text: "Pizza", Votes: 3}, {Text: "Sushi", Votes: 1}},
+			HideResults: hide,
+			Closed:      closed,
+			Voters:      4,
+		}
+	}
+	tests := []struct {
+		name       string
+		poll       *models.Poll
+		wantVotes  []int
+		wantHidden bool
+	}{
+		{"résultats visibles", poll(false, false), []int{3, 1}, false},
+		{"masqués tant qu'ouvert", poll(true, false), []int{0, 0}, true},
+		{"masqués puis clôturé", poll(true, true), []int{3, 1}, false},
+		{"clôturé", poll(false, true), []int{3, 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := pollView(tt.poll)
+			if v == tt.poll {
+				t.Fatal("pollView renvoie le sondage d'origine")
+			}
+			if v.ResultsHidden != tt.wantHidden {
+				t.Errorf("ResultsHidden = %v; want %v", v.ResultsHidden, tt.wantHidden)
+			}
+			if len(v.Options) != len(tt.wantVotes) {
+				t.Fatalf("%d options; want %d", len(v.Options), len(tt.wantVotes))
+			}
+			for i, o := range v.Options {
+				if o.Votes != tt.wantVotes[i] || o.Text != tt.poll.Options[i].Text {
+					t.Errorf("option %d = %+v; want %q avec %d votes", i, o, tt.poll.Options[i].Text, tt.wantVotes[i])
+				}
+			}
+			// Le sondage stocké garde ses compteurs.
+			if tt.poll.Options[0].Votes != 3 || tt.poll.ResultsHidden {
+				t.Errorf("pollView a modifié l'original: %+v", tt.poll)
+			}
+		})
+	}
+	if pollView(nil) != nil {
+		t.Error("pollView(nil) != nil")
+	}
+}
@@ -0,0 +1,415 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Présence. Chaque connexion authentifiée est un membre du ZSET
+// presence:conns:<uid> (score = expiration), rafraîchi par l'instance qui la porte:
+// le nombre de connexions vivantes agrège onglets et instances, et une instance
+// tombée disparaît d'elle-même à l'expiration.
+const (
+	presenceHeartbeat = 30 * time.Second
+	presenceTTL       = 90 * time.Second
+	presenceChannel   = "chat:presence"
+	presenceOnlineSet = "presence:online"
+	maxPresenceUsers  = 500
+	maxStatusTextLen  = 100
+)
+
+// Statuts manuels; "offline" est déduit de l'absence de connexion.
+var manualStatuses = map[string]bool{"online": true, "away": true, "dnd": true}
+
+// instanceID distingue les connexions de cette instance dans Redis.
+var instanceID = primitive.NewObjectID().Hex()
+
+var presenceOnce sync.Once
+
+// PresenceInfo est l'état de présence d'un utilisateur.
+type PresenceInfo struct {
+	UserID      string     `json:"user_id"`
+	Username    string     `json:"username,omitempty"`
+	Status      string     `json:"status"` // online | away | dnd | offline
+	Text        string     `json:"text,omitempty"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
+	Connections int64      `json:"connections"`
+}
+
+func presenceConnsKey(uid string) string    { return "presence:conns:" + uid }
+func presenceStatusKey(uid string) string   { return "presence:status:" + uid }
+func presenceLastSeenKey(uid string) string { return "presence:last_seen:" + uid }
+
+// presenceRoomKey: abonnés d'un salon, un membre <instance>:<uid> par instance
+// (score = expiration), rafraîchi comme presence:conns.
+func presenceRoomKey(room string) string   { return "presence:room:" + room }
+func presenceRoomMember(uid string) string { return instanceID + ":" + uid }
+
+// roomMemberUserID extrait l'utilisateur d'un membre de presence:room.
+func roomMemberUserID(member string) string {
+	if i := strings.IndexByte(member, ':'); i >= 0 {
+		return member[i+1:]
+	}
+	return member
+}
+
+func nowMs() string { return strconv.FormatInt(time.Now().UnixMilli(), 10) }
+
+func presenceExpiry() float64 {
+	return float64(time.Now().Add(presenceTTL).UnixMilli())
+}
+
+func startPresence() {
+	presenceOnce.Do(func() {
+		go presenceRelay()
+		go presenceHeartbeatLoop()
+	})
+}
+
+// liveConnections purge les connexions expirées et compte les vivantes.
+func liveConnections(ctx context.Context, uid string) (int64, error) {
+	db.Rdb.ZRemRangeByScore(ctx, presenceConnsKey(uid), "-inf", nowMs())
+	return db.Rdb.ZCount(ctx, presenceConnsKey(uid), nowMs(), "+inf").Result()
+}
+
+func presenceConnected(u WSUser, connID string) {
+	if !u.Authenticated || u.ID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	before, err := liveConnections(ctx, u.ID)
+	if err != nil {
+		log.Printf("presence connect %s: %v", u.ID, err)
+		return
+	}
+	pipe := db.Rdb.TxPipeline()
+	pipe.ZAdd(ctx, presenceConnsKey(u.ID), redis.Z{Score: presenceExpiry(), Member: instanceID + ":" + connID})
+	pipe.Expire(ctx, presenceConnsKey(u.ID), presenceTTL)
+	pipe.ZAdd(ctx, presenceOnlineSet, redis.Z{Score: presenceExpiry(), Member: u.ID})
+	pipe.Set(ctx, presenceLastSeenKey(u.ID), time.Now().UnixMilli(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("presence connect %s: %v", u.ID, err)
+		return
+	}
+	if before == 0 {
+		publishPresence(ctx, u.ID, u.Username)
+	}
+}
+
+func presenceDisconnected(u WSUser, connID string) {
+	if !u.Authenticated || u.ID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	db.Rdb.ZRem(ctx, presenceConnsKey(u.ID), instanceID+":"+connID)
+	db.Rdb.Set(ctx, presenceLastSeenKey(u.ID), time.Now().UnixMilli(), 0)
+	left, err := liveConnections(ctx, u.ID)
+	if err != nil {
+		log.Printf("presence disconnect %s: %v", u.ID, err)
+		return
+	}
+	if left == 0 {
+		db.Rdb.ZRem(ctx, presenceOnlineSet, u.ID)
+		publishPresence(ctx, u.ID, u.Username)
+	}
+}
+
+// presenceJoined: première connexion de u (sur cette instance) qui suit room.
+func presenceJoined(u WSUser, room string) {
+	if !u.Authenticated || u.ID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	pipe := db.Rdb.TxPipeline()
+	pipe.ZAdd(ctx, presenceRoomKey(room), redis.Z{Score: presenceExpiry(), Member: presenceRoomMember(u.ID)})
+	pipe.Expire(ctx, presenceRoomKey(room), presenceTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("presence join %s %s: %v", u.ID, room, err)
+	}
+}
+
+// presenceLeft: plus aucune connexion de u (sur cette instance) ne suit ces salons.
+func presenceLeft(u WSUser, rooms ...string) {
+	if !u.Authenticated || u.ID == "" || len(rooms) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	pipe := db.Rdb.Pipeline()
+	for _, room := range rooms {
+		pipe.ZRem(ctx, presenceRoomKey(room), presenceRoomMember(u.ID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("presence leave %s: %v", u.ID, err)
+	}
+}
+
+// roomSubscribers liste les utilisateurs dont une connexion, sur n'importe quelle instance, suit room.
+func roomSubscribers(ctx context.Context, room string) ([]string, error) {
+	members, err := db.Rdb.ZRangeByScore(ctx, presenceRoomKey(room), &redis.ZRangeBy{Min: nowMs(), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, m := range members {
+		if uid := roomMemberUserID(m); uid != "" && !seen[uid] {
+			seen[uid] = true
+			ids = append(ids, uid)
+		}
+	}
+	return ids, nil
+}
+
+// presenceHeartbeatLoop prolonge l'expiration des connexions portées par cette instance.
+func presenceHeartbeatLoop() {
+	for range time.Tick(presenceHeartbeat) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		pipe := db.Rdb.Pipeline()
+		rooms := map[string]bool{}
+		for _, lc := range wsHub.localConnections() {
+			pipe.ZAdd(ctx, presenceConnsKey(lc.userID), redis.Z{Score: presenceExpiry(), Member: instanceID + ":" + lc.connID})
+			pipe.Expire(ctx, presenceConnsKey(lc.userID), presenceTTL)
+			pipe.ZAdd(ctx, presenceOnlineSet, redis.Z{Score: presenceExpiry(), Member: lc.userID})
+			for _, room := range lc.rooms {
+				rooms[room] = true
+				pipe.ZAdd(ctx, presenceRoomKey(room), redis.Z{Score: presenceExpiry(), Member: presenceRoomMember(lc.userID)})
+			}
+		}
+		for room := range rooms {
+			pipe.Expire(ctx, presenceRoomKey(room), presenceTTL)
+			pipe.ZRemRangeByScore(ctx, presenceRoomKey(room), "-inf", nowMs())
+		}
+		pipe.ZRemRangeByScore(ctx, presenceOnlineSet, "-inf", nowMs())
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("presence heartbeat: %v", err)
+		}
+		cancel()
+	}
+}
+
+// presenceOf lit la présence de plusieurs utilisateurs en un aller-retour Redis.
+func presenceOf(ctx context.Context, uids []string) ([]PresenceInfo, error) {
+	pipe := db.Rdb.Pipeline()
+	counts := make([]*redis.IntCmd, len(uids))
+	statuses := make([]*redis.MapStringStringCmd, len(uids))
+	seen := make([]*redis.StringCmd, len(uids))
+	for i, uid := range uids {
+		counts[i] = pipe.ZCount(ctx, presenceConnsKey(uid), nowMs(), "+inf")
+		statuses[i] = pipe.HGetAll(ctx, presenceStatusKey(uid))
+		seen[i] = pipe.Get(ctx, presenceLastSeenKey(uid))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	out := make([]PresenceInfo, len(uids))
+	for i, uid := range uids {
+		info := PresenceInfo{UserID: uid, Status: "offline", Connections: counts[i].Val()}
+		st := statuses[i].Val()
+		info.Text = st["text"]
+		if info.Connections > 0 {
+			info.Status = "online"
+			if manualStatuses[st["status"]] {
+				info.Status = st["status"]
+			}
+		}
+		if ms, err := seen[i].Int64(); err == nil {
+			t := time.UnixMilli(ms).UTC()
+			info.LastSeen = &t
+		}
+		out[i] = info
+	}
+	return out, nil
+}
+
+func publishPresence(ctx context.Context, uid, username string) {
+	infos, err := presenceOf(ctx, []string{uid})
+	if err != nil || len(infos) == 0 {
+		return
+	}
+	infos[0].Username = username
+	payload, _ := json.Marshal(infos[0])
+	if err := db.Rdb.Publish(ctx, presenceChannel, payload).Err(); err != nil {
+		log.Printf("presence publish: %v", err)
+	}
+}
+
+func presenceRelay() {
+	sub := db.Rdb.Subscribe(context.Background(), presenceChannel)
+	for msg := range sub.Channel() {
+		var info PresenceInfo
+		if err := json.Unmarshal([]byte(msg.Payload), &info); err != nil {
+			continue
+		}
+		wsHub.sendToWatchers(info.UserID, WSEvent{Type: "presence.changed", Data: info, Timestamp: time.Now().UTC()})
+	}
+}
+
+// watchPresence remplace la liste des utilisateurs suivis par la connexion
+// et lui envoie aussitôt leur état courant.
+func watchPresence(conn *websocket.Conn, users []string) {
+	if len(users) > maxPresenceUsers {
+		users = users[:maxPresenceUsers]
+	}
+	wsHub.setWatching(conn, users)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	infos, err := presenceOf(ctx, users)
+	if err != nil {
+		wsHub.send(conn, WSError{Type: "error", Code: "server_error", Detail: "presence"})
+		return
+	}
+	wsHub.send(conn, WSEvent{Type: "presence.snapshot", Data: infos, Timestamp: time.Now().UTC()})
+}
+
+// withUsernames complète les infos de présence avec les noms d'utilisateur.
+func withUsernames(ctx context.Context, infos []PresenceInfo) {
+	oids := make([]primitive.ObjectID, 0, len(infos))
+	for _, in := range infos {
+		if oid, err := primitive.ObjectIDFromHex(in.UserID); err == nil {
+			oids = append(oids, oid)
+		}
+	}
+	if len(oids) == 0 {
+		return
+	}
+	cur, err := db.UsersCol.Find(ctx, bson.M{"_id": bson.M{"$in": oids}}, options.Find().SetProjection(bson.M{"username": 1}))
+	if err != nil {
+		return
+	}
+	var users []models.User
+	if err := cur.All(ctx, &users); err != nil {
+		return
+	}
+	names := make(map[string]string, len(users))
+	for _, u := range users {
+		names[u.ID.Hex()] = u.Username
+	}
+	for i := range infos {
+		infos[i].Username = names[infos[i].UserID]
+	}
+}
+
+// presenceHandler: GET /api/presence?users=<id|username>,...
+func presenceHandler(c *gin.Context) {
+	var ids, names []string
+	for _, v := range strings.Split(c.Query("users"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if _, err := primitive.ObjectIDFromHex(v); err == nil {
+			ids = append(ids, v)
+		} else {
+			names = append(names, v)
+		}
+	}
+	if len(ids)+len(names) == 0 || len(ids)+len(names) > maxPresenceUsers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "users required (max 500)"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if len(names) > 0 {
+		cur, err := db.UsersCol.Find(ctx, bson.M{"username": bson.M{"$in": names}}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var users []models.User
+		if err := cur.All(ctx, &users); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+			return
+		}
+		for _, u := range users {
+			ids = append(ids, u.ID.Hex())
+		}
+	}
+
+	infos, err := presenceOf(ctx, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "redis error"})
+		return
+	}
+	withUsernames(ctx, infos)
+	c.JSON(http.StatusOK, gin.H{"presence": infos})
+}
+
+// rosterHandler: GET /api/rooms/:room/roster — membres (salon privé, DM) avec leur présence,
+// ou utilisateurs qui suivent un salon public.
+func rosterHandler(c *gin.Context) {
+	room := c.Param("room")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if !canReadRoom(ctx, requestViewer(c.Request), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	ids := roomMemberIDs(ctx, room)
+	public := ids == nil
+	if public {
+		var err error
+		ids, err = roomSubscribers(ctx, room)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "redis error"})
+			return
+		}
+	}
+	infos, err := presenceOf(ctx, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "redis error"})
+		return
+	}
+	withUsernames(ctx, infos)
+	c.JSON(http.StatusOK, gin.H{"room": room, "public": public, "roster": infos})
+}
+
+// presenceStatusHandler: PUT /api/presence/status {status, text}
+func presenceStatusHandler(c *gin.Context) {
+	var req struct {
+		Status string `json:"status"`
+		Text   string `json:"text"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !manualStatuses[req.Status] || utf8.RuneCountInString(req.Text) > maxStatusTextLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	me := authViewer(c)
+	key := presenceStatusKey(me.ID)
+	if err := db.Rdb.HSet(c, key, "status", req.Status, "text", req.Text, "updated_at", time.Now().UnixMilli()).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "redis error"})
+		return
+	}
+	publishPresence(c, me.ID, me.Username)
+	infos, err := presenceOf(c, []string{me.ID})
+	if err != nil || len(infos) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "redis error"})
+		return
+	}
+	infos[0].Username = me.Username
+	c.JSON(http.StatusOK, infos[0])
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPresenceKeys(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{presenceConnsKey("u1"), "presence:conns:u1"},
+		{presenceStatusKey("u1"), "presence:status:u1"},
+		{presenceLastSeenKey("u1"), "presence:last_seen:u1"},
+		{presenceRoomKey("dev"), "presence:room:dev"},
+		{presenceRoomMember("u1"), instanceID + ":u1"},
+		{roomMemberUserID(presenceRoomMember("u1")), "u1"},
+		{roomMemberUserID("u1"), "u1"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("clé = %q; want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestPresenceExpiry(t *testing.T) {
+	before := time.Now().Add(presenceTTL).UnixMilli()
+	got := int64(presenceExpiry())
+	after := time.Now().Add(presenceTTL).UnixMilli()
+	if got < before || got > after {
+		t.Errorf("presenceExpiry = %d; want dans [%d, %d]", got, before, after)
+	}
+	// Un battement manqué ne doit pas suffire à faire passer hors ligne.
+	if presenceTTL < 2*presenceHeartbeat {
+		t.Errorf("presenceTTL (%v) < 2 battements (%v)", presenceTTL, 2*presenceHeartbeat)
+	}
+}
+
+func TestManualStatuses(t *testing.T) {
+	for s, want := range map[string]bool{"online": true, "away": true, "dnd": true, "offline": false, "": false, "Away": false} {
+		if manualStatuses[s] != want {
+			t.Errorf("manualStatuses[%q] = %v; want %v", s, manualStatuses[s], want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limitation de débit: un seau à jetons par connexion et un par utilisateur,
+// tenus dans Redis pour valoir sur toutes les instances. Les valeurs par défaut
+// se règlent par variables d'environnement (RATE_CONN_BURST, RATE_CONN_PER_SEC,
+// RATE_USER_BURST, RATE_USER_PER_SEC, FLOOD_STRIKES, FLOOD_MUTE).
+type rateConfig struct {
+	connBurst, userBurst float64
+	connRate, userRate   float64 // jetons par seconde
+	floodStrikes         int64   // refus dans floodWindow avant mute automatique
+	floodMute            time.Duration
+}
+
+const (
+	floodWindow = time.Minute
+	maxSlowMode = 6 * time.Hour
+)
+
+var (
+	rateCfg     rateConfig
+	rateCfgOnce sync.Once
+)
+
+func envFloat(name string, def float64) float64 {
+	if f, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && f > 0 {
+		return f
+	}
+	return def
+}
+
+func rateLimits() rateConfig {
+	rateCfgOnce.Do(func() {
+		rateCfg = rateConfig{
+			connBurst:    envFloat("RATE_CONN_BURST", 8),
+			connRate:     envFloat("RATE_CONN_PER_SEC", 1),
+			userBurst:    envFloat("RATE_USER_BURST", 15),
+			userRate:     envFloat("RATE_USER_PER_SEC", 2),
+			floodStrikes: int64(envFloat("FLOOD_STRIKES", 5)),
+			floodMute:    5 * time.Minute,
+		}
+		if d, err := time.ParseDuration(os.Getenv("FLOOD_MUTE")); err == nil && d > 0 {
+			rateCfg.floodMute = d
+		}
+	})
+	return rateCfg
+}
+
+// tokenBucket prend un jeton dans chaque seau de KEYS, seulement si tous en ont un.
+// ARGV: (débit, capacité) par clé. Renvoie {autorisé, attente en ms}.
+// L'horloge est celle de Redis: les instances n'ont pas à être synchronisées.
+var tokenBucket = redis.NewScript(`
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local tokens, wait = {}, 0
+for i, key in ipairs(KEYS) do
+	local rate, burst = tonumber(ARGV[2*i-1]), tonumber(ARGV[2*i])
+	local b = redis.call('HMGET', key, 'tokens', 'ts')
+	local n = tonumber(b[1]) or burst
+	local ts = tonumber(b[2]) or now
+	n = math.min(burst, n + (now - ts) * rate / 1000)
+	tokens[i] = n
+	if n < 1 then
+		wait = math.max(wait, math.ceil((1 - n) * 1000 / rate))
+	end
+end
+local allowed = wait == 0 and 1 or 0
+for i, key in ipairs(KEYS) do
+	local rate, burst = tonumber(ARGV[2*i-1]), tonumber(ARGV[2*i])
+	redis.call('HSET', key, 'tokens', tokens[i] - allowed, 'ts', now)
+	redis.call('PEXPIRE', key, math.ceil(burst * 1000 / rate) + 1000)
+end
+return {allowed, wait}
+`)
+
+// rateSubject identifie l'émetteur: id du compte, IP pour un invité
+// (son pseudonyme est choisi par le client).
+func rateSubject(u WSUser) string {
+	if u.Authenticated && u.ID != "" {
+		return u.ID
+	}
+	return "ip:" + u.IP
+}
+
+// allowFrame consomme un jeton des seaux de la connexion et de l'utilisateur.
+// En cas d'erreur Redis, la trame passe (on ne bloque pas le chat).
+func allowFrame(ctx context.Context, connID string, u WSUser) (bool, time.Duration) {
+	cfg := rateLimits()
+	keys := []string{"ratelimit:conn:" + connID, "ratelimit:user:" + rateSubject(u)}
+	res, err := tokenBucket.Run(ctx, db.Rdb, keys, cfg.connRate, cfg.connBurst, cfg.userRate, cfg.userBurst).Int64Slice()
+	if err != nil || len(res) != 2 {
+		log.Printf("rate limit: %v", err)
+		return true, 0
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond
+}
+
+// recordFlood compte les refus de l'émetteur; au seuil, il est rendu muet
+// dans le salon pour floodMute. INCR garantit un seul mute par fenêtre,
+// quelle que soit l'instance qui voit le refus.
+func recordFlood(ctx context.Context, u WSUser, room string) {
+	cfg := rateLimits()
+	key := "ratelimit:strikes:" + room + ":" + rateSubject(u)
+	n, err := db.Rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return
+	}
+	if n == 1 {
+		db.Rdb.Expire(ctx, key, floodWindow)
+	}
+	if n != cfg.floodStrikes || isDMRoom(room) || canModerate(ctx, u, room) {
+		return
+	}
+
+	t := moderationTarget{user: u}
+	if !u.Authenticated || u.ID == "" {
+		t = moderationTarget{ip: u.IP}
+	}
+	exp := primitive.NewDateTimeFromTime(time.Now().Add(cfg.floodMute))
+	a, err := recordModeration(ctx, models.ModerationAction{
+		Type:         modMute,
+		Room:         room,
+		ActorName:    "Serveur",
+		TargetUserID: t.user.ID,
+		TargetName:   u.Username,
+		TargetIP:     t.ip,
+		Reason:       "flood",
+		ExpiresAt:    &exp,
+	})
+	if err != nil {
+		log.Printf("flood mute %s: %v", room, err)
+		return
+	}
+	invalidateSanctions(room)
+	notifyTarget(ctx, t, a)
+}
+
+// slowModeInterval renvoie l'intervalle du mode lent du salon (0: désactivé).
+func slowModeInterval(ctx context.Context, room string) time.Duration {
+	r, err := cachedRoom(ctx, room)
+	if err != nil {
+		log.Printf("slow mode %s: %v", room, err)
+		return 0
+	}
+	if r == nil {
+		return 0
+	}
+	return time.Duration(r.SlowModeSeconds) * time.Second
+}
+
+// slowModeWait renvoie l'attente restante avant que l'utilisateur puisse
+// écrire à nouveau dans le salon (0: il peut). Les modérateurs en sont exemptés.
+// Le créneau est pris d'emblée (SetNX atomique); release le rend si le message
+// est refusé ensuite (filtres, pièces jointes, file pleine).
+func slowModeWait(ctx context.Context, u WSUser, room string) (wait time.Duration, release func()) {
+	release = func() {}
+	interval := slowModeInterval(ctx, room)
+	if interval <= 0 || canModerate(ctx, u, room) {
+		return 0, release
+	}
+	key := "slowmode:" + room + ":" + rateSubject(u)
+	ok, err := db.Rdb.SetNX(ctx, key, 1, interval).Result()
+	if err != nil {
+		return 0, release
+	}
+	if ok {
+		return 0, func() {
+			if err := db.Rdb.Del(context.Background(), key).Err(); err != nil {
+				log.Printf("slow mode release %s: %v", room, err)
+			}
+		}
+	}
+	left, err := db.Rdb.PTTL(ctx, key).Result()
+	if err != nil || left <= 0 {
+		return 0, release
+	}
+	return left, release
+}
+
+// slowModeHandler: PUT /api/rooms/:room/slow-mode {seconds} — 0 désactive le mode lent.
+func slowModeHandler(c *gin.Context) {
+	var req struct {
+		Seconds int `json:"seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Seconds < 0 || time.Duration(req.Seconds)*time.Second > maxSlowMode {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid seconds"})
+		return
+	}
+	room := c.Param("room")
+	if isDMRoom(room) || !roomNameRe.MatchString(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room"})
+		return
+	}
+	if !canModerate(c, authViewer(c), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	// Un salon public sans document (general) en reçoit un à cette occasion.
+	_, err := db.RoomsCol.UpdateOne(c,
+		bson.M{"name": room},
+		bson.M{
+			"$set":         bson.M{"slow_mode_seconds": req.Seconds},
+			"$setOnInsert": bson.M{"private": false, "created_at": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	forgetRoom(room)
+
+	publish(c, WSEvent{
+		Type:      "room.slow_mode",
+		Room:      room,
+		Data:      gin.H{"seconds": req.Seconds},
+		Timestamp: time.Now().UTC(),
+	})
+	c.JSON(http.StatusOK, gin.H{"room": room, "slow_mode_seconds": req.Seconds})
+}
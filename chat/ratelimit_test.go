@@ -0,0 +1,50 @@
+package chat
+
+import "testing"
+
+func TestRateSubject(t *testing.T) {
+	tests := []struct {
+		name string
+		u    WSUser
+		want string
+	}{
+		{"compte", WSUser{ID: "u1", Authenticated: true, IP: "10.0.0.1"}, "u1"},
+		{"invité", WSUser{Username: "Invité-3", IP: "10.0.0.1"}, "ip:10.0.0.1"},
+		{"id sans authentification", WSUser{ID: "u1", IP: "10.0.0.2"}, "ip:10.0.0.2"},
+	}
+	for _, tt := range tests {
+		if got := rateSubject(tt.u); got != tt.want {
+			t.Errorf("rateSubject(%s) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnvFloat(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"", 4},
+		{"2.5", 2.5},
+		{"10", 10},
+		{"0", 4},
+		{"-3", 4},
+		{"beaucoup", 4},
+	}
+	for _, tt := range tests {
+		t.Setenv("ECRIRE_TEST_RATE", tt.value)
+		if got := envFloat("ECRIRE_TEST_RATE", 4); got != tt.want {
+			t.Errorf("envFloat(%q) = %v; want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPersistItemReleaseSlot(t *testing.T) {
+	persistItem{}.releaseSlot() // sans slow mode: rien à rendre
+
+	calls := 0
+	persistItem{ReleaseSlot: func() { calls++ }}.releaseSlot()
+	if calls != 1 {
+		t.Errorf("releaseSlot: %d appels; want 1", calls)
+	}
+}
@@ -0,0 +1,245 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Nombre maximal de réactions distinctes sur un message.
+const maxReactionsPerMessage = 20
+
+// validEmoji: chaîne courte, sans espace; '.' et '$' sont interdits car l'emoji sert de clé Mongo.
+func validEmoji(e string) bool {
+	if e == "" || len(e) > 32 || !utf8.ValidString(e) || strings.ContainsAny(e, ".$") {
+		return false
+	}
+	for _, r := range e {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// reactionTarget charge le message et vérifie que l'utilisateur peut y réagir.
+func reactionTarget(ctx context.Context, actor WSUser, id, emoji string) (models.Message, error) {
+	if !actor.Authenticated || actor.ID == "" {
+		return models.Message{}, errForbidden
+	}
+	if !validEmoji(emoji) {
+		return models.Message{}, errInvalid
+	}
+	m, err := loadMessage(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, errNotFound
+	}
+	if !canReadRoom(ctx, actor, m.Room) {
+		return m, errForbidden
+	}
+	if reason, _ := sanctionReason(ctx, actor, m.Room); reason != "" {
+		return m, errForbidden
+	}
+	return m, nil
+}
+
+func publishReaction(ctx context.Context, kind string, actor WSUser, m models.Message, emoji string) {
+	count := 0
+	if r, ok := m.Reactions[emoji]; ok {
+		count = r.Count
+	}
+	publish(ctx, WSEvent{
+		Type:      kind,
+		Room:      m.Room,
+		MessageID: m.ID.Hex(),
+		Data:      gin.H{"emoji": emoji, "user_id": actor.ID, "username": actor.Username, "count": count},
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// addReaction ajoute la réaction de l'utilisateur (idempotent) dans la limite de
+// maxReactionsPerMessage emojis distincts. changed est faux si elle existait déjà.
+func addReaction(ctx context.Context, actor WSUser, id, emoji string) (m models.Message, changed bool, err error) {
+	m, err = reactionTarget(ctx, actor, id, emoji)
+	if err != nil {
+		return m, false, err
+	}
+	if contains(m.Reactions[emoji].Users, actor.ID) {
+		return m, false, nil
+	}
+
+	key := "reactions." + emoji
+	filter := bson.M{
+		"_id":          m.ID,
+		"deleted":      bson.M{"$ne": true},
+		key + ".users": bson.M{"$ne": actor.ID},
+		"$or": bson.A{
+			bson.M{key: bson.M{"$exists": true}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{
+				bson.M{"$size": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$reactions", bson.M{}}}}},
+				maxReactionsPerMessage,
+			}}},
+		},
+	}
+	update := bson.M{
+		"$addToSet": bson.M{key + ".users": actor.ID},
+		"$inc":      bson.M{key + ".count": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.Message
+	if err := db.MessagesCol.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if err != mongo.ErrNoDocuments {
+			return m, false, err
+		}
+		// Rien n'a bougé: réaction concurrente identique, ou plafond atteint.
+		if m, err = loadMessage(ctx, id); err != nil {
+			return m, false, err
+		}
+		if contains(m.Reactions[emoji].Users, actor.ID) {
+			return m, false, nil
+		}
+		return m, false, errLimit
+	}
+	publishReaction(ctx, "reaction.added", actor, updated, emoji)
+	return updated, true, nil
+}
+
+// removeReaction retire la réaction de l'utilisateur; l'emoji disparaît à zéro.
+func removeReaction(ctx context.Context, actor WSUser, id, emoji string) (m models.Message, changed bool, err error) {
+	m, err = reactionTarget(ctx, actor, id, emoji)
+	if err != nil {
+		return m, false, err
+	}
+
+	key := "reactions." + emoji
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.Message
+	err = db.MessagesCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": m.ID, key + ".users": actor.ID},
+		bson.M{"$pull": bson.M{key + ".users": actor.ID}, "$inc": bson.M{key + ".count": -1}},
+		opts,
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return m, false, nil
+	}
+	if err != nil {
+		return m, false, err
+	}
+	if r := updated.Reactions[emoji]; r.Count <= 0 {
+		_, _ = db.MessagesCol.UpdateOne(ctx,
+			bson.M{"_id": m.ID, key + ".count": bson.M{"$lte": 0}},
+			bson.M{"$unset": bson.M{key: ""}},
+		)
+		delete(updated.Reactions, emoji)
+	}
+	publishReaction(ctx, "reaction.removed", actor, updated, emoji)
+	return updated, true, nil
+}
+
+// reactionCounts est la forme agrégée renvoyée dans l'historique: emoji -> nombre.
+func reactionCounts(m models.Message) map[string]int {
+	out := make(map[string]int, len(m.Reactions))
+	for e, r := range m.Reactions {
+		if r.Count > 0 {
+			out[e] = r.Count
+		}
+	}
+	return out
+}
+
+// addReactionHandler: POST /api/messages/:id/reactions {emoji}
+func addReactionHandler(c *gin.Context) {
+	var req struct {
+		Emoji string `json:"emoji"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	m, _, err := addReaction(c, authViewer(c), c.Param("id"), req.Emoji)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": m.ID.Hex(), "reactions": reactionCounts(m)})
+}
+
+// removeReactionHandler: DELETE /api/messages/:id/reactions/:emoji
+func removeReactionHandler(c *gin.Context) {
+	m, _, err := removeReaction(c, authViewer(c), c.Param("id"), c.Param("emoji"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": m.ID.Hex(), "reactions": reactionCounts(m)})
+}
+
+// reactorsHandler: GET /api/messages/:id/reactions[?emoji=] — qui a réagi, par emoji.
+func reactorsHandler(c *gin.Context) {
+	viewer := requestViewer(c.Request)
+	m, err := loadMessage(c, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	if !canReadRoom(c, viewer, m.Room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	only := c.Query("emoji")
+	var ids []primitive.ObjectID
+	for e, r := range m.Reactions {
+		if only != "" && e != only {
+			continue
+		}
+		for _, id := range r.Users {
+			if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+				ids = append(ids, oid)
+			}
+		}
+	}
+	names := make(map[string]string, len(ids))
+	if len(ids) > 0 {
+		cur, err := db.UsersCol.Find(c, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"username": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var users []models.User
+		if err := cur.All(c, &users); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+			return
+		}
+		for _, u := range users {
+			names[u.ID.Hex()] = u.Username
+		}
+	}
+
+	out := make([]gin.H, 0, len(m.Reactions))
+	for e, r := range m.Reactions {
+		if only != "" && e != only {
+			continue
+		}
+		users := make([]gin.H, 0, len(r.Users))
+		for _, id := range r.Users {
+			users = append(users, gin.H{"id": id, "username": names[id]})
+		}
+		out = append(out, gin.H{"emoji": e, "count": r.Count, "users": users})
+	}
+	c.JSON(http.StatusOK, gin.H{"id": m.ID.Hex(), "reactions": out})
+}
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+)
+
+func TestValidEmoji(t *testing.T) {
+	tests := []struct {
+		e    string
+		want bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👨‍👩‍👧", true},
+		{":party:", true},
+		{"", false},
+		{"a b", false},
+		{"\t", false},
+		{"x\x00", false},
+		{"a.b", false},
+		{"$set", false},
+		{"\xff", false},
+		{strings.Repeat("a", 33), false},
+		{strings.Repeat("a", 32), true},
+	}
+	for _, tt := range tests {
+		if got := validEmoji(tt.e); got != tt.want {
+			t.Errorf("validEmoji(%q) = %v; want %v", tt.e, got, tt.want)
+		}
+	}
+}
+
+func TestReactionCounts(t *testing.T) {
+	m := models.Message{Reactions: map[string]models.Reaction{
+		"👍": {Count: 2, Users: []string{"u1", "u2"}},
+		"🎉": {Count: 1, Users: []string{"u3"}},
+		"😢": {Count: 0},
+	}}
+	want := map[string]int{"👍": 2, "🎉": 1}
+	if got := reactionCounts(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("reactionCounts = %v; want %v", got, want)
+	}
+	if got := reactionCounts(models.Message{}); got == nil || len(got) != 0 {
+		t.Errorf("reactionCounts(sans réaction) = %#v; want map vide", got)
+	}
+}
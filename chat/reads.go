@@ -0,0 +1,330 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Les positions de lecture sont regroupées en mémoire et écrites par lots:
+// un défilement rapide produit une seule écriture par (utilisateur, salon).
+const readFlushInterval = 2 * time.Second
+
+// maxUnreadCount plafonne le compteur renvoyé par salon (les clients affichent "999+").
+const maxUnreadCount = 999
+
+type readKey struct{ userID, room string }
+
+var (
+	readMu      sync.Mutex
+	readPending = map[readKey]int64{} // position la plus avancée non encore écrite
+	readOnce    sync.Once
+)
+
+func startReadFlusher() {
+	readOnce.Do(func() {
+		go func() {
+			for range time.Tick(readFlushInterval) {
+				flushReads()
+			}
+		}()
+	})
+}
+
+// markRead enregistre la position de lecture (seq, ou id de message) pour un flush ultérieur.
+func markRead(ctx context.Context, u WSUser, room, messageID string, seq int64) (int64, error) {
+	if !u.Authenticated || u.ID == "" {
+		return 0, errForbidden
+	}
+	if room == "" {
+		room = "general"
+	}
+	if !canReadRoom(ctx, u, room) {
+		return 0, errForbidden
+	}
+	if seq <= 0 && messageID != "" {
+		m, err := loadMessage(ctx, messageID)
+		if err != nil {
+			return 0, err
+		}
+		if m.Room != room {
+			return 0, errInvalid
+		}
+		seq = m.Seq
+	}
+	if seq <= 0 {
+		return 0, errInvalid
+	}
+	k := readKey{u.ID, room}
+	readMu.Lock()
+	if seq > readPending[k] {
+		readPending[k] = seq
+	}
+	readMu.Unlock()
+	return seq, nil
+}
+
+// flushReads écrit les positions en attente ($max: une position ne recule jamais)
+// puis signale la lecture à l'autre participant des DM.
+func flushReads() {
+	readMu.Lock()
+	if len(readPending) == 0 {
+		readMu.Unlock()
+		return
+	}
+	batch := readPending
+	readPending = map[readKey]int64{}
+	readMu.Unlock()
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	ops := make([]mongo.WriteModel, 0, len(batch))
+	for k, seq := range batch {
+		ops = append(ops, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"user_id": k.userID, "room": k.room}).
+			SetUpdate(bson.M{"$max": bson.M{"last_read_seq": seq}, "$set": bson.M{"updated_at": now}}).
+			SetUpsert(true))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := db.ReadStatesCol.BulkWrite(ctx, ops, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Printf("flush read states: %v", err)
+		return
+	}
+
+	for k, seq := range batch {
+		a, b, ok := dmParticipants(k.room)
+		if !ok {
+			continue
+		}
+		other := a
+		if other == k.userID {
+			other = b
+		}
+		wsHub.sendToUser(other, WSEvent{
+			Type:      "read.updated",
+			Room:      k.room,
+			Data:      gin.H{"user_id": k.userID, "last_read_seq": seq},
+			Timestamp: now.Time().UTC(),
+		})
+	}
+}
+
+// readPositions renvoie les positions de lecture de l'utilisateur, écritures en attente comprises.
+func readPositions(ctx context.Context, userID string) (map[string]int64, error) {
+	cur, err := db.ReadStatesCol.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	var states []models.ReadState
+	if err := cur.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	out := make(map[string]int64, len(states))
+	for _, s := range states {
+		out[s.Room] = s.LastReadSeq
+	}
+	readMu.Lock()
+	for k, seq := range readPending {
+		if k.userID == userID && seq > out[k.room] {
+			out[k.room] = seq
+		}
+	}
+	readMu.Unlock()
+	return out, nil
+}
+
+// userRooms liste les salons de l'utilisateur: general, salons publics,
+// salons privés dont il est membre, ses DM et tout salon déjà lu.
+func userRooms(ctx context.Context, u WSUser, positions map[string]int64) ([]string, error) {
+	set := map[string]bool{"general": true}
+	for r := range positions {
+		set[r] = true
+	}
+	cur, err := db.RoomsCol.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"private": false},
+		bson.M{"owner_id": u.ID},
+		bson.M{"members": u.ID},
+	}}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var rooms []models.Room
+	if err := cur.All(ctx, &rooms); err != nil {
+		return nil, err
+	}
+	for _, r := range rooms {
+		set[r.Name] = true
+	}
+	dms, err := db.MessagesCol.Distinct(ctx, "room", bson.M{"room": primitive.Regex{
+		Pattern: "^" + dmPrefix + "(" + u.ID + ":[^:]+|[^:]+:" + u.ID + ")$",
+	}})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range dms {
+		if name, ok := r.(string); ok {
+			set[name] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for r := range set {
+		if canReadRoom(ctx, u, r) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// unreadPipeline compte, pour chaque salon, les non lus et les mentions non lues.
+// Un document par salon ($documents), puis un $lookup borné à maxUnreadCount+1
+// messages: le coût ne dépend pas de l'ancienneté de la dernière lecture.
+// Sans position de lecture, le salon est compté depuis since (seq sans borne).
+func unreadPipeline(rooms []string, positions map[string]int64, since primitive.DateTime, userID string, authors []string) mongo.Pipeline {
+	docs := make(bson.A, 0, len(rooms))
+	for _, r := range rooms {
+		var after, from interface{} = primitive.MinKey{}, since
+		if seq, ok := positions[r]; ok {
+			after, from = seq, primitive.MinKey{}
+		}
+		docs = append(docs, bson.M{"room": r, "after_seq": after, "since": from})
+	}
+	unread := func(extra bson.M) bson.A {
+		match := bson.M{
+			"$expr": bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$room", "$$room"}},
+				bson.M{"$gt": bson.A{"$seq", "$$after_seq"}},
+				bson.M{"$gte": bson.A{"$created_at", "$$since"}},
+			}},
+			"user_id": bson.M{"$nin": authors},
+			"deleted": bson.M{"$ne": true},
+		}
+		for k, v := range extra {
+			match[k] = v
+		}
+		return bson.A{
+			bson.M{"$match": match},
+			bson.M{"$sort": bson.M{"seq": -1}},
+			bson.M{"$limit": maxUnreadCount + 1},
+			bson.M{"$project": bson.M{"_id": 0, "seq": 1}},
+		}
+	}
+	lookup := func(as string, extra bson.M) bson.D {
+		return bson.D{{Key: "$lookup", Value: bson.M{
+			"from":     "messages",
+			"let":      bson.M{"room": "$room", "after_seq": "$after_seq", "since": "$since"},
+			"pipeline": unread(extra),
+			"as":       as,
+		}}}
+	}
+	mentioned := bson.M{"$or": bson.A{
+		bson.M{"mentions": userID},
+		bson.M{"mention_room": true},
+		bson.M{"mention_here": true},
+	}}
+	return mongo.Pipeline{
+		{{Key: "$documents", Value: docs}},
+		lookup("unread", nil),
+		lookup("mentions", mentioned),
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"room":     1,
+			"unread":   bson.M{"$min": bson.A{bson.M{"$size": "$unread"}, maxUnreadCount}},
+			"mentions": bson.M{"$min": bson.A{bson.M{"$size": "$mentions"}, maxUnreadCount}},
+			"last_seq": bson.M{"$ifNull": bson.A{bson.M{"$max": "$unread.seq"}, 0}},
+		}}},
+	}
+}
+
+// unreadHandler: GET /api/rooms/unread — non lus et mentions non lues, par salon, en une requête.
+func unreadHandler(c *gin.Context) {
+	me := authViewer(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	positions, err := readPositions(ctx, me.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	rooms, err := userRooms(ctx, me, positions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	// Sans position de lecture, on compte depuis la création du compte (horodatage
+	// de son ObjectID), pas depuis le début de l'historique du salon.
+	var since primitive.DateTime
+	if oid, err := primitive.ObjectIDFromHex(me.ID); err == nil {
+		since = primitive.NewDateTimeFromTime(oid.Timestamp())
+	}
+	// Comme l'historique: ni ses propres messages, ni ceux des utilisateurs bloqués.
+	authors, err := blockedIDs(ctx, me.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	authors = append(authors, me.ID)
+
+	counts := map[string]gin.H{}
+	if len(rooms) > 0 {
+		cur, err := db.MessagesCol.Database().Aggregate(ctx, unreadPipeline(rooms, positions, since, me.ID, authors))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var rows []struct {
+			Room     string `bson:"room"`
+			Unread   int64  `bson:"unread"`
+			Mentions int64  `bson:"mentions"`
+			LastSeq  int64  `bson:"last_seq"`
+		}
+		if err := cur.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+			return
+		}
+		for _, r := range rows {
+			if r.Unread > 0 {
+				counts[r.Room] = gin.H{"unread": r.Unread, "mentions": r.Mentions, "last_seq": r.LastSeq}
+			}
+		}
+	}
+
+	out := make([]gin.H, 0, len(rooms))
+	for _, r := range rooms {
+		item := gin.H{"room": r, "last_read_seq": positions[r], "unread": 0, "mentions": 0}
+		for k, v := range counts[r] {
+			item[k] = v
+		}
+		out = append(out, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"rooms": out})
+}
+
+// markReadHandler: POST /api/rooms/:room/read {seq} ou {message_id}
+func markReadHandler(c *gin.Context) {
+	var req struct {
+		Seq       int64  `json:"seq"`
+		MessageID string `json:"message_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	seq, err := markRead(c, authViewer(c), c.Param("room"), req.MessageID, req.Seq)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"room": c.Param("room"), "last_read_seq": seq})
+}
@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUnreadPipeline(t *testing.T) {
+	since := primitive.NewDateTimeFromTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := unreadPipeline([]string{"general", "jeux"}, map[string]int64{"general": 41}, since, "u1", []string{"b1", "u1"})
+	if len(p) != 4 {
+		t.Fatalf("unreadPipeline: %d étapes; want 4", len(p))
+	}
+
+	// Salon lu: borne par seq; jamais lu: borne par date d'inscription.
+	wantDocs := bson.A{
+		bson.M{"room": "general", "after_seq": int64(41), "since": primitive.MinKey{}},
+		bson.M{"room": "jeux", "after_seq": primitive.MinKey{}, "since": since},
+	}
+	if got := p[0][0].Value; p[0][0].Key != "$documents" || !reflect.DeepEqual(got, wantDocs) {
+		t.Errorf("étape 0 = %v; want $documents %v", p[0], wantDocs)
+	}
+
+	for i, as := range map[int]string{1: "unread", 2: "mentions"} {
+		lk, ok := p[i][0].Value.(bson.M)
+		if p[i][0].Key != "$lookup" || !ok || lk["as"] != as {
+			t.Fatalf("étape %d = %v; want $lookup as %s", i, p[i], as)
+		}
+		sub := lk["pipeline"].(bson.A)
+		match := sub[0].(bson.M)["$match"].(bson.M)
+		if !reflect.DeepEqual(match["user_id"], bson.M{"$nin": []string{"b1", "u1"}}) {
+			t.Errorf("%s: user_id = %v; want auteurs exclus", as, match["user_id"])
+		}
+		// Le coût est borné par salon, pas seulement le résultat.
+		if got := sub[2].(bson.M)["$limit"]; got != maxUnreadCount+1 {
+			t.Errorf("%s: $limit = %v; want %d", as, got, maxUnreadCount+1)
+		}
+		_, mentions := match["$or"]
+		if mentions != (as == "mentions") {
+			t.Errorf("%s: filtre de mention présent = %v", as, mentions)
+		}
+	}
+}
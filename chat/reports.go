@@ -0,0 +1,321 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Statuts d'un signalement.
+const (
+	reportOpen      = "open"
+	reportActioned  = "actioned"
+	reportDismissed = "dismissed"
+)
+
+var reportCategories = map[string]bool{
+	"spam": true, "harassment": true, "hate": true, "nsfw": true, "violence": true, "other": true,
+}
+
+const maxReportComment = 1000
+
+// reportHideThreshold: nombre de signalements ouverts qui masque un message
+// en attendant la revue (REPORT_HIDE_THRESHOLD, 3 par défaut).
+func reportHideThreshold() int64 {
+	return int64(envFloat("REPORT_HIDE_THRESHOLD", 3))
+}
+
+// canHandleReport: modérateur du salon pour un message, modérateur global sinon.
+func canHandleReport(ctx context.Context, u WSUser, r models.Report) bool {
+	if r.Room != "" {
+		return canModerate(ctx, u, r.Room)
+	}
+	return isGlobalModerator(ctx, u)
+}
+
+// hideMessage masque le message et le place dans la file de revue (une seule fois).
+func hideMessage(ctx context.Context, m models.Message, reports int64) {
+	res, err := db.MessagesCol.UpdateOne(ctx,
+		bson.M{"_id": m.ID, "hidden": bson.M{"$ne": true}, "deleted": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"hidden": true}},
+	)
+	if err != nil {
+		log.Printf("hide message %s: %v", m.ID.Hex(), err)
+		return
+	}
+	if res.ModifiedCount == 0 {
+		return
+	}
+	setQuoteExcerpts(ctx, m.ID.Hex(), "")
+	if err := currentSearch().Remove(ctx, m.ID.Hex()); err != nil {
+		log.Printf("search remove failed: %v", err)
+	}
+	publish(ctx, WSEvent{
+		Type:      "message.hidden",
+		Room:      m.Room,
+		MessageID: m.ID.Hex(),
+		Timestamp: time.Now().UTC(),
+	})
+	flagForReview(ctx, m, []string{"reports: " + strconv.FormatInt(reports, 10) + " reports"}, false)
+}
+
+// unhideMessage rétablit un message masqué (revue approuvée).
+func unhideMessage(ctx context.Context, m models.Message) {
+	res, err := db.MessagesCol.UpdateOne(ctx,
+		bson.M{"_id": m.ID, "hidden": true, "deleted": bson.M{"$ne": true}},
+		bson.M{"$unset": bson.M{"hidden": ""}},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		return
+	}
+	m.Hidden = false
+	setQuoteExcerpts(ctx, m.ID.Hex(), excerpt(m.Content, quoteExcerptLen))
+	if err := currentSearch().Index(ctx, m); err != nil {
+		log.Printf("search index failed: %v", err)
+	}
+	publish(ctx, WSEvent{
+		Type:      "message.unhidden",
+		Room:      m.Room,
+		MessageID: m.ID.Hex(),
+		Data:      gin.H{"text": m.Content},
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// createReportHandler: POST /api/reports {message_id | user, category, comment}
+// Un second signalement de la même cible par le même auteur renvoie le premier.
+func createReportHandler(c *gin.Context) {
+	var req struct {
+		MessageID string `json:"message_id"`
+		User      string `json:"user"` // id ou username
+		Category  string `json:"category"`
+		Comment   string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.MessageID == "") == (req.User == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message_id or user required"})
+		return
+	}
+	if !reportCategories[req.Category] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category"})
+		return
+	}
+	if len(req.Comment) > maxReportComment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "comment too long"})
+		return
+	}
+	me := authViewer(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r := models.Report{
+		ID:           primitive.NewObjectID(),
+		ReporterID:   me.ID,
+		ReporterName: me.Username,
+		Category:     req.Category,
+		Comment:      req.Comment,
+		Status:       reportOpen,
+		CreatedAt:    primitive.NewDateTimeFromTime(time.Now()),
+	}
+	var msg models.Message
+	if req.MessageID != "" {
+		m, err := loadMessage(ctx, req.MessageID)
+		if err == nil && (m.Deleted || !canReadRoom(ctx, me, m.Room)) {
+			err = errNotFound
+		}
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+			return
+		}
+		if isAuthor(me, m) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot report own message"})
+			return
+		}
+		msg = m
+		r.TargetType, r.TargetKey = "message", "message:"+m.ID.Hex()
+		r.MessageID, r.Room = m.ID.Hex(), m.Room
+		r.TargetUserID, r.TargetName = m.UserID, m.Sender
+		r.Excerpt = excerpt(m.Content, quoteExcerptLen)
+	} else {
+		t, err := resolveTarget(ctx, req.User, "")
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+			return
+		}
+		if t.user.ID == me.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot report yourself"})
+			return
+		}
+		r.TargetType, r.TargetKey = "user", "user:"+t.user.ID
+		r.TargetUserID, r.TargetName = t.user.ID, t.user.Username
+	}
+
+	if _, err := db.ReportsCol.InsertOne(ctx, r); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			var existing models.Report
+			if err := db.ReportsCol.FindOne(ctx, bson.M{"reporter_id": me.ID, "target_key": r.TargetKey}).Decode(&existing); err == nil {
+				c.JSON(http.StatusOK, gin.H{"report": existing, "duplicate": true})
+				return
+			}
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if r.TargetType == "message" {
+		n, err := db.ReportsCol.CountDocuments(ctx, bson.M{"target_key": r.TargetKey, "status": reportOpen})
+		if err != nil {
+			log.Printf("count reports %s: %v", r.TargetKey, err)
+		} else if n >= reportHideThreshold() {
+			hideMessage(ctx, msg, n)
+		}
+	}
+	c.JSON(http.StatusCreated, gin.H{"report": r, "duplicate": false})
+}
+
+// listReportsHandler: GET /api/moderation/reports?status=open&category=&room=&target_type=&assignee=me|none|<id>&limit=&before=<id>
+// Sans room, réservé aux modérateurs globaux.
+func listReportsHandler(c *gin.Context) {
+	me := authViewer(c)
+	room := c.Query("room")
+	if room == "" && !isGlobalModerator(c, me) || room != "" && !canModerate(c, me, room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	filter := bson.M{"status": c.DefaultQuery("status", reportOpen)}
+	if room != "" {
+		filter["room"] = room
+	}
+	if cat := c.Query("category"); cat != "" {
+		filter["category"] = cat
+	}
+	if tt := c.Query("target_type"); tt != "" {
+		filter["target_type"] = tt
+	}
+	switch a := c.Query("assignee"); a {
+	case "":
+	case "me":
+		filter["assignee_id"] = me.ID
+	case "none":
+		filter["assignee_id"] = bson.M{"$exists": false}
+	default:
+		filter["assignee_id"] = a
+	}
+	if before := c.Query("before"); before != "" {
+		oid, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": oid}
+	}
+	limit := 50
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cur, err := db.ReportsCol.Find(c, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	reports := []models.Report{}
+	if err := cur.All(c, &reports); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	var next interface{}
+	if len(reports) == limit {
+		next = reports[len(reports)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, gin.H{"reports": reports, "next": next})
+}
+
+// updateReportHandler: PATCH /api/moderation/reports/:id {status, assignee, resolution}
+// assignee: "me", un id, ou "" pour désassigner; absent: inchangé.
+func updateReportHandler(c *gin.Context) {
+	var req struct {
+		Status     string  `json:"status"`
+		Assignee   *string `json:"assignee"`
+		Resolution string  `json:"resolution"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	switch req.Status {
+	case "", reportOpen, reportActioned, reportDismissed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var r models.Report
+	if err := db.ReportsCol.FindOne(ctx, bson.M{"_id": oid}).Decode(&r); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+		return
+	}
+	me := authViewer(c)
+	if !canHandleReport(ctx, me, r) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	if req.Assignee != nil {
+		switch a := *req.Assignee; a {
+		case "":
+			unset["assignee_id"] = ""
+		case "me":
+			set["assignee_id"] = me.ID
+		default:
+			set["assignee_id"] = a
+		}
+	}
+	if req.Resolution != "" {
+		set["resolution"] = req.Resolution
+	}
+	switch req.Status {
+	case reportActioned, reportDismissed:
+		set["status"] = req.Status
+		set["resolved_by"] = me.ID
+		set["resolved_at"] = primitive.NewDateTimeFromTime(time.Now())
+	case reportOpen:
+		set["status"] = reportOpen
+		unset["resolved_by"], unset["resolved_at"] = "", ""
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := db.ReportsCol.FindOneAndUpdate(ctx, bson.M{"_id": oid}, update, opts).Decode(&r); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,34 @@
+package chat
+
+import "testing"
+
+func TestReportHideThreshold(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int64
+	}{
+		{"", 3},
+		{"5", 5},
+		{"1", 1},
+		{"0", 3},
+		{"-2", 3},
+		{"trois", 3},
+	}
+	for _, tt := range tests {
+		t.Setenv("REPORT_HIDE_THRESHOLD", tt.env)
+		if got := reportHideThreshold(); got != tt.want {
+			t.Errorf("reportHideThreshold(%q) = %d; want %d", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestReportCategories(t *testing.T) {
+	for c, want := range map[string]bool{
+		"spam": true, "harassment": true, "hate": true, "nsfw": true, "violence": true, "other": true,
+		"": false, "Spam": false, "abuse": false,
+	} {
+		if reportCategories[c] != want {
+			t.Errorf("reportCategories[%q] = %v; want %v", c, reportCategories[c], want)
+		}
+	}
+}
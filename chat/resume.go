@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Taille des lots lus en base pendant un rejeu.
+const replayBatch = 500
+
+// WSResumed marque la fin du rejeu d'un salon: la suite arrive en direct.
+type WSResumed struct {
+	Type    string `json:"type"` // toujours "resumed"
+	Room    string `json:"room"`
+	LastSeq int64  `json:"last_seq"`
+	Count   int    `json:"count"`
+}
+
+// nextRoomSeq incrémente atomiquement le compteur du salon (collection counters).
+// Les numéros sont monotones par salon, y compris entre plusieurs instances.
+func nextRoomSeq(ctx context.Context, room string) (int64, error) {
+	var out struct {
+		Seq int64 `bson:"seq"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := db.CountersCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": "room_seq:" + room},
+		bson.M{"$inc": bson.M{"seq": int64(1)}},
+		opts,
+	).Decode(&out)
+	return out.Seq, err
+}
+
+// resolveResumeCursor accepte un numéro de séquence ou l'id du dernier message vu.
+func resolveResumeCursor(ctx context.Context, room, cursor string) (int64, error) {
+	if n, err := strconv.ParseInt(cursor, 10, 64); err == nil && n >= 0 {
+		return n, nil
+	}
+	oid, err := primitive.ObjectIDFromHex(cursor)
+	if err != nil {
+		return 0, errors.New("curseur de reprise invalide")
+	}
+	var m models.Message
+	if err := db.MessagesCol.FindOne(ctx, bson.M{"_id": oid, "room": room}).Decode(&m); err != nil {
+		return 0, errors.New("message de reprise introuvable")
+	}
+	return m.Seq, nil
+}
+
+// toWSMessage convertit un message stocké en message diffusable.
+func toWSMessage(m models.Message) WSMessage {
+	out := WSMessage{
+		ID:           m.ID.Hex(),
+		Seq:          m.Seq,
+		Username:     m.Sender,
+		Avatar:       avatarFor(m),
+		Guest:        m.UserID == "",
+		authorID:     m.UserID,
+		Text:         m.Content,
+		Timestamp:    m.CreatedAt.Time().UTC(),
+		Room:         m.Room,
+		Deleted:      m.Deleted,
+		ReplyTo:      m.ReplyTo,
+		Quote:        m.Quote,
+		ThreadRoot:   m.ThreadRoot,
+		ReplyCount:   m.ReplyCount,
+		Attachments:  attachmentRefs(m),
+		Pinned:       m.PinnedAt != nil,
+		Announcement: m.Announcement,
+		Persistent:   m.Persistent,
+		Poll:         pollView(m.Poll),
+		Command:      m.Command,
+	}
+	if len(m.Reactions) > 0 {
+		out.Reactions = reactionCounts(m)
+	}
+	if m.Hidden {
+		out.Hidden, out.Text = true, ""
+	}
+	if m.EditedAt != nil {
+		t := m.EditedAt.Time().UTC()
+		out.EditedAt = &t
+	}
+	return out
+}
+
+func (h *hub) beginReplay(c *websocket.Conn, room string) {
+	h.mu.Lock()
+	if cl, ok := h.conns[c]; ok {
+		cl.replaying[room] = []roomEvent{}
+	}
+	h.mu.Unlock()
+}
+
+// endReplay vide le tampon live (sans ce qui a déjà été rejoué) puis signale la fin du rejeu,
+// ou son échec si la lecture en base n'a pas abouti (le client doit alors recharger l'historique).
+// Tout se fait sous le verrou: aucun message live ne peut s'intercaler.
+func (h *hub) endReplay(c *websocket.Conn, room string, lastSeq int64, count int, failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.conns[c]
+	if !ok {
+		return
+	}
+	buf := cl.replaying[room]
+	delete(cl.replaying, room)
+	for _, ev := range buf {
+		seq := ev.eventSeq()
+		if seq != 0 && seq <= lastSeq {
+			continue // déjà rejoué depuis la base
+		}
+		if seq > lastSeq {
+			lastSeq = seq
+		}
+		h.write(c, ev)
+		count++
+	}
+	if failed {
+		h.write(c, WSError{Type: "error", Code: "resume_failed", Room: room})
+		return
+	}
+	h.write(c, WSResumed{Type: "resumed", Room: room, LastSeq: lastSeq, Count: count})
+}
+
+// resumeRoom rejoue les messages du salon postérieurs au curseur puis bascule en direct.
+// La diffusion live est mise en tampon pendant la lecture en base, ce qui évite
+// trous et doublons: un message live est soit déjà en base, soit dans le tampon.
+func resumeRoom(conn *websocket.Conn, room, cursor string) {
+	if room == "" {
+		room = "general"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	after, err := resolveResumeCursor(ctx, room, cursor)
+	if err != nil {
+		wsHub.send(conn, WSError{Type: "error", Code: "invalid_resume", Room: room, Detail: err.Error()})
+		return
+	}
+
+	wsHub.rejoin(conn, room)
+	wsHub.beginReplay(conn, room)
+	lastSeq, count, failed := after, 0, false
+	defer func() { wsHub.endReplay(conn, room, lastSeq, count, failed) }()
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(replayBatch)
+	for {
+		cur, err := db.MessagesCol.Find(ctx, bson.M{"room": room, "seq": bson.M{"$gt": lastSeq}}, opts)
+		if err != nil {
+			log.Printf("resume %s: %v", room, err)
+			failed = true
+			return
+		}
+		var batch []models.Message
+		err = cur.All(ctx, &batch)
+		if err != nil {
+			log.Printf("resume %s: %v", room, err)
+			failed = true
+			return
+		}
+		for _, m := range batch {
+			wsHub.sendEvent(conn, toWSMessage(m))
+			lastSeq = m.Seq
+			count++
+		}
+		if len(batch) < replayBatch {
+			return
+		}
+	}
+}
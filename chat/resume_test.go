@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestResolveResumeCursor(t *testing.T) {
+	tests := []struct {
+		cursor  string
+		want    int64
+		wantErr bool
+	}{
+		{cursor: "0", want: 0},
+		{cursor: "42", want: 42},
+		{cursor: "-1", wantErr: true},
+		{cursor: "demain", wantErr: true},
+		{cursor: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		// Les curseurs numériques et invalides ne touchent pas à la base.
+		got, err := resolveResumeCursor(context.Background(), "general", tt.cursor)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveResumeCursor(%q) = %d, %v; want %d (erreur: %v)", tt.cursor, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestToWSMessage(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	edited := primitive.NewDateTimeFromTime(at.Add(time.Minute))
+	base := models.Message{
+		ID:        primitive.NewObjectIDFromTimestamp(at),
+		Seq:       12,
+		Sender:    "alice",
+		UserID:    "u1",
+		Content:   "bonjour",
+		Room:      "general",
+		CreatedAt: primitive.NewDateTimeFromTime(at),
+	}
+
+	m := toWSMessage(base)
+	if m.ID != base.ID.Hex() || m.Seq != 12 || m.Username != "alice" || m.Text != "bonjour" || m.Room != "general" {
+		t.Errorf("toWSMessage = %+v; champs de base incorrects", m)
+	}
+	if m.Guest || m.authorID != "u1" || !m.Timestamp.Equal(at) || m.EditedAt != nil || m.Reactions != nil {
+		t.Errorf("toWSMessage = %+v; auteur, horodatage ou champs optionnels incorrects", m)
+	}
+
+	guest := base
+	guest.UserID = ""
+	if m := toWSMessage(guest); !m.Guest || m.authorID != "" {
+		t.Errorf("toWSMessage(invité) = guest %v, author %q; want true, \"\"", m.Guest, m.authorID)
+	}
+
+	hidden := base
+	hidden.Hidden = true
+	if m := toWSMessage(hidden); !m.Hidden || m.Text != "" {
+		t.Errorf("toWSMessage(masqué) = hidden %v, text %q; want true, \"\"", m.Hidden, m.Text)
+	}
+
+	rich := base
+	rich.EditedAt = &edited
+	rich.Reactions = map[string]models.Reaction{"👍": {Count: 2, Users: []string{"u2", "u3"}}}
+	m = toWSMessage(rich)
+	if m.EditedAt == nil || !m.EditedAt.Equal(edited.Time()) {
+		t.Errorf("toWSMessage(édité).EditedAt = %v; want %v", m.EditedAt, edited.Time())
+	}
+	if m.Reactions["👍"] != 2 {
+		t.Errorf("toWSMessage(réactions).Reactions = %v; want 👍:2", m.Reactions)
+	}
+}
@@ -0,0 +1,160 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Statuts de la file de revue.
+const (
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewRemoved  = "removed"
+)
+
+// flagForReview place un message dans la file de revue (filtres, seuil de signalements).
+func flagForReview(ctx context.Context, m models.Message, reasons []string, edit bool) {
+	item := models.ReviewItem{
+		ID:        primitive.NewObjectID(),
+		MessageID: m.ID.Hex(),
+		Room:      m.Room,
+		UserID:    m.UserID,
+		Username:  m.Sender,
+		Text:      m.Content,
+		Reasons:   reasons,
+		Edit:      edit,
+		Status:    reviewPending,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := db.ReviewsCol.InsertOne(ctx, item); err != nil {
+		log.Printf("review queue %s: %v", item.MessageID, err)
+	}
+}
+
+// reviewsHandler: GET /api/moderation/reviews?status=pending&room=&limit=&before=<id>
+// Sans room, réservé aux modérateurs globaux.
+func reviewsHandler(c *gin.Context) {
+	me := authViewer(c)
+	room := c.Query("room")
+	if room == "" && !isGlobalModerator(c, me) || room != "" && !canModerate(c, me, room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	status := c.DefaultQuery("status", reviewPending)
+	filter := bson.M{"status": status}
+	if room != "" {
+		filter["room"] = room
+	}
+	if before := c.Query("before"); before != "" {
+		oid, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": oid}
+	}
+	limit := 50
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cur, err := db.ReviewsCol.Find(c, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	items := []models.ReviewItem{}
+	if err := cur.All(c, &items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	var next interface{}
+	if len(items) == limit {
+		next = items[len(items)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, gin.H{"reviews": items, "next": next})
+}
+
+// resolveReviewHandler: POST /api/moderation/reviews/:id {decision: approve|remove, note}
+// "remove" supprime le message (tombstone) s'il existe encore, "approve" le démasque;
+// les signalements ouverts du message sont clos en conséquence.
+func resolveReviewHandler(c *gin.Context) {
+	var req struct {
+		Decision string `json:"decision"`
+		Note     string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Decision != "approve" && req.Decision != "remove") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be approve or remove"})
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var item models.ReviewItem
+	if err := db.ReviewsCol.FindOne(ctx, bson.M{"_id": oid}).Decode(&item); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return
+	}
+	me := authViewer(c)
+	if !canModerate(ctx, me, item.Room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	status := reviewApproved
+	if req.Decision == "remove" {
+		status = reviewRemoved
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	res, err := db.ReviewsCol.UpdateOne(ctx,
+		bson.M{"_id": oid, "status": reviewPending},
+		bson.M{"$set": bson.M{"status": status, "reviewed_by": me.ID, "reviewed_at": now, "note": req.Note}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "already reviewed"})
+		return
+	}
+
+	if m, err := loadMessage(ctx, item.MessageID); err == nil && !m.Deleted {
+		if status == reviewRemoved {
+			if _, err := tombstone(ctx, m, me.ID); err != nil {
+				log.Printf("review remove %s: %v", item.MessageID, err)
+			}
+		} else if m.Hidden {
+			unhideMessage(ctx, m)
+		}
+	}
+	// Les signalements ouverts du message suivent la décision.
+	reportStatus := reportDismissed
+	if status == reviewRemoved {
+		reportStatus = reportActioned
+	}
+	if _, err := db.ReportsCol.UpdateMany(ctx,
+		bson.M{"target_key": "message:" + item.MessageID, "status": reportOpen},
+		bson.M{"$set": bson.M{"status": reportStatus, "resolved_by": me.ID, "resolved_at": now, "resolution": "review: " + req.Decision}},
+	); err != nil {
+		log.Printf("review reports %s: %v", item.MessageID, err)
+	}
+	item.Status, item.ReviewedBy, item.ReviewedAt, item.Note = status, me.ID, &now, req.Note
+	c.JSON(http.StatusOK, item)
+}
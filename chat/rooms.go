@@ -0,0 +1,342 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/auth"
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const dmPrefix = "dm:"
+
+var roomNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// reservedRooms ne peuvent pas être créés par un utilisateur (document posé par db.Init).
+var reservedRooms = map[string]bool{"general": true}
+
+// validRoomName: nom de salon acceptable (roomNameRe) ou conversation privée bien formée.
+func validRoomName(room string) bool {
+	if _, _, ok := dmParticipants(room); ok {
+		return true
+	}
+	return roomNameRe.MatchString(room)
+}
+
+// dmRoomName construit le nom de la conversation privée entre deux utilisateurs (ids triés).
+func dmRoomName(a, b string) string {
+	ids := []string{a, b}
+	sort.Strings(ids)
+	return dmPrefix + ids[0] + ":" + ids[1]
+}
+
+func isDMRoom(room string) bool { return strings.HasPrefix(room, dmPrefix) }
+
+// dmParticipants renvoie les deux ids d'une conversation privée.
+func dmParticipants(room string) (string, string, bool) {
+	a, b, ok := strings.Cut(strings.TrimPrefix(room, dmPrefix), ":")
+	return a, b, ok && isDMRoom(room)
+}
+
+func loadRoom(ctx context.Context, name string) (*models.Room, error) {
+	var r models.Room
+	if err := db.RoomsCol.FindOne(ctx, bson.M{"name": name}).Decode(&r); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Réglages lus à chaque message (mode lent, accès invités): document du salon
+// gardé roomCacheTTL en mémoire.
+const roomCacheTTL = 5 * time.Second
+
+type cachedRoomEntry struct {
+	room     *models.Room
+	loadedAt time.Time
+}
+
+var (
+	roomCacheMu sync.Mutex
+	roomCache   = map[string]cachedRoomEntry{}
+)
+
+// cachedRoom est loadRoom avec cache; nil si le salon n'a pas de document.
+func cachedRoom(ctx context.Context, name string) (*models.Room, error) {
+	roomCacheMu.Lock()
+	e, ok := roomCache[name]
+	roomCacheMu.Unlock()
+	if ok && time.Since(e.loadedAt) < roomCacheTTL {
+		return e.room, nil
+	}
+	r, err := loadRoom(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	roomCacheMu.Lock()
+	roomCache[name] = cachedRoomEntry{room: r, loadedAt: time.Now()}
+	roomCacheMu.Unlock()
+	return r, nil
+}
+
+func forgetRoom(name string) {
+	roomCacheMu.Lock()
+	delete(roomCache, name)
+	roomCacheMu.Unlock()
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// roomAudience renvoie le filtre des utilisateurs autorisés à lire le salon,
+// ou nil si le salon est ouvert à tous. Calculé une fois par diffusion, hors verrou du hub.
+func roomAudience(ctx context.Context, room string) (func(u WSUser) bool, error) {
+	allow, err := memberAudience(ctx, room)
+	if err != nil || isDMRoom(room) {
+		return allow, err
+	}
+	// Les bannis du salon sont exclus, qu'il soit public ou privé,
+	// ainsi que les invités si le salon leur est fermé.
+	s, err := sanctionsFor(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+	noGuests := guestAccess(ctx, room) == guestNone
+	if !noGuests && len(s.bannedUsers)+len(s.bannedIPs) == 0 {
+		return allow, nil
+	}
+	return func(u WSUser) bool {
+		return !(noGuests && !u.Authenticated) && !s.banned(u) && (allow == nil || allow(u))
+	}, nil
+}
+
+// memberAudience: filtre d'appartenance seul (participants du DM, membres du salon privé).
+func memberAudience(ctx context.Context, room string) (func(u WSUser) bool, error) {
+	if isDMRoom(room) {
+		a, b, ok := dmParticipants(room)
+		return func(u WSUser) bool {
+			return ok && u.Authenticated && (u.ID == a || u.ID == b)
+		}, nil
+	}
+	r, err := loadRoom(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil || !r.Private {
+		return nil, nil
+	}
+	members := make(map[string]bool, len(r.Members)+1)
+	for _, id := range r.Members {
+		members[id] = true
+	}
+	members[r.OwnerID] = true
+	return func(u WSUser) bool { return u.Authenticated && members[u.ID] }, nil
+}
+
+// canReadRoom: DM réservé aux deux participants, salon privé à ses membres.
+func canReadRoom(ctx context.Context, u WSUser, room string) bool {
+	allow, err := roomAudience(ctx, room)
+	if err != nil {
+		return false
+	}
+	return allow == nil || allow(u)
+}
+
+// hiddenRooms liste les salons que u ne peut pas lire sans les nommer: salons privés
+// dont il n'est pas membre, salons fermés aux invités (pour un invité) et salons
+// dont il est banni.
+func hiddenRooms(ctx context.Context, u WSUser) ([]string, error) {
+	filter := bson.M{"private": true}
+	if u.Authenticated && u.ID != "" {
+		filter["owner_id"] = bson.M{"$ne": u.ID}
+		filter["members"] = bson.M{"$ne": u.ID}
+	} else {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"guest_access": guestNone}}}
+	}
+	cur, err := db.RoomsCol.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var rooms []models.Room
+	if err := cur.All(ctx, &rooms); err != nil {
+		return nil, err
+	}
+	banned, err := bannedRooms(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rooms)+len(banned))
+	for _, r := range rooms {
+		names = append(names, r.Name)
+	}
+	return append(names, banned...), nil
+}
+
+// requestViewer identifie l'appelant d'une route REST (cookie ou Bearer), invité sinon.
+// Contrairement à extractUserFromRequest, pas d'accès base: les claims suffisent.
+func requestViewer(r *http.Request) WSUser {
+	token := ""
+	if ck, err := r.Cookie("access_token"); err == nil {
+		token = ck.Value
+	}
+	if token == "" {
+		if parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+			token = parts[1]
+		}
+	}
+	if token != "" {
+		if claims, err := auth.ValidateJWT(token); err == nil && claims.TokenType == "access" {
+			return WSUser{ID: claims.UserID, Username: claims.Username, Authenticated: true}
+		}
+	}
+	return WSUser{Username: "Invité", Guest: true}
+}
+
+// authViewer lit l'utilisateur posé par auth.AuthRequired.
+func authViewer(c *gin.Context) WSUser {
+	return WSUser{
+		ID:            c.GetString("userID"),
+		Username:      c.GetString("username"),
+		Authenticated: true,
+	}
+}
+
+// createRoomHandler: POST /api/rooms {name, private}
+func createRoomHandler(c *gin.Context) {
+	var req struct {
+		Name    string `json:"name"`
+		Private bool   `json:"private"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !roomNameRe.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room name"})
+		return
+	}
+	if reservedRooms[strings.ToLower(req.Name)] {
+		c.JSON(http.StatusConflict, gin.H{"error": "room name reserved"})
+		return
+	}
+	// Un salon public implicite (sans document) a pu recevoir des messages:
+	// le créer en privé en donnerait l'historique au nouveau propriétaire.
+	if err := db.MessagesCol.FindOne(c, bson.M{"room": req.Name}).Err(); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "room already exists"})
+		return
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	me := authViewer(c)
+	room := models.Room{
+		Name:      req.Name,
+		Private:   req.Private,
+		OwnerID:   me.ID,
+		Members:   []string{me.ID},
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	res, err := db.RoomsCol.InsertOne(c, room)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "room already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	room.ID, _ = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, room)
+}
+
+// listRoomsHandler: GET /api/rooms — salons publics et salons privés dont l'appelant est membre.
+func listRoomsHandler(c *gin.Context) {
+	me := authViewer(c)
+	filter := bson.M{"$or": bson.A{
+		bson.M{"private": false},
+		bson.M{"owner_id": me.ID},
+		bson.M{"members": me.ID},
+	}}
+	cur, err := db.RoomsCol.Find(c, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	rooms := []models.Room{}
+	if err := cur.All(c, &rooms); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, rooms)
+}
+
+// addMemberHandler: POST /api/rooms/:room/members {username} — réservé au propriétaire.
+func addMemberHandler(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
+		return
+	}
+	r, err := loadRoom(c, c.Param("room"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if r == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	if r.OwnerID != authViewer(c).ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var u models.User
+	if err := db.UsersCol.FindOne(c, bson.M{"username": req.Username}).Decode(&u); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if _, err := db.RoomsCol.UpdateOne(c, bson.M{"_id": r.ID}, bson.M{"$addToSet": bson.M{"members": u.ID.Hex()}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	emitWebhook(r.Name, hookJoin, memberEvent(WSUser{ID: u.ID.Hex(), Username: u.Username, Authenticated: true}, "invite"))
+	c.JSON(http.StatusOK, gin.H{"room": r.Name, "member": u.ID.Hex()})
+}
+
+// dmHandler: POST /api/dm {username} — renvoie le nom de la conversation privée avec cet utilisateur.
+func dmHandler(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
+		return
+	}
+	var u models.User
+	if err := db.UsersCol.FindOne(c, bson.M{"username": req.Username}).Decode(&u); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	me := authViewer(c)
+	if isBlocked(c, u.ID.Hex(), me.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "blocked"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": dmRoomName(me.ID, u.ID.Hex())})
+}
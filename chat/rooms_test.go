@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDMParticipants(t *testing.T) {
+	tests := []struct {
+		room string
+		a, b string
+		ok   bool
+	}{
+		{"dm:aaa:bbb", "aaa", "bbb", true},
+		{dmRoomName("zzz", "aaa"), "aaa", "zzz", true},
+		{"dm:aaa", "", "", false},
+		{"general", "", "", false},
+		{"xdm:aaa:bbb", "", "", false},
+	}
+	for _, tt := range tests {
+		a, b, ok := dmParticipants(tt.room)
+		if ok != tt.ok || (ok && (a != tt.a || b != tt.b)) {
+			t.Errorf("dmParticipants(%q) = %q, %q, %v; want %q, %q, %v", tt.room, a, b, ok, tt.a, tt.b, tt.ok)
+		}
+	}
+}
+
+func TestDMRoomNameIsSymmetric(t *testing.T) {
+	if x, y := dmRoomName("u1", "u2"), dmRoomName("u2", "u1"); x != y || x != "dm:u1:u2" {
+		t.Errorf("dmRoomName = %q / %q; want dm:u1:u2 dans les deux sens", x, y)
+	}
+}
+
+func TestValidRoomName(t *testing.T) {
+	for room, want := range map[string]bool{
+		"general":                  true,
+		"dev-ops_2":                true,
+		dmRoomName("alice", "bob"): true,
+		"dm:alice":                 false,
+		"":                         false,
+		"avec espace":              false,
+		"salon/sous":               false,
+		"été":                      false,
+		strings.Repeat("a", 64):    true,
+		strings.Repeat("a", 65):    false,
+	} {
+		if got := validRoomName(room); got != want {
+			t.Errorf("validRoomName(%q) = %v; want %v", room, got, want)
+		}
+	}
+}
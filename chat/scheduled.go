@@ -0,0 +1,544 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Messages programmés et rappels, stockés dans scheduled_jobs: ils survivent aux
+// redémarrages. Une échéance est prise sous bail (status "firing", lease_until) par
+// une seule instance; un bail expiré est repris, au plus maxScheduledAttempts fois.
+// Le message posté porte client_msg_id "sched:<id>" et le rappel a l'id du job:
+// une reprise après un envoi réussi retombe sur la déduplication, sans doublon.
+const (
+	jobMessage  = "message"
+	jobReminder = "reminder"
+
+	jobPending   = "pending"
+	jobFiring    = "firing"
+	jobDone      = "done"
+	jobCancelled = "cancelled"
+	jobFailed    = "failed"
+
+	scheduledLease       = time.Minute
+	scheduledPoll        = time.Second
+	maxScheduledAttempts = 3
+	maxScheduledPerUser  = 100
+	maxScheduledHorizon  = 365 * 24 * time.Hour
+	maxReminderNote      = 280
+)
+
+var scheduledOnce sync.Once
+
+func startScheduler() {
+	scheduledOnce.Do(func() {
+		go func() {
+			t := time.NewTicker(scheduledPoll)
+			defer t.Stop()
+			for range t.C {
+				for nextScheduled() {
+				}
+			}
+		}()
+	})
+}
+
+// nextScheduled déclenche une échéance arrivée à terme; false s'il n'y en a plus.
+func nextScheduled() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), scheduledLease)
+	defer cancel()
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var j models.ScheduledJob
+	err := db.ScheduledCol.FindOneAndUpdate(ctx,
+		bson.M{"$or": []bson.M{
+			{"status": jobPending, "due_at": bson.M{"$lte": now}},
+			{"status": jobFiring, "lease_until": bson.M{"$lt": now}},
+		}},
+		bson.M{
+			"$set": bson.M{"status": jobFiring, "lease_until": primitive.NewDateTimeFromTime(time.Now().Add(scheduledLease))},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "due_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&j)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("scheduled queue: %v", err)
+		}
+		return false
+	}
+	if j.Attempts > maxScheduledAttempts {
+		finishJob(ctx, j, "", errors.New("too many attempts"))
+		return true
+	}
+
+	var result string
+	switch j.Kind {
+	case jobMessage:
+		result, err = fireScheduledMessage(ctx, j)
+	case jobReminder:
+		result, err = fireReminder(ctx, j)
+	default:
+		err = errInvalid
+	}
+	if err != nil && !permanentJobError(err) {
+		log.Printf("scheduled %s: %v", j.ID.Hex(), err)
+		return true // nouvel essai à l'expiration du bail
+	}
+	finishJob(ctx, j, result, err)
+	return true
+}
+
+// permanentJobError: refus qui ne changera pas en réessayant.
+func permanentJobError(err error) bool {
+	return errors.Is(err, errNotFound) || errors.Is(err, errForbidden) || errors.Is(err, errInvalid) ||
+		errors.Is(err, errRejected) || errors.Is(err, errInvalidReply)
+}
+
+// finishJob clôt le job et prévient son propriétaire (scheduled.sent / scheduled.failed).
+func finishJob(ctx context.Context, j models.ScheduledJob, result string, jobErr error) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	set := bson.M{"status": jobDone, "fired_at": now, "updated_at": now}
+	evType := "scheduled.sent"
+	if jobErr != nil {
+		set["status"], set["error"] = jobFailed, errorCode(jobErr)
+		if !permanentJobError(jobErr) {
+			set["error"] = jobErr.Error()
+		}
+		evType = "scheduled.failed"
+	}
+	if result != "" {
+		set["result_id"] = result
+	}
+	var done models.ScheduledJob
+	err := db.ScheduledCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": j.ID, "status": jobFiring},
+		bson.M{"$set": set, "$unset": bson.M{"lease_until": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&done)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("scheduled finish %s: %v", j.ID.Hex(), err)
+		}
+		return
+	}
+	if done.Kind == jobReminder && jobErr == nil {
+		return // la notification elle-même fait office d'avis
+	}
+	wsHub.sendToUser(done.OwnerID, WSEvent{
+		Type:      evType,
+		Room:      done.Room,
+		MessageID: done.ResultID,
+		Data:      done,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// fireScheduledMessage poste le message avec les contrôles d'un envoi direct,
+// refaits à l'échéance: droits, sanctions, blocage et filtres ont pu changer.
+func fireScheduledMessage(ctx context.Context, j models.ScheduledJob) (string, error) {
+	owner, err := scheduledOwner(ctx, j.OwnerID)
+	if err != nil {
+		return "", err
+	}
+	clientMsgID := "sched:" + j.ID.Hex()
+	key := authorKey(owner.ID, owner.Username)
+	if existing, ok := findDuplicate(ctx, key, clientMsgID); ok {
+		return existing.ID.Hex(), nil // posté lors d'un essai précédent
+	}
+
+	if !canReadRoom(ctx, owner, j.Room) {
+		return "", errForbidden
+	}
+	if reason, _ := sanctionReason(ctx, owner, j.Room); reason != "" {
+		return "", errForbidden
+	}
+	if a, b, ok := dmParticipants(j.Room); ok {
+		other := a
+		if other == owner.ID {
+			other = b
+		}
+		if isBlocked(ctx, other, owner.ID) {
+			return "", errForbidden
+		}
+	}
+	if _, _, err := resolveThreading(ctx, j.Room, j.ReplyTo, j.ThreadRoot); err != nil {
+		return "", err
+	}
+	filtered := runFilters(ctx, FilterInput{
+		Text:      j.Text,
+		Room:      j.Room,
+		UserID:    owner.ID,
+		Username:  owner.Username,
+		AuthorKey: rateSubject(owner),
+	})
+	if filtered.Rejected {
+		return "", errRejected
+	}
+
+	persistAndDeliver(persistItem{
+		ID:          primitive.NewObjectID(),
+		UserID:      owner.ID,
+		Username:    owner.Username,
+		Room:        j.Room,
+		Text:        filtered.Text,
+		Timestamp:   time.Now().UTC(),
+		ClientMsgID: clientMsgID,
+		ReplyTo:     j.ReplyTo,
+		ThreadRoot:  j.ThreadRoot,
+		Flags:       filtered.Flags,
+	})
+	existing, ok := findDuplicate(ctx, key, clientMsgID)
+	if !ok {
+		return "", errors.New("message not stored")
+	}
+	return existing.ID.Hex(), nil
+}
+
+// fireReminder dépose la notification de rappel; son id est celui du job.
+func fireReminder(ctx context.Context, j models.ScheduledJob) (string, error) {
+	owner, err := scheduledOwner(ctx, j.OwnerID)
+	if err != nil {
+		return "", err
+	}
+	m, err := loadMessage(ctx, j.MessageID)
+	if err != nil {
+		return "", err
+	}
+	if m.Deleted || !canReadRoom(ctx, owner, m.Room) {
+		return "", errNotFound
+	}
+	n := models.Notification{
+		ID:        j.ID,
+		UserID:    owner.ID,
+		Type:      "reminder",
+		MessageID: m.ID.Hex(),
+		Room:      m.Room,
+		From:      m.Sender,
+		Note:      j.Note,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if !m.Hidden {
+		n.Excerpt = excerpt(m.Content, quoteExcerptLen)
+	}
+	if _, err := db.NotificationsCol.InsertOne(ctx, n); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return n.ID.Hex(), nil // déjà déposée lors d'un essai précédent
+		}
+		return "", err
+	}
+	wsHub.sendToUser(n.UserID, WSEvent{
+		Type:      "notification",
+		Room:      n.Room,
+		MessageID: n.MessageID,
+		Data:      n,
+		Timestamp: time.Now().UTC(),
+	})
+	return n.ID.Hex(), nil
+}
+
+// scheduledOwner relit le compte: le pseudo affiché est celui du moment de l'envoi.
+func scheduledOwner(ctx context.Context, id string) (WSUser, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return WSUser{}, errNotFound
+	}
+	var u models.User
+	if err := db.UsersCol.FindOne(ctx, bson.M{"_id": oid}).Decode(&u); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return WSUser{}, errNotFound
+		}
+		return WSUser{}, err
+	}
+	return WSUser{ID: id, Username: u.Username, Authenticated: true}, nil
+}
+
+// dueTime lit l'échéance: "at" (RFC 3339) ou "in" (durée Go, ou "<n>d" en jours).
+func dueTime(at, in string) (time.Time, error) {
+	var due time.Time
+	switch {
+	case at != "" && in != "":
+		return due, errInvalid
+	case at != "":
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return due, errInvalid
+		}
+		due = t
+	case in != "":
+		var d time.Duration
+		if days, ok := strings.CutSuffix(in, "d"); ok {
+			n, err := strconv.Atoi(days)
+			if err != nil {
+				return due, errInvalid
+			}
+			d = time.Duration(n) * 24 * time.Hour
+		} else {
+			var err error
+			if d, err = time.ParseDuration(in); err != nil {
+				return due, errInvalid
+			}
+		}
+		due = time.Now().Add(d)
+	default:
+		return due, errInvalid
+	}
+	if now := time.Now(); !due.After(now) || due.After(now.Add(maxScheduledHorizon)) {
+		return due, errInvalid
+	}
+	return due.UTC(), nil
+}
+
+// createJob enregistre le job, dans la limite de maxScheduledPerUser en attente.
+func createJob(ctx context.Context, j models.ScheduledJob) (models.ScheduledJob, error) {
+	n, err := db.ScheduledCol.CountDocuments(ctx, bson.M{"owner_id": j.OwnerID, "status": jobPending})
+	if err != nil {
+		return j, err
+	}
+	if n >= maxScheduledPerUser {
+		return j, errLimit
+	}
+	j.ID = primitive.NewObjectID()
+	j.Status = jobPending
+	j.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	if _, err := db.ScheduledCol.InsertOne(ctx, j); err != nil {
+		return j, err
+	}
+	return j, nil
+}
+
+// createScheduledHandler: POST /api/scheduled {room, text, at|in, reply_to, thread_root}
+func createScheduledHandler(c *gin.Context) {
+	var req struct {
+		Room       string `json:"room"`
+		Text       string `json:"text"`
+		At         string `json:"at"`
+		In         string `json:"in"`
+		ReplyTo    string `json:"reply_to"`
+		ThreadRoot string `json:"thread_root"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Text) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text required"})
+		return
+	}
+	if req.Room == "" {
+		req.Room = "general"
+	}
+	if !isDMRoom(req.Room) && !roomNameRe.MatchString(req.Room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room"})
+		return
+	}
+	due, err := dueTime(req.At, req.In)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid due time"})
+		return
+	}
+	me := authViewer(c)
+	if !canReadRoom(c, me, req.Room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if _, _, err := resolveThreading(c, req.Room, req.ReplyTo, req.ThreadRoot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	j, err := createJob(c, models.ScheduledJob{
+		Kind:       jobMessage,
+		OwnerID:    me.ID,
+		Room:       req.Room,
+		Text:       req.Text,
+		ReplyTo:    req.ReplyTo,
+		ThreadRoot: req.ThreadRoot,
+		DueAt:      primitive.NewDateTimeFromTime(due),
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusCreated, j)
+}
+
+// remindHandler: POST /api/messages/:id/remind {at|in, note}
+func remindHandler(c *gin.Context) {
+	var req struct {
+		At   string `json:"at"`
+		In   string `json:"in"`
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Note) > maxReminderNote {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	due, err := dueTime(req.At, req.In)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid due time"})
+		return
+	}
+	me := authViewer(c)
+	m, err := loadMessage(c, c.Param("id"))
+	if err != nil || m.Deleted || !canReadRoom(c, me, m.Room) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	j, err := createJob(c, models.ScheduledJob{
+		Kind:      jobReminder,
+		OwnerID:   me.ID,
+		Room:      m.Room,
+		MessageID: m.ID.Hex(),
+		Note:      req.Note,
+		DueAt:     primitive.NewDateTimeFromTime(due),
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	c.JSON(http.StatusCreated, j)
+}
+
+// listScheduledHandler: GET /api/scheduled?kind=message|reminder&status=pending|done|...|all
+// Par défaut, les jobs en attente, du plus proche au plus lointain.
+func listScheduledHandler(c *gin.Context) {
+	me := authViewer(c)
+	filter := bson.M{"owner_id": me.ID}
+	switch status := c.Query("status"); status {
+	case "":
+		filter["status"] = jobPending
+	case "all":
+	default:
+		filter["status"] = status
+	}
+	if kind := c.Query("kind"); kind != "" {
+		filter["kind"] = kind
+	}
+	limit := 50
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "due_at", Value: 1}}).SetLimit(int64(limit))
+	cur, err := db.ScheduledCol.Find(c, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	jobs := []models.ScheduledJob{}
+	if err := cur.All(c, &jobs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"scheduled": jobs})
+}
+
+// updateScheduledHandler: PATCH /api/scheduled/:id {text, note, at|in}
+// Seul un job encore en attente se modifie; pris par le worker, il est trop tard (409).
+func updateScheduledHandler(c *gin.Context) {
+	var req struct {
+		Text *string `json:"text"`
+		Note *string `json:"note"`
+		At   string  `json:"at"`
+		In   string  `json:"in"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if req.Text != nil && req.Note != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text or note, not both"})
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	set := bson.M{"updated_at": now}
+	filter := bson.M{"_id": oid, "owner_id": authViewer(c).ID, "status": jobPending}
+	if req.Text != nil {
+		if strings.TrimSpace(*req.Text) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "text required"})
+			return
+		}
+		set["text"], filter["kind"] = *req.Text, jobMessage
+	}
+	if req.Note != nil {
+		if len(*req.Note) > maxReminderNote {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "note too long"})
+			return
+		}
+		set["note"], filter["kind"] = *req.Note, jobReminder
+	}
+	if req.At != "" || req.In != "" {
+		due, err := dueTime(req.At, req.In)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid due time"})
+			return
+		}
+		set["due_at"] = primitive.NewDateTimeFromTime(due)
+	}
+
+	var j models.ScheduledJob
+	err = db.ScheduledCol.FindOneAndUpdate(c, filter, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&j)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			scheduledMiss(c, oid)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
+
+// cancelScheduledHandler: DELETE /api/scheduled/:id
+func cancelScheduledHandler(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	res, err := db.ScheduledCol.UpdateOne(c,
+		bson.M{"_id": oid, "owner_id": authViewer(c).ID, "status": jobPending},
+		bson.M{"$set": bson.M{"status": jobCancelled, "updated_at": now}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		scheduledMiss(c, oid)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// scheduledMiss distingue un job inconnu (404) d'un job déjà parti ou annulé (409).
+func scheduledMiss(c *gin.Context, oid primitive.ObjectID) {
+	var j models.ScheduledJob
+	if err := db.ScheduledCol.FindOne(c, bson.M{"_id": oid, "owner_id": authViewer(c).ID}).Decode(&j); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "scheduled job not found"})
+		return
+	}
+	if j.Status == jobPending {
+		// Job en attente mais d'un autre type: text pour un message, note pour un rappel.
+		c.JSON(http.StatusBadRequest, gin.H{"error": "field not applicable to " + j.Kind})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "job is " + j.Status, "status": j.Status})
+}
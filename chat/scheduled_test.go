@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDueTime(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		at, in  string
+		want    time.Duration // échéance attendue depuis maintenant
+		wantErr bool
+	}{
+		{name: "durée", in: "90m", want: 90 * time.Minute},
+		{name: "jours", in: "2d", want: 48 * time.Hour},
+		{name: "date", at: now.Add(time.Hour).Format(time.RFC3339), want: time.Hour},
+		{name: "les deux", at: now.Add(time.Hour).Format(time.RFC3339), in: "1h", wantErr: true},
+		{name: "aucune", wantErr: true},
+		{name: "passée", at: now.Add(-time.Hour).Format(time.RFC3339), wantErr: true},
+		{name: "négative", in: "-5m", wantErr: true},
+		{name: "nulle", in: "0s", wantErr: true},
+		{name: "au-delà d'un an", in: "400d", wantErr: true},
+		{name: "jours invalides", in: "xd", wantErr: true},
+		{name: "durée invalide", in: "demain", wantErr: true},
+		{name: "date invalide", at: "2026-13-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due, err := dueTime(tt.at, tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalid) {
+					t.Fatalf("dueTime(%q, %q) err = %v; want errInvalid", tt.at, tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dueTime(%q, %q) err = %v", tt.at, tt.in, err)
+			}
+			if due.Location() != time.UTC {
+				t.Errorf("échéance %v hors UTC", due)
+			}
+			// RFC 3339 tronque à la seconde.
+			if d := due.Sub(now.Add(tt.want)); d < -2*time.Second || d > 2*time.Second {
+				t.Errorf("dueTime(%q, %q) = %v; want ~%v", tt.at, tt.in, due, now.Add(tt.want).UTC())
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package chat
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+)
+
+// SearchQuery décrit une recherche plein texte déjà filtrée par les droits de l'appelant.
+type SearchQuery struct {
+	Text   string
+	Room   string    // facultatif: restreint à un salon
+	Author string    // facultatif: username de l'expéditeur
+	From   time.Time // bornes facultatives sur created_at
+	To     time.Time
+	Limit  int
+	Offset int
+
+	// Droits: salons illisibles pour l'appelant (privés, fermés aux invités,
+	// bannis) et son id (les DM ne remontent que s'il y participe; aucun DM
+	// pour un invité).
+	HiddenRooms []string
+	ViewerID    string
+}
+
+// SearchHit est un message trouvé, avec son score de pertinence.
+type SearchHit struct {
+	Message models.Message
+	Score   float64
+}
+
+// SearchBackend est le moteur de recherche des messages.
+// Index/Remove sont appelés à l'écriture pour les moteurs externes.
+type SearchBackend interface {
+	Index(ctx context.Context, m models.Message) error
+	Remove(ctx context.Context, id string) error
+	Search(ctx context.Context, q SearchQuery) (hits []SearchHit, total int64, err error)
+}
+
+var (
+	searchMu      sync.RWMutex
+	searchBackend SearchBackend = mongoSearch{}
+)
+
+// SetSearchBackend remplace le moteur de recherche (moteur externe, ou mémoire en test).
+func SetSearchBackend(b SearchBackend) {
+	searchMu.Lock()
+	searchBackend = b
+	searchMu.Unlock()
+}
+
+func currentSearch() SearchBackend {
+	searchMu.RLock()
+	defer searchMu.RUnlock()
+	return searchBackend
+}
+
+// visibleTo applique les droits d'une requête à un message (utilisé hors Mongo).
+func (q SearchQuery) visibleTo(m models.Message) bool {
+	if m.Deleted || m.Hidden || contains(q.HiddenRooms, m.Room) {
+		return false
+	}
+	if isDMRoom(m.Room) {
+		a, b, ok := dmParticipants(m.Room)
+		return ok && q.ViewerID != "" && (q.ViewerID == a || q.ViewerID == b)
+	}
+	return true
+}
+
+// searchTerms découpe la requête en termes en minuscules.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+const snippetRadius = 60
+
+// highlight renvoie un extrait autour de la première occurrence d'un terme,
+// échappé en HTML, chaque occurrence entourée de <mark>.
+func highlight(content string, terms []string) string {
+	runes := []rune(content)
+	lower := []rune(strings.ToLower(content))
+	if len(lower) != len(runes) {
+		lower = runes // casse non réversible: on surligne sans normaliser
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	for i := 0; i < len(lower); {
+		matched := 0
+		for _, t := range terms {
+			tr := []rune(t)
+			if len(tr) > matched && i+len(tr) <= len(lower) && string(lower[i:i+len(tr)]) == t {
+				matched = len(tr)
+			}
+		}
+		if matched > 0 {
+			spans = append(spans, span{i, i + matched})
+			i += matched
+			continue
+		}
+		i++
+	}
+
+	from, to := 0, len(runes)
+	if len(spans) > 0 {
+		from = max(0, spans[0].start-snippetRadius)
+		to = min(len(runes), spans[0].end+snippetRadius)
+	} else if to > 2*snippetRadius {
+		to = 2 * snippetRadius
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		if s.start < from || s.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:s.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[s.start:s.end])))
+		b.WriteString("</mark>")
+		pos = s.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func parseSearchDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// searchHandler: GET /api/search/messages?q=&room=&author=&from=&to=&limit=&offset=
+func searchHandler(c *gin.Context) {
+	q := SearchQuery{
+		Text:   strings.TrimSpace(c.Query("q")),
+		Room:   c.Query("room"),
+		Author: c.Query("author"),
+		Limit:  20,
+	}
+	if q.Text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q required"})
+		return
+	}
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 100 {
+		q.Limit = n
+	}
+	if n, err := strconv.Atoi(c.Query("offset")); err == nil && n >= 0 {
+		q.Offset = n
+	}
+	var ok1, ok2 bool
+	q.From, ok1 = parseSearchDate(c.Query("from"))
+	q.To, ok2 = parseSearchDate(c.Query("to"))
+	if !ok1 || !ok2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	viewer := requestViewer(c.Request)
+	// Invités refusés partout: aucun salon à chercher.
+	if !viewer.Authenticated && guestMode() == guestDisabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if q.Room != "" && !canReadRoom(ctx, viewer, q.Room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	hidden, err := hiddenRooms(ctx, viewer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	q.HiddenRooms = hidden
+	if viewer.Authenticated {
+		q.ViewerID = viewer.ID
+	}
+
+	hits, total, err := currentSearch().Search(ctx, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "search error"})
+		return
+	}
+
+	terms := searchTerms(q.Text)
+	out := make([]gin.H, 0, len(hits))
+	for _, h := range hits {
+		item := historyItem(h.Message)
+		item["snippet"] = highlight(h.Message.Content, terms)
+		item["score"] = h.Score
+		out = append(out, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results": out,
+		"total":   total,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+	})
+}
@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+)
+
+// MemorySearch est un moteur de recherche en mémoire (tests, développement local).
+// Un message correspond s'il contient au moins un terme; le score compte les occurrences.
+type MemorySearch struct {
+	mu   sync.RWMutex
+	msgs map[string]models.Message
+}
+
+func NewMemorySearch() *MemorySearch {
+	return &MemorySearch{msgs: make(map[string]models.Message)}
+}
+
+func (s *MemorySearch) Index(_ context.Context, m models.Message) error {
+	s.mu.Lock()
+	s.msgs[m.ID.Hex()] = m
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemorySearch) Remove(_ context.Context, id string) error {
+	s.mu.Lock()
+	delete(s.msgs, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemorySearch) Search(_ context.Context, q SearchQuery) ([]SearchHit, int64, error) {
+	terms := searchTerms(q.Text)
+
+	s.mu.RLock()
+	var hits []SearchHit
+	for _, m := range s.msgs {
+		if q.Room != "" && m.Room != q.Room {
+			continue
+		}
+		if q.Author != "" && m.Sender != q.Author {
+			continue
+		}
+		at := m.CreatedAt.Time()
+		if (!q.From.IsZero() && at.Before(q.From)) || (!q.To.IsZero() && at.After(q.To)) {
+			continue
+		}
+		if !q.visibleTo(m) {
+			continue
+		}
+		content := strings.ToLower(m.Content)
+		score := 0
+		for _, t := range terms {
+			score += strings.Count(content, t)
+		}
+		if score > 0 {
+			hits = append(hits, SearchHit{Message: m, Score: float64(score)})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Message.CreatedAt > hits[j].Message.CreatedAt
+	})
+	total := int64(len(hits))
+	if q.Offset >= len(hits) {
+		return nil, total, nil
+	}
+	hits = hits[q.Offset:]
+	if len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, total, nil
+}
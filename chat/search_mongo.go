@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoSearch s'appuie sur l'index texte "content_text" créé par db.Init:
+// l'indexation est faite par Mongo, Index/Remove n'ont rien à faire.
+type mongoSearch struct{}
+
+func (mongoSearch) Index(context.Context, models.Message) error { return nil }
+func (mongoSearch) Remove(context.Context, string) error        { return nil }
+
+func (mongoSearch) Search(ctx context.Context, q SearchQuery) ([]SearchHit, int64, error) {
+	filter := bson.M{"$text": bson.M{"$search": q.Text}, "deleted": bson.M{"$ne": true}, "hidden": bson.M{"$ne": true}}
+
+	hidden := q.HiddenRooms
+	if hidden == nil {
+		hidden = []string{}
+	}
+	and := bson.A{bson.M{"room": bson.M{"$nin": hidden}}}
+	if q.Room != "" {
+		and = append(and, bson.M{"room": q.Room})
+	}
+	// DM: uniquement ceux où l'appelant est l'un des deux participants.
+	dm := bson.M{"room": bson.M{"$not": primitive.Regex{Pattern: "^" + dmPrefix}}}
+	if q.ViewerID != "" {
+		id := regexp.QuoteMeta(q.ViewerID)
+		and = append(and, bson.M{"$or": bson.A{dm, bson.M{"room": primitive.Regex{
+			Pattern: "^" + dmPrefix + "(" + id + ":[^:]+|[^:]+:" + id + ")$",
+		}}}})
+	} else {
+		and = append(and, dm)
+	}
+	filter["$and"] = and
+
+	if q.Author != "" {
+		filter["sender"] = q.Author
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		rng := bson.M{}
+		if !q.From.IsZero() {
+			rng["$gte"] = primitive.NewDateTimeFromTime(q.From)
+		}
+		if !q.To.IsZero() {
+			rng["$lte"] = primitive.NewDateTimeFromTime(q.To)
+		}
+		filter["created_at"] = rng
+	}
+
+	total, err := db.MessagesCol.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "created_at", Value: -1}}).
+		SetSkip(int64(q.Offset)).
+		SetLimit(int64(q.Limit))
+	cur, err := db.MessagesCol.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(ctx)
+
+	var hits []SearchHit
+	for cur.Next(ctx) {
+		var doc struct {
+			models.Message `bson:",inline"`
+			Score          float64 `bson:"score"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		hits = append(hits, SearchHit{Message: doc.Message, Score: doc.Score})
+	}
+	return hits, total, cur.Err()
+}
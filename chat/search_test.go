@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHighlight(t *testing.T) {
+	long := "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. "
+	tests := []struct {
+		name    string
+		content string
+		terms   []string
+		want    string
+	}{
+		{"simple", "Bonjour le monde", []string{"monde"}, "Bonjour le <mark>monde</mark>"},
+		{"casse", "Le Chat dort", []string{"chat"}, "Le <mark>Chat</mark> dort"},
+		{"plusieurs", "chat et chien, chat", []string{"chat", "chien"}, "<mark>chat</mark> et <mark>chien</mark>, <mark>chat</mark>"},
+		{"terme le plus long", "chatons", []string{"chat", "chaton"}, "<mark>chaton</mark>s"},
+		{"échappement", "<b>chat</b> & co", []string{"chat"}, "&lt;b&gt;<mark>chat</mark>&lt;/b&gt; &amp; co"},
+		{"accents", "Élève émérite", []string{"élève"}, "<mark>Élève</mark> émérite"},
+		{"aucun", "rien à voir", []string{"chat"}, "rien à voir"},
+		{"aucun, tronqué", long + long, []string{"chat"}, string([]rune(long + long)[:2*snippetRadius]) + "…"},
+		{"extrait centré", long + "chat " + long, []string{"chat"},
+			"…" + string([]rune(long)[len([]rune(long))-snippetRadius:]) + "<mark>chat</mark>" + string([]rune(" " + long)[:snippetRadius]) + "…"},
+	}
+	for _, tt := range tests {
+		if got := highlight(tt.content, tt.terms); got != tt.want {
+			t.Errorf("highlight(%s) =\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchQueryVisibleTo(t *testing.T) {
+	dm := dmRoomName("alice", "bob")
+	tests := []struct {
+		name string
+		q    SearchQuery
+		room string
+		want bool
+	}{
+		{"salon public", SearchQuery{}, "general", true},
+		{"salon privé masqué", SearchQuery{HiddenRooms: []string{"secret"}}, "secret", false},
+		{"autre salon privé", SearchQuery{HiddenRooms: []string{"secret"}}, "general", true},
+		{"DM participant", SearchQuery{ViewerID: "alice"}, dm, true},
+		{"DM autre participant", SearchQuery{ViewerID: "bob"}, dm, true},
+		{"DM tiers", SearchQuery{ViewerID: "carol"}, dm, false},
+		{"DM invité", SearchQuery{}, dm, false},
+		{"DM mal formé", SearchQuery{ViewerID: "alice"}, "dm:alice", false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.visibleTo(models.Message{Room: tt.room}); got != tt.want {
+			t.Errorf("visibleTo(%s) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+	// Masqués par signalement ou supprimés: exclus partout.
+	for name, m := range map[string]models.Message{
+		"masqué":    {Room: "general", Hidden: true},
+		"supprimé":  {Room: "general", Deleted: true},
+		"DM masqué": {Room: dm, Hidden: true},
+	} {
+		if (SearchQuery{ViewerID: "alice"}).visibleTo(m) {
+			t.Errorf("visibleTo(%s) = true; want false", name)
+		}
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemorySearch()
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := func(room, sender, content string, minutes int) models.Message {
+		return models.Message{
+			ID:        primitive.NewObjectID(),
+			Room:      room,
+			Sender:    sender,
+			Content:   content,
+			CreatedAt: primitive.NewDateTimeFromTime(base.Add(time.Duration(minutes) * time.Minute)),
+		}
+	}
+	once := msg("general", "alice", "un chat", 1)
+	twice := msg("general", "bob", "chat, encore un chat", 2)
+	later := msg("general", "alice", "le chat revient", 3)
+	private := msg("secret", "bob", "chat privé", 4)
+	direct := msg(dmRoomName("alice", "bob"), "bob", "chat en DM", 5)
+	other := msg("general", "carol", "rien à voir", 6)
+	hidden := msg("general", "dave", "chat chat chat signalé", 7)
+	hidden.Hidden = true
+	deleted := msg("general", "dave", "chat chat chat effacé", 8)
+	deleted.Deleted = true
+	for _, m := range []models.Message{once, twice, later, private, direct, other, hidden, deleted} {
+		_ = s.Index(ctx, m)
+	}
+
+	ids := func(hits []SearchHit) []string {
+		out := make([]string, len(hits))
+		for i, h := range hits {
+			out[i] = h.Message.ID.Hex()
+		}
+		return out
+	}
+	tests := []struct {
+		name  string
+		q     SearchQuery
+		want  []models.Message
+		total int64
+	}{
+		{"score puis récence", SearchQuery{Text: "chat", Limit: 10, HiddenRooms: []string{"secret"}},
+			[]models.Message{twice, later, once}, 3},
+		{"DM du participant", SearchQuery{Text: "chat", Limit: 10, ViewerID: "alice"},
+			[]models.Message{twice, direct, private, later, once}, 5},
+		{"salon", SearchQuery{Text: "chat", Room: "secret", Limit: 10}, []models.Message{private}, 1},
+		{"auteur", SearchQuery{Text: "chat", Author: "alice", Limit: 10}, []models.Message{later, once}, 2},
+		{"période", SearchQuery{Text: "chat", From: base.Add(90 * time.Second), To: base.Add(150 * time.Second), Limit: 10},
+			[]models.Message{twice}, 1},
+		{"pagination", SearchQuery{Text: "chat", Limit: 1, Offset: 1, HiddenRooms: []string{"secret"}},
+			[]models.Message{later}, 3},
+		{"au-delà", SearchQuery{Text: "chat", Limit: 10, Offset: 10}, nil, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, total, err := s.Search(ctx, tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, want := ids(hits), ids(nil)
+			for _, m := range tt.want {
+				want = append(want, m.ID.Hex())
+			}
+			if total != tt.total || len(got) != len(want) {
+				t.Fatalf("Search = %v (total %d); want %v (total %d)", got, total, want, tt.total)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("Search = %v; want %v", got, want)
+				}
+			}
+		})
+	}
+
+	_ = s.Remove(ctx, twice.ID.Hex())
+	if _, total, _ := s.Search(ctx, SearchQuery{Text: "chat", Limit: 10, HiddenRooms: []string{"secret"}}); total != 2 {
+		t.Errorf("après Remove, total = %d; want 2", total)
+	}
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Longueur maximale (en runes) de l'extrait conservé pour une citation.
+const quoteExcerptLen = 140
+
+var errInvalidReply = errors.New("invalid_reply")
+
+func excerpt(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
+
+// resolveThreading valide reply_to/thread_root d'un nouveau message du salon.
+// Citer un message d'un fil range la réponse dans ce fil; une racine désignée
+// par une réponse est ramenée à la vraie racine.
+func resolveThreading(ctx context.Context, room, replyTo, threadRoot string) (*models.Quote, string, error) {
+	var quote *models.Quote
+	if replyTo != "" {
+		m, err := loadMessage(ctx, replyTo)
+		if err != nil || m.Room != room || m.Deleted {
+			return nil, "", errInvalidReply
+		}
+		quote = &models.Quote{ID: m.ID.Hex(), Sender: m.Sender}
+		if !m.Hidden { // masqué en attente de revue: pas d'extrait
+			quote.Excerpt = excerpt(m.Content, quoteExcerptLen)
+		}
+		if threadRoot == "" {
+			threadRoot = m.ThreadRoot
+		}
+	}
+	if threadRoot != "" {
+		root, err := loadMessage(ctx, threadRoot)
+		if err != nil || root.Room != room || root.Deleted {
+			return nil, "", errInvalidReply
+		}
+		if root.ThreadRoot != "" {
+			threadRoot = root.ThreadRoot
+		}
+	}
+	return quote, threadRoot, nil
+}
+
+// setQuoteExcerpts réécrit l'extrait cité par les réponses à id: vidé quand le
+// message est supprimé ou masqué, rétabli quand il est approuvé.
+func setQuoteExcerpts(ctx context.Context, id, text string) {
+	if _, err := db.MessagesCol.UpdateMany(ctx,
+		bson.M{"reply_to": id, "quote": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"quote.excerpt": text}},
+	); err != nil {
+		log.Printf("quote excerpts %s: %v", id, err)
+	}
+}
+
+// bumpThread met à jour les compteurs de la racine et les diffuse au salon.
+func bumpThread(ctx context.Context, reply models.Message) {
+	oid, err := primitive.ObjectIDFromHex(reply.ThreadRoot)
+	if err != nil {
+		return
+	}
+	var root models.Message
+	err = db.MessagesCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": oid},
+		bson.M{"$inc": bson.M{"reply_count": 1}, "$max": bson.M{"last_reply_at": reply.CreatedAt}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&root)
+	if err != nil {
+		log.Printf("thread update %s: %v", reply.ThreadRoot, err)
+		return
+	}
+	data := gin.H{
+		"reply_count":   root.ReplyCount,
+		"last_reply_id": reply.ID.Hex(),
+		"last_reply_by": reply.Sender,
+	}
+	if root.LastReplyAt != nil {
+		data["last_reply_at"] = root.LastReplyAt.Time().UTC()
+	}
+	publish(ctx, WSEvent{
+		Type:      "thread.updated",
+		Room:      root.Room,
+		MessageID: root.ID.Hex(),
+		Data:      data,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// unbumpThread décompte une réponse supprimée et diffuse le nouveau compteur.
+// last_reply_at reste celui de la dernière réponse publiée.
+func unbumpThread(ctx context.Context, reply models.Message) {
+	oid, err := primitive.ObjectIDFromHex(reply.ThreadRoot)
+	if err != nil {
+		return
+	}
+	var root models.Message
+	err = db.MessagesCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": oid, "reply_count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"reply_count": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&root)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("thread update %s: %v", reply.ThreadRoot, err)
+		}
+		return
+	}
+	publish(ctx, WSEvent{
+		Type:      "thread.updated",
+		Room:      root.Room,
+		MessageID: root.ID.Hex(),
+		Data:      gin.H{"reply_count": root.ReplyCount},
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// threadHandler: GET /api/messages/:id/thread?limit=&after= — racine puis réponses (chronologique).
+func threadHandler(c *gin.Context) {
+	limit := defaultHistoryLimit
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= maxHistoryLimit {
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	root, err := loadMessage(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": errorCode(err)})
+		return
+	}
+	if root.ThreadRoot != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a thread root", "thread_root": root.ThreadRoot})
+		return
+	}
+	viewer := requestViewer(c.Request)
+	if !canReadRoom(ctx, viewer, root.Room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	base := bson.M{"thread_root": root.ID.Hex()}
+	if !excludeBlocked(ctx, base, viewer) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	filter := base
+	if after := c.Query("after"); after != "" {
+		hc, err := parseHistoryCursor(ctx, root.Room, after)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter = pageFilter(base, hc, false, false)
+	}
+	replies, more, err := fetchPage(ctx, filter, false, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	out := make([]gin.H, 0, len(replies))
+	for _, m := range replies {
+		out = append(out, historyItem(m))
+	}
+	var next interface{}
+	if more && len(replies) > 0 {
+		next = cursorOf(replies[len(replies)-1]).String()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"root":    historyItem(root),
+		"replies": out,
+		"next":    next,
+	})
+}
@@ -0,0 +1,22 @@
+package chat
+
+import "testing"
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"court", 10, "court"},
+		{"pile dix!!", 10, "pile dix!!"},
+		{"un message un peu long", 10, "un message…"},
+		{"éàüçôéàüçôé", 10, "éàüçôéàüçô…"},
+		{"", 5, ""},
+	}
+	for _, tt := range tests {
+		if got := excerpt(tt.s, tt.n); got != tt.want {
+			t.Errorf("excerpt(%q, %d) = %q; want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
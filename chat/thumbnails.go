@@ -0,0 +1,284 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Aperçus des images. Le téléversement marque l'image "pending"; le worker prend
+// les images une à une sous bail (preview_lease), ce qui vaut entre instances et
+// après un redémarrage: un bail expiré est repris, au plus maxPreviewAttempts fois.
+const (
+	previewPending = "pending"
+	previewReady   = "ready"
+	previewFailed  = "failed"
+
+	previewLease       = 2 * time.Minute
+	previewSweep       = time.Minute
+	maxPreviewAttempts = 3
+)
+
+// thumbSizes: plus grand côté des vignettes; seules celles plus petites que l'original sont produites.
+var thumbSizes = []int{1024, 480, 160}
+
+var (
+	thumbWake = make(chan struct{}, 1)
+	thumbOnce sync.Once
+)
+
+func startThumbnailer() {
+	thumbOnce.Do(func() {
+		go func() {
+			t := time.NewTicker(previewSweep)
+			defer t.Stop()
+			for {
+				for nextPreview() {
+				}
+				select {
+				case <-thumbWake:
+				case <-t.C:
+				}
+			}
+		}()
+	})
+}
+
+// wakeThumbnailer réveille le worker sans attendre le prochain passage.
+func wakeThumbnailer() {
+	select {
+	case thumbWake <- struct{}{}:
+	default:
+	}
+}
+
+func thumbnailURL(id string, size int) string {
+	return attachmentURL(id) + "/thumbnails/" + strconv.Itoa(size)
+}
+
+// nextPreview traite une image en attente; false s'il n'y en a plus.
+func nextPreview() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), previewLease)
+	defer cancel()
+
+	now := time.Now()
+	var a models.Attachment
+	err := db.AttachmentsCol.FindOneAndUpdate(ctx,
+		bson.M{
+			"preview_status": previewPending,
+			"detached":       bson.M{"$ne": true},
+			"$or": []bson.M{
+				{"preview_lease": bson.M{"$exists": false}},
+				{"preview_lease": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{"preview_lease": primitive.NewDateTimeFromTime(now.Add(previewLease))},
+			"$inc": bson.M{"preview_attempts": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&a)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("preview queue: %v", err)
+		}
+		return false
+	}
+	if a.PreviewAttempts > maxPreviewAttempts {
+		setPreviewFailed(ctx, a)
+		return true
+	}
+
+	thumbs, placeholder, err := buildPreview(ctx, a)
+	if err != nil {
+		log.Printf("preview %s: %v", a.ID.Hex(), err)
+		if errors.Is(err, errBadImage) || errors.Is(err, errImageTooLarge) {
+			setPreviewFailed(ctx, a)
+		}
+		return true // sinon nouvel essai à l'expiration du bail
+	}
+
+	var done models.Attachment
+	err = db.AttachmentsCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": a.ID},
+		bson.M{
+			"$set":   bson.M{"preview_status": previewReady, "placeholder": placeholder, "thumbnails": thumbs},
+			"$unset": bson.M{"preview_lease": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&done)
+	if err != nil {
+		// Fichier supprimé pendant le calcul: les vignettes n'ont plus de propriétaire.
+		for _, t := range thumbs {
+			_ = currentBlobStore().Delete(ctx, t.Key)
+		}
+		return true
+	}
+	// Lu après l'écriture de l'aperçu: un message inséré plus tard le reprend à l'insertion (syncPreviews).
+	if done.MessageID != "" {
+		applyPreview(ctx, done)
+	}
+	return true
+}
+
+func setPreviewFailed(ctx context.Context, a models.Attachment) {
+	if _, err := db.AttachmentsCol.UpdateOne(ctx,
+		bson.M{"_id": a.ID},
+		bson.M{"$set": bson.M{"preview_status": previewFailed}, "$unset": bson.M{"preview_lease": ""}},
+	); err != nil {
+		log.Printf("preview failed %s: %v", a.ID.Hex(), err)
+	}
+}
+
+// buildPreview décode l'image, stocke ses vignettes et calcule son empreinte.
+// Les vignettes sont produites de la plus grande à la plus petite, chacune à partir de la précédente.
+func buildPreview(ctx context.Context, a models.Attachment) ([]models.Thumbnail, string, error) {
+	rc, err := currentBlobStore().Get(ctx, a.Key)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, attachmentLimits().maxBytes+1))
+	rc.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	// Contrôle refait ici: le blob a pu être écrit par une autre version ou un autre outil.
+	if _, _, err := checkImageBounds(data, attachmentLimits().maxPixels); err != nil {
+		return nil, "", err
+	}
+	src, err := decodeImage(a.ContentType, data)
+	if err != nil {
+		return nil, "", err
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	var thumbs []models.Thumbnail
+	for _, size := range thumbSizes {
+		if w <= size && h <= size {
+			continue
+		}
+		tw, th := fitWithin(w, h, size)
+		src = resizeBox(src, tw, th)
+		out, ctype, err := encodeThumbnail(src, a.ContentType)
+		if err != nil {
+			return nil, "", err
+		}
+		t := models.Thumbnail{
+			Size:        size,
+			Width:       tw,
+			Height:      th,
+			Key:         "thumbnails/" + a.ID.Hex() + "-" + strconv.Itoa(size),
+			ContentType: ctype,
+			Bytes:       int64(len(out)),
+		}
+		if err := currentBlobStore().Put(ctx, t.Key, bytes.NewReader(out), t.Bytes, ctype); err != nil {
+			return nil, "", err
+		}
+		thumbs = append([]models.Thumbnail{t}, thumbs...)
+	}
+	return thumbs, blurHash(src), nil
+}
+
+// applyPreview recopie l'aperçu dans le message et l'annonce au salon.
+func applyPreview(ctx context.Context, a models.Attachment) {
+	oid, err := primitive.ObjectIDFromHex(a.MessageID)
+	if err != nil {
+		return
+	}
+	var m models.Message
+	err = db.MessagesCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": oid, "attachments.id": a.ID.Hex()},
+		bson.M{"$set": bson.M{
+			"attachments.$.placeholder": a.Placeholder,
+			"attachments.$.thumbnails":  a.Thumbnails,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&m)
+	if err != nil {
+		return // message pas encore inséré, ou supprimé
+	}
+	for _, ref := range attachmentRefs(m) {
+		if ref.ID == a.ID.Hex() {
+			publish(ctx, WSEvent{
+				Type:      "message.attachment_preview",
+				Room:      m.Room,
+				MessageID: m.ID.Hex(),
+				Data:      ref,
+				Timestamp: time.Now().UTC(),
+			})
+		}
+	}
+}
+
+// syncPreviews complète, juste après l'insertion, les aperçus terminés
+// entre la validation des pièces jointes et le stockage du message.
+func syncPreviews(ctx context.Context, m *models.Message) {
+	missing := false
+	for _, ref := range m.Attachments {
+		if ref.Width > 0 && ref.Placeholder == "" {
+			missing = true
+		}
+	}
+	if !missing {
+		return
+	}
+	cur, err := db.AttachmentsCol.Find(ctx, bson.M{"message_id": m.ID.Hex(), "preview_status": previewReady})
+	if err != nil {
+		log.Printf("sync previews %s: %v", m.ID.Hex(), err)
+		return
+	}
+	var ready []models.Attachment
+	if err := cur.All(ctx, &ready); err != nil {
+		return
+	}
+	for _, a := range ready {
+		for i := range m.Attachments {
+			if ref := &m.Attachments[i]; ref.ID == a.ID.Hex() && ref.Placeholder == "" {
+				ref.Placeholder, ref.Thumbnails = a.Placeholder, a.Thumbnails
+				if _, err := db.MessagesCol.UpdateOne(ctx,
+					bson.M{"_id": m.ID, "attachments.id": ref.ID},
+					bson.M{"$set": bson.M{"attachments.$.placeholder": a.Placeholder, "attachments.$.thumbnails": a.Thumbnails}},
+				); err != nil {
+					log.Printf("sync previews %s: %v", m.ID.Hex(), err)
+				}
+			}
+		}
+	}
+}
+
+// thumbnailHandler: GET /api/attachments/:id/thumbnails/:size[?exp=&sig=]
+// Mêmes droits que le fichier d'origine.
+func thumbnailHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, err := loadAttachment(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if !authorizeDownload(ctx, c, a) {
+		return
+	}
+	size, _ := strconv.Atoi(c.Param("size"))
+	for _, t := range a.Thumbnails {
+		if t.Size == size {
+			serveBlob(c, t.Key, t.Bytes, t.ContentType, a.Filename)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "thumbnail not found", "preview_status": a.PreviewStatus})
+}
@@ -0,0 +1,228 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// Indicateurs de saisie. L'état partagé vit dans Redis (un ZSET par salon,
+// score = expiration) et les changements passent par un canal pub/sub pour
+// que toutes les instances les diffusent à leurs connexions.
+const (
+	typingTTL      = 6 * time.Second // sans nouveau typing.start, la saisie expire
+	typingThrottle = 3 * time.Second // au plus une diffusion "start" par utilisateur et salon
+	typingChannel  = "chat:typing"
+)
+
+// typingSignal circule sur le canal Redis.
+type typingSignal struct {
+	Room     string `json:"room"`
+	UserKey  string `json:"user_key"`
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username"`
+	Typing   bool   `json:"typing"`
+}
+
+// typingState est une saisie en cours sur une connexion de cette instance.
+type typingState struct {
+	conn     *websocket.Conn
+	signal   typingSignal
+	lastSeen time.Time
+}
+
+var (
+	typingMu    sync.Mutex
+	typingLocal = map[string]*typingState{} // room|userKey -> état
+	typingOnce  sync.Once
+)
+
+func typingZSet(room string) string { return "typing:" + room }
+
+// typingMember est le membre du ZSET: clé utilisateur et nom affiché.
+func typingMember(sig typingSignal) string { return sig.UserKey + "|" + sig.Username }
+func typingThrottleKey(room, userKey string) string {
+	return "typing:throttle:" + room + ":" + userKey
+}
+
+// startTyping démarre le relais pub/sub et le nettoyage des saisies expirées.
+func startTyping() {
+	typingOnce.Do(func() {
+		go typingRelay()
+		go typingJanitor()
+	})
+}
+
+func typingRelay() {
+	sub := db.Rdb.Subscribe(context.Background(), typingChannel)
+	for msg := range sub.Channel() {
+		var sig typingSignal
+		if err := json.Unmarshal([]byte(msg.Payload), &sig); err != nil {
+			continue
+		}
+		deliverTyping(sig)
+	}
+}
+
+// deliverTyping diffuse localement, sauf aux connexions de l'utilisateur qui tape.
+func deliverTyping(sig typingSignal) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	allow, err := roomAudience(ctx, sig.Room)
+	if err != nil {
+		return
+	}
+	kind := "typing.stop"
+	data := gin.H{"user_id": sig.UserID, "username": sig.Username}
+	if sig.UserID == "" {
+		data["guest"] = true
+	}
+	if sig.Typing {
+		kind = "typing.start"
+		data["expires_in"] = int(typingTTL.Seconds())
+	}
+	wsHub.broadcastTo(WSEvent{Type: kind, Room: sig.Room, Data: data, Timestamp: time.Now().UTC()}, nil,
+		func(u WSUser) bool {
+			return authorKey(u.ID, u.Username) != sig.UserKey && (allow == nil || allow(u))
+		})
+}
+
+func publishTyping(ctx context.Context, sig typingSignal) {
+	payload, _ := json.Marshal(sig)
+	if err := db.Rdb.Publish(ctx, typingChannel, payload).Err(); err != nil {
+		log.Printf("typing publish: %v", err)
+	}
+}
+
+// handleTyping traite typing.start / typing.stop reçus d'une connexion.
+func handleTyping(conn *websocket.Conn, user WSUser, room string, typing bool) {
+	if room == "" {
+		room = "general"
+	}
+	sig := typingSignal{
+		Room:     room,
+		UserKey:  authorKey(user.ID, user.Username),
+		UserID:   user.ID,
+		Username: user.Username,
+		Typing:   typing,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if !typing {
+		stopTyping(ctx, sig)
+		return
+	}
+
+	typingMu.Lock()
+	typingLocal[room+"|"+sig.UserKey] = &typingState{conn: conn, signal: sig, lastSeen: time.Now()}
+	typingMu.Unlock()
+
+	now := time.Now()
+	pipe := db.Rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, typingZSet(room), "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	pipe.ZAdd(ctx, typingZSet(room), redis.Z{Score: float64(now.Add(typingTTL).UnixMilli()), Member: typingMember(sig)})
+	pipe.Expire(ctx, typingZSet(room), typingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("typing state: %v", err)
+	}
+
+	// Coalescence: les start répétés dans la fenêtre ne font que prolonger l'état.
+	ok, err := db.Rdb.SetNX(ctx, typingThrottleKey(room, sig.UserKey), 1, typingThrottle).Result()
+	if err != nil || !ok {
+		return
+	}
+	publishTyping(ctx, sig)
+}
+
+// stopTyping efface la saisie (locale et Redis) et diffuse l'arrêt si elle existait.
+func stopTyping(ctx context.Context, sig typingSignal) {
+	typingMu.Lock()
+	_, local := typingLocal[sig.Room+"|"+sig.UserKey]
+	delete(typingLocal, sig.Room+"|"+sig.UserKey)
+	typingMu.Unlock()
+
+	removed, err := db.Rdb.ZRem(ctx, typingZSet(sig.Room), typingMember(sig)).Result()
+	if err != nil {
+		log.Printf("typing state: %v", err)
+	}
+	db.Rdb.Del(ctx, typingThrottleKey(sig.Room, sig.UserKey))
+	if !local && removed == 0 {
+		return
+	}
+	sig.Typing = false
+	publishTyping(ctx, sig)
+}
+
+// typingJanitor expire les saisies restées silencieuses plus de typingTTL.
+func typingJanitor() {
+	for range time.Tick(time.Second) {
+		var expired []typingSignal
+		typingMu.Lock()
+		for _, st := range typingLocal {
+			if time.Since(st.lastSeen) > typingTTL {
+				expired = append(expired, st.signal)
+			}
+		}
+		typingMu.Unlock()
+		for _, sig := range expired {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			stopTyping(ctx, sig)
+			cancel()
+		}
+	}
+}
+
+// typingDisconnected arrête les saisies portées par une connexion qui se ferme.
+func typingDisconnected(conn *websocket.Conn) {
+	var gone []typingSignal
+	typingMu.Lock()
+	for _, st := range typingLocal {
+		if st.conn == conn {
+			gone = append(gone, st.signal)
+		}
+	}
+	typingMu.Unlock()
+	for _, sig := range gone {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		stopTyping(ctx, sig)
+		cancel()
+	}
+}
+
+// typingSnapshotHandler: GET /api/rooms/:room/typing — utilisateurs en train d'écrire.
+func typingSnapshotHandler(c *gin.Context) {
+	room := c.Param("room")
+	if !canReadRoom(c, requestViewer(c.Request), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	members, err := db.Rdb.ZRangeByScore(c, typingZSet(room), &redis.ZRangeBy{Min: now, Max: "+inf"}).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "redis error"})
+		return
+	}
+	typing := make([]gin.H, 0, len(members))
+	for _, m := range members {
+		key, name, _ := strings.Cut(m, "|")
+		u := gin.H{"username": name}
+		if strings.HasPrefix(key, "guest:") {
+			u["guest"] = true
+		} else {
+			u["user_id"] = key
+		}
+		typing = append(typing, u)
+	}
+	c.JSON(http.StatusOK, gin.H{"room": room, "typing": typing})
+}
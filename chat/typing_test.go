@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTypingKeys(t *testing.T) {
+	sig := typingSignal{Room: "general", UserKey: authorKey("", "Invité-7"), Username: "Invité-7", Typing: true}
+	if got, want := typingZSet(sig.Room), "typing:general"; got != want {
+		t.Errorf("typingZSet = %q; want %q", got, want)
+	}
+	if got, want := typingMember(sig), "guest:Invité-7|Invité-7"; got != want {
+		t.Errorf("typingMember = %q; want %q", got, want)
+	}
+	if got, want := typingThrottleKey(sig.Room, sig.UserKey), "typing:throttle:general:guest:Invité-7"; got != want {
+		t.Errorf("typingThrottleKey = %q; want %q", got, want)
+	}
+	// Deux salons, même utilisateur: verrous de coalescence distincts.
+	if typingThrottleKey("a", "u1") == typingThrottleKey("b", "u1") {
+		t.Errorf("typingThrottleKey identique pour deux salons")
+	}
+	// Un start coalescé doit encore prolonger l'état avant son expiration.
+	if typingThrottle >= typingTTL {
+		t.Errorf("typingThrottle (%v) >= typingTTL (%v)", typingThrottle, typingTTL)
+	}
+}
+
+func TestTypingSignalJSON(t *testing.T) {
+	tests := []struct {
+		sig  typingSignal
+		want string
+	}{
+		{
+			typingSignal{Room: "general", UserKey: "u1", UserID: "u1", Username: "alice", Typing: true},
+			`{"room":"general","user_key":"u1","user_id":"u1","username":"alice","typing":true}`,
+		},
+		{
+			typingSignal{Room: "general", UserKey: "guest:bob", Username: "bob"},
+			`{"room":"general","user_key":"guest:bob","username":"bob","typing":false}`,
+		},
+	}
+	for _, tt := range tests {
+		b, _ := json.Marshal(tt.sig)
+		if string(b) != tt.want {
+			t.Errorf("json = %s; want %s", b, tt.want)
+		}
+		var back typingSignal
+		if err := json.Unmarshal(b, &back); err != nil || back != tt.sig {
+			t.Errorf("aller-retour = %+v, %v; want %+v", back, err, tt.sig)
+		}
+	}
+}
@@ -0,0 +1,654 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Louis-Bouhours/ecrireback/db"
+	"github.com/Louis-Bouhours/ecrireback/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Webhooks sortants. Les évènements d'un salon (message, join, leave) sont posés
+// dans webhookQueue sans attente: ni la boucle /ws ni le worker de persistance ne
+// patientent. Une goroutine en fait des envois (webhook_deliveries), que les workers
+// prennent sous bail comme les aperçus d'images: un envoi survit à un redémarrage
+// et une seule instance le tente à la fois. Après un échec, nouvel essai avec un
+// délai exponentiel; au-delà de WEBHOOK_MAX_ATTEMPTS l'envoi reste en lettre morte
+// ("dead"), visible et relançable par les modérateurs du salon.
+// L'ordre des envois n'est pas garanti: le corps porte l'horodatage de l'évènement.
+// join/leave: premier abonnement d'un utilisateur au salon (connexion pour general,
+// trame subscribe ou ?room= sinon) et départ de sa dernière connexion abonnée
+// (unsubscribe, déconnexion), ajout à un salon privé, kick et ban. Le suivi des
+// abonnements est propre à chaque instance.
+const (
+	hookMessage = "message"
+	hookJoin    = "join"
+	hookLeave   = "leave"
+
+	deliveryPending   = "pending"
+	deliveryRetrying  = "retrying"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+
+	maxWebhooksPerRoom = 10
+	maxDeliveryLog     = 20 // dernières tentatives gardées par envoi
+	webhookPoll        = time.Second
+	webhookCacheTTL    = 30 * time.Second
+
+	eventHeader    = "X-Ecrire-Event"
+	deliveryHeader = "X-Ecrire-Delivery"
+)
+
+var hookEvents = []string{hookMessage, hookJoin, hookLeave}
+
+type webhookConfig struct {
+	timeout     time.Duration // par tentative
+	backoff     time.Duration // délai avant le 2e essai, doublé ensuite
+	maxBackoff  time.Duration
+	maxAttempts int
+	workers     int
+}
+
+type webhookEvent struct {
+	room  string
+	event string
+	data  interface{}
+	at    time.Time
+}
+
+type cachedHooks struct {
+	hooks    []models.Webhook
+	loadedAt time.Time
+}
+
+var (
+	webhookQueue = make(chan webhookEvent, 1000)
+	webhookWake  = make(chan struct{}, 1)
+	webhookOnce  sync.Once
+
+	webhookCfg     webhookConfig
+	webhookCfgOnce sync.Once
+
+	hookCacheMu sync.Mutex
+	hookCache   = map[string]cachedHooks{}
+
+	// Adresses publiques seulement, sans redirection (voir outbound.go);
+	// le délai est celui du contexte de chaque tentative.
+	webhookClient = newOutboundClient(0)
+)
+
+// webhookSettings lit WEBHOOK_TIMEOUT, WEBHOOK_BACKOFF, WEBHOOK_MAX_BACKOFF,
+// WEBHOOK_MAX_ATTEMPTS et WEBHOOK_WORKERS.
+func webhookSettings() webhookConfig {
+	webhookCfgOnce.Do(func() {
+		webhookCfg = webhookConfig{
+			timeout:     5 * time.Second,
+			backoff:     10 * time.Second,
+			maxBackoff:  time.Hour,
+			maxAttempts: int(envFloat("WEBHOOK_MAX_ATTEMPTS", 8)),
+			workers:     int(envFloat("WEBHOOK_WORKERS", 4)),
+		}
+		if d, err := time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT")); err == nil && d > 0 {
+			webhookCfg.timeout = d
+		}
+		if d, err := time.ParseDuration(os.Getenv("WEBHOOK_BACKOFF")); err == nil && d > 0 {
+			webhookCfg.backoff = d
+		}
+		if d, err := time.ParseDuration(os.Getenv("WEBHOOK_MAX_BACKOFF")); err == nil && d > 0 {
+			webhookCfg.maxBackoff = d
+		}
+		if webhookCfg.maxAttempts < 1 {
+			webhookCfg.maxAttempts = 1
+		}
+		if webhookCfg.workers < 1 {
+			webhookCfg.workers = 1
+		}
+	})
+	return webhookCfg
+}
+
+func startWebhooks() {
+	webhookOnce.Do(func() {
+		go func() {
+			for ev := range webhookQueue {
+				enqueueDeliveries(ev)
+			}
+		}()
+		for i := 0; i < webhookSettings().workers; i++ {
+			go func() {
+				t := time.NewTicker(webhookPoll)
+				defer t.Stop()
+				for {
+					for nextDelivery() {
+					}
+					select {
+					case <-webhookWake:
+					case <-t.C:
+					}
+				}
+			}()
+		}
+	})
+}
+
+func wakeWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// emitWebhook signale un évènement de salon à ses webhooks, sans jamais bloquer.
+func emitWebhook(room, event string, data interface{}) {
+	select {
+	case webhookQueue <- webhookEvent{room: room, event: event, data: data, at: time.Now().UTC()}:
+	default:
+		log.Printf("webhook queue full: dropping %s event for room=%s", event, room)
+	}
+}
+
+// memberEvent est le corps des évènements join et leave.
+func memberEvent(u WSUser, reason string) gin.H {
+	return gin.H{"user_id": u.ID, "username": u.Username, "guest": !u.Authenticated, "reason": reason}
+}
+
+// roomWebhooks renvoie les webhooks actifs du salon (cache local de webhookCacheTTL).
+func roomWebhooks(ctx context.Context, room string) ([]models.Webhook, error) {
+	hookCacheMu.Lock()
+	e, ok := hookCache[room]
+	hookCacheMu.Unlock()
+	if ok && time.Since(e.loadedAt) < webhookCacheTTL {
+		return e.hooks, nil
+	}
+	cur, err := db.WebhooksCol.Find(ctx, bson.M{"room": room, "active": true})
+	if err != nil {
+		return nil, err
+	}
+	var hooks []models.Webhook
+	if err := cur.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	hookCacheMu.Lock()
+	hookCache[room] = cachedHooks{hooks: hooks, loadedAt: time.Now()}
+	hookCacheMu.Unlock()
+	return hooks, nil
+}
+
+func forgetWebhooks(room string) {
+	hookCacheMu.Lock()
+	delete(hookCache, room)
+	hookCacheMu.Unlock()
+}
+
+// enqueueDeliveries crée un envoi par webhook abonné à l'évènement.
+func enqueueDeliveries(ev webhookEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hooks, err := roomWebhooks(ctx, ev.room)
+	if err != nil {
+		log.Printf("webhooks %s: %v", ev.room, err)
+		return
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var docs []interface{}
+	for _, h := range hooks {
+		if !contains(h.Events, ev.event) {
+			continue
+		}
+		d := models.WebhookDelivery{
+			ID:            primitive.NewObjectID(),
+			WebhookID:     h.ID.Hex(),
+			Room:          ev.room,
+			Event:         ev.event,
+			Status:        deliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		body, err := json.Marshal(gin.H{
+			"id":         d.ID.Hex(),
+			"webhook_id": d.WebhookID,
+			"event":      ev.event,
+			"room":       ev.room,
+			"timestamp":  ev.at,
+			"data":       ev.data,
+		})
+		if err != nil {
+			log.Printf("webhook payload %s: %v", ev.event, err)
+			return
+		}
+		d.Payload = string(body)
+		docs = append(docs, d)
+	}
+	if len(docs) == 0 {
+		return
+	}
+	if _, err := db.DeliveriesCol.InsertMany(ctx, docs); err != nil {
+		log.Printf("webhook deliveries %s: %v", ev.room, err)
+		return
+	}
+	wakeWebhooks()
+}
+
+// nextDelivery tente un envoi arrivé à échéance; false s'il n'y en a plus.
+func nextDelivery() bool {
+	cfg := webhookSettings()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout+5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var d models.WebhookDelivery
+	err := db.DeliveriesCol.FindOneAndUpdate(ctx,
+		bson.M{
+			"status":          bson.M{"$in": []string{deliveryPending, deliveryRetrying}},
+			"next_attempt_at": bson.M{"$lte": primitive.NewDateTimeFromTime(now)},
+			"$or": []bson.M{
+				{"lease_until": bson.M{"$exists": false}},
+				{"lease_until": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{"lease_until": primitive.NewDateTimeFromTime(now.Add(2*cfg.timeout + 5*time.Second))},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&d)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("webhook queue: %v", err)
+		}
+		return false
+	}
+
+	var attempt models.DeliveryAttempt
+	h, err := loadWebhook(ctx, d.WebhookID)
+	switch {
+	case err != nil && !errors.Is(err, errNotFound):
+		log.Printf("webhook %s: %v", d.WebhookID, err)
+		return true // nouvel essai à l'expiration du bail
+	case err != nil:
+		attempt = models.DeliveryAttempt{At: primitive.NewDateTimeFromTime(now), Error: "webhook deleted"}
+		d.Attempts = cfg.maxAttempts
+	case !h.Active:
+		attempt = models.DeliveryAttempt{At: primitive.NewDateTimeFromTime(now), Error: "webhook disabled"}
+		d.Attempts = cfg.maxAttempts
+	case d.Attempts > cfg.maxAttempts:
+		// Bail expiré au dernier essai (instance arrêtée en cours d'envoi).
+		attempt = models.DeliveryAttempt{At: primitive.NewDateTimeFromTime(now), Error: "lease expired"}
+	default:
+		attempt = deliver(ctx, h, d)
+	}
+
+	set := bson.M{}
+	switch {
+	case attempt.Error == "":
+		set["status"], set["delivered_at"] = deliveryDelivered, attempt.At
+	case d.Attempts >= cfg.maxAttempts:
+		set["status"] = deliveryDead
+	default:
+		set["status"] = deliveryRetrying
+		set["next_attempt_at"] = primitive.NewDateTimeFromTime(time.Now().Add(retryDelay(cfg, d.Attempts)))
+	}
+	if _, err := db.DeliveriesCol.UpdateOne(ctx,
+		bson.M{"_id": d.ID},
+		bson.M{
+			"$set":   set,
+			"$unset": bson.M{"lease_until": ""},
+			"$push":  bson.M{"log": bson.M{"$each": []models.DeliveryAttempt{attempt}, "$slice": -maxDeliveryLog}},
+		},
+	); err != nil {
+		log.Printf("webhook delivery %s: %v", d.ID.Hex(), err)
+	}
+	return true
+}
+
+// retryDelay: backoff, 2×backoff, 4×backoff... plafonné, avec ±20 % d'aléa
+// pour étaler les reprises après une panne du destinataire.
+func retryDelay(cfg webhookConfig, attempts int) time.Duration {
+	d := cfg.backoff
+	for i := 1; i < attempts && d < cfg.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > cfg.maxBackoff {
+		d = cfg.maxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5+1)) - d/10
+	return d + jitter
+}
+
+// deliver poste le corps signé; toute réponse hors 2xx est un échec.
+func deliver(ctx context.Context, h models.Webhook, d models.WebhookDelivery) models.DeliveryAttempt {
+	start := time.Now()
+	attempt := models.DeliveryAttempt{At: primitive.NewDateTimeFromTime(start)}
+	ctx, cancel := context.WithTimeout(ctx, webhookSettings().timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		attempt.Error = "invalid_request"
+		return attempt
+	}
+	ts := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, d.Event)
+	req.Header.Set(deliveryHeader, d.ID.Hex())
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(signatureHeader, signPayload(h.Secret, ts, []byte(d.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		// Le journal est lu par les modérateurs: le détail reste dans les logs.
+		log.Printf("webhook %s: %v", h.ID.Hex(), err)
+		attempt.Error = outboundError(err)
+		return attempt
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode/100 != 2 {
+		attempt.Error = resp.Status
+	}
+	return attempt
+}
+
+func loadWebhook(ctx context.Context, id string) (models.Webhook, error) {
+	var h models.Webhook
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return h, errNotFound
+	}
+	if err := db.WebhooksCol.FindOne(ctx, bson.M{"_id": oid}).Decode(&h); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return h, errNotFound
+		}
+		return h, err
+	}
+	return h, nil
+}
+
+// validHookEvents: sous-ensemble non vide de hookEvents, sans doublon.
+func validHookEvents(events []string) bool {
+	if len(events) == 0 {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, e := range events {
+		if !contains(hookEvents, e) || seen[e] {
+			return false
+		}
+		seen[e] = true
+	}
+	return true
+}
+
+// webhookRoom contrôle la route: salon (pas de DM) dont l'appelant est modérateur.
+func webhookRoom(c *gin.Context) (string, bool) {
+	room := c.Param("room")
+	if isDMRoom(room) || !roomNameRe.MatchString(room) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room"})
+		return "", false
+	}
+	if !canModerate(c, authViewer(c), room) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return "", false
+	}
+	return room, true
+}
+
+// listWebhooksHandler: GET /api/rooms/:room/webhooks
+func listWebhooksHandler(c *gin.Context) {
+	room, ok := webhookRoom(c)
+	if !ok {
+		return
+	}
+	cur, err := db.WebhooksCol.Find(c, bson.M{"room": room}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	hooks := []models.Webhook{}
+	if err := cur.All(c, &hooks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+// createWebhookHandler: POST /api/rooms/:room/webhooks {url, events}
+// Sans events: tous. Le secret de signature n'est renvoyé qu'ici (et à sa rotation).
+func createWebhookHandler(c *gin.Context) {
+	room, ok := webhookRoom(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !validOutboundURL(req.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid url"})
+		return
+	}
+	if req.Events == nil {
+		req.Events = hookEvents
+	}
+	if !validHookEvents(req.Events) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid events", "allowed": hookEvents})
+		return
+	}
+	n, err := db.WebhooksCol.CountDocuments(c, bson.M{"room": room})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if n >= maxWebhooksPerRoom {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errorCode(errLimit)})
+		return
+	}
+	secret, err := newSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "secret generation failed"})
+		return
+	}
+	h := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		Room:      room,
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    req.Events,
+		Active:    true,
+		CreatedBy: authViewer(c).ID,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := db.WebhooksCol.InsertOne(c, h); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	forgetWebhooks(room)
+	c.JSON(http.StatusCreated, gin.H{"webhook": h, "secret": secret})
+}
+
+// updateWebhookHandler: PATCH /api/rooms/:room/webhooks/:id {url, events, active, rotate_secret}
+func updateWebhookHandler(c *gin.Context) {
+	room, ok := webhookRoom(c)
+	if !ok {
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req struct {
+		URL          *string  `json:"url"`
+		Events       []string `json:"events"`
+		Active       *bool    `json:"active"`
+		RotateSecret bool     `json:"rotate_secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	set := bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	if req.URL != nil {
+		if !validOutboundURL(*req.URL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid url"})
+			return
+		}
+		set["url"] = *req.URL
+	}
+	if req.Events != nil {
+		if !validHookEvents(req.Events) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid events", "allowed": hookEvents})
+			return
+		}
+		set["events"] = req.Events
+	}
+	if req.Active != nil {
+		set["active"] = *req.Active
+	}
+	secret := ""
+	if req.RotateSecret {
+		if secret, err = newSecret(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "secret generation failed"})
+			return
+		}
+		set["secret"] = secret
+	}
+
+	var h models.Webhook
+	err = db.WebhooksCol.FindOneAndUpdate(c,
+		bson.M{"_id": oid, "room": room},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&h)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	forgetWebhooks(room)
+	out := gin.H{"webhook": h}
+	if secret != "" {
+		out["secret"] = secret
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// deleteWebhookHandler: DELETE /api/rooms/:room/webhooks/:id
+// Les envois en attente passent en lettre morte à leur prochain tour.
+func deleteWebhookHandler(c *gin.Context) {
+	room, ok := webhookRoom(c)
+	if !ok {
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	res, err := db.WebhooksCol.DeleteOne(c, bson.M{"_id": oid, "room": room})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	forgetWebhooks(room)
+	c.Status(http.StatusNoContent)
+}
+
+// deliveriesHandler: GET /api/rooms/:room/webhooks/:id/deliveries?status=&limit=&before=<id>
+// Journal des envois, du plus récent au plus ancien; status=dead pour les lettres mortes.
+func deliveriesHandler(c *gin.Context) {
+	room, ok := webhookRoom(c)
+	if !ok {
+		return
+	}
+	filter := bson.M{"webhook_id": c.Param("id"), "room": room}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if before := c.Query("before"); before != "" {
+		oid, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": oid}
+	}
+	limit := 50
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cur, err := db.DeliveriesCol.Find(c, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	deliveries := []models.WebhookDelivery{}
+	if err := cur.All(c, &deliveries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db cursor error"})
+		return
+	}
+	var next interface{}
+	if len(deliveries) == limit {
+		next = deliveries[len(deliveries)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "next": next})
+}
+
+// redeliverHandler: POST /api/rooms/:room/webhooks/:id/deliveries/:delivery/retry
+// Remet une lettre morte en file, avec un compteur d'essais neuf.
+func redeliverHandler(c *gin.Context) {
+	room, ok := webhookRoom(c)
+	if !ok {
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(c.Param("delivery"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var d models.WebhookDelivery
+	err = db.DeliveriesCol.FindOneAndUpdate(c,
+		bson.M{"_id": oid, "webhook_id": c.Param("id"), "room": room, "status": deliveryDead},
+		bson.M{"$set": bson.M{
+			"status":          deliveryPending,
+			"attempts":        0,
+			"next_attempt_at": primitive.NewDateTimeFromTime(time.Now()),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&d)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dead delivery not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	wakeWebhooks()
+	c.JSON(http.StatusAccepted, d)
+}
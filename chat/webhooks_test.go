@@ -0,0 +1,30 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cfg := webhookConfig{backoff: 10 * time.Second, maxBackoff: time.Hour}
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{8, 1280 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		lo, hi := tt.base-tt.base/10, tt.base+tt.base/10
+		for i := 0; i < 200; i++ {
+			if d := retryDelay(cfg, tt.attempts); d < lo || d > hi {
+				t.Fatalf("retryDelay(%d) = %v; want within [%v, %v]", tt.attempts, d, lo, hi)
+			}
+		}
+	}
+}
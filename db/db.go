@@ -8,6 +8,8 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -16,7 +18,23 @@ var (
 	Rdb         *redis.Client
 	UsersCol    *mongo.Collection
 	MessagesCol *mongo.Collection
-	Ctx         = context.Background()
+	CountersCol *mongo.Collection
+	RoomsCol    *mongo.Collection
+	// Boîte de réception (mentions) et préférences par salon
+	NotificationsCol *mongo.Collection
+	RoomSettingsCol  *mongo.Collection
+	ReadStatesCol    *mongo.Collection
+	ModerationCol    *mongo.Collection
+	ReviewsCol       *mongo.Collection // contenus signalés par les filtres
+	ReportsCol       *mongo.Collection // signalements des utilisateurs
+	BlocksCol        *mongo.Collection // utilisateurs bloqués
+	AttachmentsCol   *mongo.Collection // métadonnées des pièces jointes
+	ScheduledCol     *mongo.Collection // messages programmés et rappels
+	PollVotesCol     *mongo.Collection // votes des sondages, un par utilisateur
+	CommandsCol      *mongo.Collection // commandes slash externes
+	WebhooksCol      *mongo.Collection // abonnements sortants aux évènements des salons
+	DeliveriesCol    *mongo.Collection // envois des webhooks et leur journal
+	Ctx              = context.Background()
 )
 
 func Init() {
@@ -50,6 +68,21 @@ func Init() {
 	db := mongoClient.Database("ecrire_db")
 	UsersCol = db.Collection("users")
 	MessagesCol = db.Collection("messages")
+	CountersCol = db.Collection("counters")
+	RoomsCol = db.Collection("rooms")
+	NotificationsCol = db.Collection("notifications")
+	RoomSettingsCol = db.Collection("room_settings")
+	ReadStatesCol = db.Collection("read_states")
+	ModerationCol = db.Collection("moderation_actions")
+	ReviewsCol = db.Collection("review_queue")
+	ReportsCol = db.Collection("reports")
+	BlocksCol = db.Collection("blocks")
+	AttachmentsCol = db.Collection("attachments")
+	ScheduledCol = db.Collection("scheduled_jobs")
+	PollVotesCol = db.Collection("poll_votes")
+	CommandsCol = db.Collection("commands")
+	WebhooksCol = db.Collection("webhooks")
+	DeliveriesCol = db.Collection("webhook_deliveries")
 	log.Println("✅ Connecté à MongoDB")
 
 	// Index unique sur username
@@ -60,4 +93,238 @@ func Init() {
 	if _, err := UsersCol.Indexes().CreateOne(Ctx, usersIndex); err != nil {
 		log.Printf("⚠️ Impossible de créer l'index unique sur username: %v", err)
 	}
+
+	// Index unique (auteur, client_msg_id): déduplique les renvois après reconnexion
+	dedupeIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "author_key", Value: 1}, {Key: "client_msg_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_author_client_msg").
+			SetPartialFilterExpression(bson.M{"client_msg_id": bson.M{"$type": "string"}}),
+	}
+	if _, err := MessagesCol.Indexes().CreateOne(Ctx, dedupeIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index de déduplication des messages: %v", err)
+	}
+
+	// Index (room, seq): rejeu des messages manqués après reconnexion
+	seqIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "room", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetName("room_seq"),
+	}
+	if _, err := MessagesCol.Indexes().CreateOne(Ctx, seqIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index (room, seq) des messages: %v", err)
+	}
+
+	// Index (room, created_at): pagination de l'historique par curseur
+	historyIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "room", Value: 1}, {Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("room_created_at"),
+	}
+	if _, err := MessagesCol.Indexes().CreateOne(Ctx, historyIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index (room, created_at) des messages: %v", err)
+	}
+
+	// Index texte sur content: recherche plein texte
+	textIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "content", Value: "text"}},
+		Options: options.Index().SetName("content_text").SetDefaultLanguage("french"),
+	}
+	if _, err := MessagesCol.Indexes().CreateOne(Ctx, textIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index texte des messages: %v", err)
+	}
+
+	// Index unique sur le nom de salon
+	roomsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_room_name"),
+	}
+	if _, err := RoomsCol.Indexes().CreateOne(Ctx, roomsIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index unique sur le nom de salon: %v", err)
+	}
+
+	// Salon par défaut: document public sans propriétaire, créé avant toute requête
+	// pour que personne ne puisse le revendiquer via POST /api/rooms.
+	if _, err := RoomsCol.UpdateOne(Ctx,
+		bson.M{"name": "general"},
+		bson.M{
+			"$set":         bson.M{"private": false},
+			"$unset":       bson.M{"owner_id": "", "members": ""},
+			"$setOnInsert": bson.M{"created_at": primitive.NewDateTimeFromTime(time.Now())},
+		},
+		options.Update().SetUpsert(true),
+	); err != nil {
+		log.Printf("⚠️ Impossible de créer le salon general: %v", err)
+	}
+
+	// Index (thread_root, created_at): lecture des fils de discussion
+	threadIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "thread_root", Value: 1}, {Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("thread_root_created_at").
+			SetPartialFilterExpression(bson.M{"thread_root": bson.M{"$type": "string"}}),
+	}
+	if _, err := MessagesCol.Indexes().CreateOne(Ctx, threadIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des fils de discussion: %v", err)
+	}
+
+	// Index reply_to: réponses à un message supprimé ou masqué (extrait cité)
+	replyIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "reply_to", Value: 1}},
+		Options: options.Index().SetName("reply_to").
+			SetPartialFilterExpression(bson.M{"reply_to": bson.M{"$type": "string"}}),
+	}
+	if _, err := MessagesCol.Indexes().CreateOne(Ctx, replyIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des réponses: %v", err)
+	}
+
+	// Index (user_id, read, created_at): boîte de réception et compteur de non lus
+	notifIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "read", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("user_read_created_at"),
+	}
+	if _, err := NotificationsCol.Indexes().CreateOne(Ctx, notifIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des notifications: %v", err)
+	}
+
+	// Index unique (user_id, room) sur les préférences de salon
+	settingsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "room", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_user_room"),
+	}
+	if _, err := RoomSettingsCol.Indexes().CreateOne(Ctx, settingsIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des préférences de salon: %v", err)
+	}
+
+	// Index unique (user_id, room) sur les positions de lecture
+	readIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "room", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_user_room"),
+	}
+	if _, err := ReadStatesCol.Indexes().CreateOne(Ctx, readIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des positions de lecture: %v", err)
+	}
+
+	// Index (room, type, created_at): sanctions actives et journal de modération
+	moderationIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "room", Value: 1}, {Key: "type", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("room_type_created_at"),
+	}
+	if _, err := ModerationCol.Indexes().CreateOne(Ctx, moderationIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index de modération: %v", err)
+	}
+
+	// Index (status, room, _id): file de revue par statut et salon
+	reviewIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "room", Value: 1}, {Key: "_id", Value: -1}},
+		Options: options.Index().SetName("status_room_id"),
+	}
+	if _, err := ReviewsCol.Indexes().CreateOne(Ctx, reviewIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index de la file de revue: %v", err)
+	}
+
+	// Index unique (reporter_id, target_key): un signalement par cible et par auteur
+	reportIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "reporter_id", Value: 1}, {Key: "target_key", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_reporter_target"),
+	}
+	if _, err := ReportsCol.Indexes().CreateOne(Ctx, reportIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index unique des signalements: %v", err)
+	}
+
+	// Index (status, room, _id): file des signalements
+	reportQueueIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "room", Value: 1}, {Key: "_id", Value: -1}},
+		Options: options.Index().SetName("status_room_id"),
+	}
+	if _, err := ReportsCol.Indexes().CreateOne(Ctx, reportQueueIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index de la file des signalements: %v", err)
+	}
+
+	// Index unique (blocker_id, blocked_id)
+	blocksIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "blocker_id", Value: 1}, {Key: "blocked_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("uniq_blocker_blocked"),
+	}
+	if _, err := BlocksCol.Indexes().CreateOne(Ctx, blocksIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des blocages: %v", err)
+	}
+
+	// Index (message_id, created_at): collecte des fichiers orphelins
+	attachmentsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "message_id", Value: 1}, {Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("message_id_created_at"),
+	}
+	if _, err := AttachmentsCol.Indexes().CreateOne(Ctx, attachmentsIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des pièces jointes: %v", err)
+	}
+
+	// Index (status, due_at): échéances à déclencher
+	scheduledIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "due_at", Value: 1}},
+		Options: options.Index().SetName("status_due_at"),
+	}
+	if _, err := ScheduledCol.Indexes().CreateOne(Ctx, scheduledIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des envois programmés: %v", err)
+	}
+
+	// Index unique (message_id, user_id): un vote par utilisateur et par sondage
+	pollVoteIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "message_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("message_id_user_id_unique"),
+	}
+	if _, err := PollVotesCol.Indexes().CreateOne(Ctx, pollVoteIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des votes: %v", err)
+	}
+
+	// Index (poll.closed, poll.closes_at): sondages à clôturer
+	pollCloseIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "poll.closed", Value: 1}, {Key: "poll.closes_at", Value: 1}},
+		Options: options.Index().SetName("poll_closes_at").
+			SetPartialFilterExpression(bson.M{"poll.closes_at": bson.M{"$exists": true}}),
+	}
+	if _, err := MessagesCol.Indexes().CreateOne(Ctx, pollCloseIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des sondages: %v", err)
+	}
+
+	// Index unique (name, room): une commande externe par nom et par salon
+	commandIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}, {Key: "room", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("name_room_unique"),
+	}
+	if _, err := CommandsCol.Indexes().CreateOne(Ctx, commandIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des commandes: %v", err)
+	}
+
+	// Index room: webhooks d'un salon
+	webhookIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "room", Value: 1}},
+		Options: options.Index().SetName("room"),
+	}
+	if _, err := WebhooksCol.Indexes().CreateOne(Ctx, webhookIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index des webhooks: %v", err)
+	}
+
+	// Index (status, next_attempt_at): envois à faire
+	deliveryQueueIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		Options: options.Index().SetName("status_next_attempt_at"),
+	}
+	if _, err := DeliveriesCol.Indexes().CreateOne(Ctx, deliveryQueueIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index de la file des webhooks: %v", err)
+	}
+
+	// Index (webhook_id, _id): journal des envois d'un webhook
+	deliveryLogIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: -1}},
+		Options: options.Index().SetName("webhook_id_id"),
+	}
+	if _, err := DeliveriesCol.Indexes().CreateOne(Ctx, deliveryLogIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index du journal des webhooks: %v", err)
+	}
+
+	// Index TTL sur created_at: journal des envois conservé 30 jours
+	deliveryTTLIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("created_at_ttl").SetExpireAfterSeconds(30 * 24 * 3600),
+	}
+	if _, err := DeliveriesCol.Indexes().CreateOne(Ctx, deliveryTTLIndex); err != nil {
+		log.Printf("⚠️ Impossible de créer l'index d'expiration des webhooks: %v", err)
+	}
 }
@@ -0,0 +1,53 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Attachment décrit un fichier téléversé; le contenu est dans le BlobStore sous Key.
+// Tant que MessageID est vide, le fichier est orphelin et sera supprimé passé un délai.
+type Attachment struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID     string             `bson:"owner_id" json:"owner_id"`
+	Key         string             `bson:"key" json:"-"`
+	Filename    string             `bson:"filename" json:"filename"`
+	ContentType string             `bson:"content_type" json:"content_type"`
+	Size        int64              `bson:"size" json:"size"`
+	Room        string             `bson:"room,omitempty" json:"room,omitempty"`
+	MessageID   string             `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	Detached    bool               `bson:"detached,omitempty" json:"-"` // message supprimé: à collecter
+	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
+
+	// Images: dimensions connues au téléversement, aperçu calculé en tâche de fond
+	Width           int                 `bson:"width,omitempty" json:"width,omitempty"`
+	Height          int                 `bson:"height,omitempty" json:"height,omitempty"`
+	PreviewStatus   string              `bson:"preview_status,omitempty" json:"preview_status,omitempty"` // pending, ready, failed
+	Placeholder     string              `bson:"placeholder,omitempty" json:"placeholder,omitempty"`       // BlurHash
+	Thumbnails      []Thumbnail         `bson:"thumbnails,omitempty" json:"thumbnails,omitempty"`
+	PreviewLease    *primitive.DateTime `bson:"preview_lease,omitempty" json:"-"`
+	PreviewAttempts int                 `bson:"preview_attempts,omitempty" json:"-"`
+}
+
+// Thumbnail est une réduction stockée sous Key, de plus grand côté Size.
+type Thumbnail struct {
+	Size        int    `bson:"size" json:"size"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+	Key         string `bson:"key" json:"-"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Bytes       int64  `bson:"bytes" json:"bytes"`
+	URL         string `bson:"-" json:"url,omitempty"`
+}
+
+// AttachmentRef est la copie portée par le message.
+type AttachmentRef struct {
+	ID          string `bson:"id" json:"id"`
+	Filename    string `bson:"filename" json:"filename"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Size        int64  `bson:"size" json:"size"`
+	URL         string `bson:"-" json:"url,omitempty"`
+
+	// Aperçu des images, complété quand les vignettes sont prêtes
+	Width       int         `bson:"width,omitempty" json:"width,omitempty"`
+	Height      int         `bson:"height,omitempty" json:"height,omitempty"`
+	Placeholder string      `bson:"placeholder,omitempty" json:"placeholder,omitempty"`
+	Thumbnails  []Thumbnail `bson:"thumbnails,omitempty" json:"thumbnails,omitempty"`
+}
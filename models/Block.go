@@ -0,0 +1,12 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Block: BlockerID ne reçoit plus les messages de BlockedID, qui ne peut plus lui écrire en DM.
+type Block struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	BlockerID string             `bson:"blocker_id" json:"-"`
+	BlockedID string             `bson:"blocked_id" json:"user_id"`
+	Username  string             `bson:"username" json:"username"` // nom du bloqué au moment du blocage
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+}
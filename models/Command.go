@@ -0,0 +1,17 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Command est une commande slash externe: l'appel est transmis en POST signé
+// (HMAC-SHA256 de Secret) à URL, dont la réponse est renvoyée à l'appelant.
+type Command struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"` // sans le "/"
+	Room        string             `bson:"room" json:"room"` // vide: tous les salons
+	URL         string             `bson:"url" json:"url"`
+	Secret      string             `bson:"secret" json:"-"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Usage       string             `bson:"usage,omitempty" json:"usage,omitempty"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
+}
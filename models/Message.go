@@ -1,11 +1,85 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Message est un message de chat stocké.
+// sender/content/created_at sont les champs historiques; les suivants
+// ont été ajoutés pour les salons, la reprise et la déduplication.
 type Message struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Sender    string             `bson:"sender" json:"sender"`
-	Receiver  string             `bson:"receiver,omitempty" json:"receiver,omitempty"`
-	Content   string             `bson:"content" json:"content"`
-	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Sender       string             `bson:"sender" json:"sender"`
+	Receiver     string             `bson:"receiver,omitempty" json:"receiver,omitempty"`
+	Content      string             `bson:"content" json:"content"`
+	CreatedAt    primitive.DateTime `bson:"created_at" json:"created_at"`
+	UserID       string             `bson:"user_id" json:"user_id,omitempty"`             // vide si invité
+	Username     string             `bson:"username,omitempty" json:"username,omitempty"` // redondant mais pratique
+	Room         string             `bson:"room,omitempty" json:"room,omitempty"`
+	Seq          int64              `bson:"seq,omitempty" json:"seq,omitempty"` // ordre monotone dans le salon
+	CreatedAtISO time.Time          `bson:"created_at_iso,omitempty" json:"-"`  // lecture humaine si besoin
+	AuthorKey    string             `bson:"author_key,omitempty" json:"-"`      // déduplication
+	ClientMsgID  string             `bson:"client_msg_id,omitempty" json:"client_msg_id,omitempty"`
+
+	// Édition et suppression (tombstone: le document reste, contenu vidé)
+	EditedAt  *primitive.DateTime `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	Edits     []MessageEdit       `bson:"edits,omitempty" json:"edits,omitempty"`
+	Deleted   bool                `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	DeletedAt *primitive.DateTime `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy string              `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+
+	// Masqué après trop de signalements, en attente de revue (contenu conservé)
+	Hidden bool `bson:"hidden,omitempty" json:"hidden,omitempty"`
+
+	// Réactions: emoji -> compteur agrégé et ids des utilisateurs
+	Reactions map[string]Reaction `bson:"reactions,omitempty" json:"reactions,omitempty"`
+
+	// Citation et fils de discussion (compteurs tenus sur la racine)
+	ReplyTo     string              `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	Quote       *Quote              `bson:"quote,omitempty" json:"quote,omitempty"`
+	ThreadRoot  string              `bson:"thread_root,omitempty" json:"thread_root,omitempty"`
+	ReplyCount  int                 `bson:"reply_count,omitempty" json:"reply_count,omitempty"`
+	LastReplyAt *primitive.DateTime `bson:"last_reply_at,omitempty" json:"last_reply_at,omitempty"`
+
+	// Mentions résolues à l'envoi: ids des @username, et @room/@here
+	Mentions    []string `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	MentionRoom bool     `bson:"mention_room,omitempty" json:"mention_room,omitempty"`
+	MentionHere bool     `bson:"mention_here,omitempty" json:"mention_here,omitempty"`
+
+	// Épinglage (copie de Room.Pins pour l'affichage) et annonces des modérateurs
+	PinnedAt     *primitive.DateTime `bson:"pinned_at,omitempty" json:"pinned_at,omitempty"`
+	PinnedBy     string              `bson:"pinned_by,omitempty" json:"pinned_by,omitempty"`
+	Announcement bool                `bson:"announcement,omitempty" json:"announcement,omitempty"`
+	Persistent   bool                `bson:"persistent,omitempty" json:"persistent,omitempty"` // annonce remise à chaque abonnement au salon
+
+	// Pièces jointes, dénormalisées depuis la collection attachments à l'envoi
+	Attachments []AttachmentRef `bson:"attachments,omitempty" json:"attachments,omitempty"`
+
+	// Sondage: la question est aussi le contenu du message
+	Poll *Poll `bson:"poll,omitempty" json:"poll,omitempty"`
+
+	// Commande slash à l'origine du message ("me": action à la troisième personne)
+	Command string `bson:"command,omitempty" json:"command,omitempty"`
+}
+
+// Quote est l'aperçu du message cité, figé à l'envoi de la réponse; l'extrait
+// est vidé si le message cité est supprimé ou masqué.
+type Quote struct {
+	ID      string `bson:"id" json:"id"`
+	Sender  string `bson:"sender" json:"sender"`
+	Excerpt string `bson:"excerpt" json:"excerpt"`
+}
+
+type Reaction struct {
+	Count int      `bson:"count" json:"count"`
+	Users []string `bson:"users" json:"users"`
+}
+
+// MessageEdit conserve une version antérieure du contenu d'un message.
+type MessageEdit struct {
+	Content  string             `bson:"content" json:"content"`
+	EditedAt primitive.DateTime `bson:"edited_at" json:"edited_at"`
+	EditorID string             `bson:"editor_id" json:"editor_id"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ModerationAction trace une action de modération (kick, ban, mute, purge, ...).
+// Les bans et mutes sont actifs tant qu'ils ne sont ni révoqués ni expirés.
+type ModerationAction struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Type         string              `bson:"type" json:"type"`
+	Room         string              `bson:"room" json:"room"`
+	ActorID      string              `bson:"actor_id" json:"actor_id"`
+	ActorName    string              `bson:"actor_name" json:"actor_name"`
+	TargetUserID string              `bson:"target_user_id,omitempty" json:"target_user_id,omitempty"`
+	TargetName   string              `bson:"target_name,omitempty" json:"target_name,omitempty"`
+	TargetIP     string              `bson:"target_ip,omitempty" json:"target_ip,omitempty"`
+	Reason       string              `bson:"reason,omitempty" json:"reason,omitempty"`
+	ExpiresAt    *primitive.DateTime `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	Revoked      bool                `bson:"revoked,omitempty" json:"revoked,omitempty"`
+	Affected     int64               `bson:"affected,omitempty" json:"affected,omitempty"` // purge: messages supprimés
+	CreatedAt    primitive.DateTime  `bson:"created_at" json:"created_at"`
+}
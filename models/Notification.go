@@ -0,0 +1,26 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Notification est une entrée de la boîte de réception d'un utilisateur (mention, rappel).
+type Notification struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    string              `bson:"user_id" json:"user_id"`
+	Type      string              `bson:"type" json:"type"`                     // "mention" | "reminder"
+	Kind      string              `bson:"kind,omitempty" json:"kind,omitempty"` // "user" | "room" | "here"
+	MessageID string              `bson:"message_id" json:"message_id"`
+	Room      string              `bson:"room" json:"room"`
+	From      string              `bson:"from" json:"from"`
+	Excerpt   string              `bson:"excerpt" json:"excerpt"`
+	Note      string              `bson:"note,omitempty" json:"note,omitempty"` // rappel: note de l'utilisateur
+	CreatedAt primitive.DateTime  `bson:"created_at" json:"created_at"`
+	Read      bool                `bson:"read" json:"read"`
+	ReadAt    *primitive.DateTime `bson:"read_at,omitempty" json:"read_at,omitempty"`
+}
+
+// RoomSettings sont les préférences d'un utilisateur pour un salon.
+type RoomSettings struct {
+	UserID string `bson:"user_id" json:"user_id"`
+	Room   string `bson:"room" json:"room"`
+	Muted  bool   `bson:"muted" json:"muted"`
+}
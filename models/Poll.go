@@ -0,0 +1,34 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Poll est le sondage porté par un message (Message.Poll). Les compteurs sont
+// tenus sur le message; les votes eux-mêmes sont dans poll_votes.
+type Poll struct {
+	Question    string              `bson:"question" json:"question"`
+	Options     []PollOption        `bson:"options" json:"options"`
+	Multiple    bool                `bson:"multiple" json:"multiple"`         // plusieurs choix par votant
+	Anonymous   bool                `bson:"anonymous" json:"anonymous"`       // votants jamais exposés
+	HideResults bool                `bson:"hide_results" json:"hide_results"` // résultats masqués jusqu'à la clôture
+	Voters      int                 `bson:"voters" json:"voters"`
+	ClosesAt    *primitive.DateTime `bson:"closes_at,omitempty" json:"closes_at,omitempty"`
+	Closed      bool                `bson:"closed" json:"closed"`
+	ClosedAt    *primitive.DateTime `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+
+	ResultsHidden bool `bson:"-" json:"results_hidden,omitempty"` // compteurs retirés de la vue
+}
+
+type PollOption struct {
+	Text  string `bson:"text" json:"text"`
+	Votes int    `bson:"votes" json:"votes"`
+}
+
+// PollVote est le vote d'un utilisateur: un seul par sondage (index unique).
+type PollVote struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MessageID string             `bson:"message_id" json:"message_id"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	Username  string             `bson:"username" json:"username"`
+	Options   []int              `bson:"options" json:"options"`
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ReadState est la position de lecture d'un utilisateur dans un salon ou un DM.
+type ReadState struct {
+	UserID      string             `bson:"user_id" json:"user_id"`
+	Room        string             `bson:"room" json:"room"`
+	LastReadSeq int64              `bson:"last_read_seq" json:"last_read_seq"`
+	UpdatedAt   primitive.DateTime `bson:"updated_at" json:"updated_at"`
+}
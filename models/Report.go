@@ -0,0 +1,26 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Report est le signalement d'un message ou d'un utilisateur.
+// Status: "open", puis "actioned" (mesure prise) ou "dismissed" (sans suite).
+type Report struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ReporterID   string              `bson:"reporter_id" json:"reporter_id"`
+	ReporterName string              `bson:"reporter_name" json:"reporter_name"`
+	TargetType   string              `bson:"target_type" json:"target_type"` // "message" | "user"
+	TargetKey    string              `bson:"target_key" json:"-"`            // "<type>:<id>", déduplication par auteur
+	MessageID    string              `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	TargetUserID string              `bson:"target_user_id,omitempty" json:"target_user_id,omitempty"`
+	TargetName   string              `bson:"target_name,omitempty" json:"target_name,omitempty"`
+	Room         string              `bson:"room,omitempty" json:"room,omitempty"` // salon du message signalé
+	Excerpt      string              `bson:"excerpt,omitempty" json:"excerpt,omitempty"`
+	Category     string              `bson:"category" json:"category"`
+	Comment      string              `bson:"comment,omitempty" json:"comment,omitempty"`
+	Status       string              `bson:"status" json:"status"`
+	AssigneeID   string              `bson:"assignee_id,omitempty" json:"assignee_id,omitempty"`
+	ResolvedBy   string              `bson:"resolved_by,omitempty" json:"resolved_by,omitempty"`
+	ResolvedAt   *primitive.DateTime `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+	Resolution   string              `bson:"resolution,omitempty" json:"resolution,omitempty"`
+	CreatedAt    primitive.DateTime  `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ReviewItem est un message signalé par la chaîne de filtres, en attente de revue.
+// Status: "pending", puis "approved" (message conservé) ou "removed" (message supprimé).
+type ReviewItem struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	MessageID  string              `bson:"message_id" json:"message_id"`
+	Room       string              `bson:"room" json:"room"`
+	UserID     string              `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Username   string              `bson:"username" json:"username"`
+	Text       string              `bson:"text" json:"text"`
+	Reasons    []string            `bson:"reasons" json:"reasons"` // "<filtre>: <motif>"
+	Edit       bool                `bson:"edit,omitempty" json:"edit,omitempty"`
+	Status     string              `bson:"status" json:"status"`
+	ReviewedBy string              `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewedAt *primitive.DateTime `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	Note       string              `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt  primitive.DateTime  `bson:"created_at" json:"created_at"`
+}
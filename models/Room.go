@@ -0,0 +1,26 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Room décrit un salon. Les salons sans document sont publics;
+// les conversations privées (DM) n'ont pas de document: leur nom "dm:<id>:<id>" suffit.
+type Room struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name            string             `bson:"name" json:"name"`
+	Private         bool               `bson:"private" json:"private"`
+	OwnerID         string             `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	Members         []string           `bson:"members,omitempty" json:"members,omitempty"`                     // ids utilisateurs (salons privés)
+	Moderators      []string           `bson:"moderators,omitempty" json:"moderators,omitempty"`               // ids des modérateurs du salon
+	SlowModeSeconds int                `bson:"slow_mode_seconds,omitempty" json:"slow_mode_seconds,omitempty"` // intervalle minimal entre deux messages d'un utilisateur
+	GuestAccess     string             `bson:"guest_access,omitempty" json:"guest_access,omitempty"`           // "none", "read", "write" ou vide (mode global)
+	Pins            []Pin              `bson:"pins,omitempty" json:"pins,omitempty"`                           // messages épinglés, dans l'ordre d'épinglage
+	Topic           string             `bson:"topic,omitempty" json:"topic,omitempty"`                         // sujet du salon (/topic)
+	CreatedAt       primitive.DateTime `bson:"created_at" json:"created_at"`
+}
+
+// Pin: message épinglé par un modérateur du salon.
+type Pin struct {
+	MessageID string             `bson:"message_id" json:"message_id"`
+	PinnedBy  string             `bson:"pinned_by" json:"pinned_by"`
+	PinnedAt  primitive.DateTime `bson:"pinned_at" json:"pinned_at"`
+}
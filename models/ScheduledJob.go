@@ -0,0 +1,27 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ScheduledJob est un envoi différé ("message") ou un rappel personnel ("reminder").
+// Un message programmé est posté dans Room au nom de OwnerID; un rappel notifie
+// OwnerID à propos de MessageID.
+type ScheduledJob struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Kind       string              `bson:"kind" json:"kind"` // "message" | "reminder"
+	OwnerID    string              `bson:"owner_id" json:"owner_id"`
+	Room       string              `bson:"room" json:"room"`
+	Text       string              `bson:"text,omitempty" json:"text,omitempty"`
+	ReplyTo    string              `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	ThreadRoot string              `bson:"thread_root,omitempty" json:"thread_root,omitempty"`
+	MessageID  string              `bson:"message_id,omitempty" json:"message_id,omitempty"` // rappel: message visé
+	Note       string              `bson:"note,omitempty" json:"note,omitempty"`
+	DueAt      primitive.DateTime  `bson:"due_at" json:"due_at"`
+	Status     string              `bson:"status" json:"status"` // pending, firing, done, cancelled, failed
+	Attempts   int                 `bson:"attempts,omitempty" json:"-"`
+	LeaseUntil *primitive.DateTime `bson:"lease_until,omitempty" json:"-"`
+	ResultID   string              `bson:"result_id,omitempty" json:"result_id,omitempty"` // message posté
+	Error      string              `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt  primitive.DateTime  `bson:"created_at" json:"created_at"`
+	UpdatedAt  *primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	FiredAt    *primitive.DateTime `bson:"fired_at,omitempty" json:"fired_at,omitempty"`
+}
@@ -3,9 +3,11 @@ package models
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type User struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Username string             `bson:"username" json:"username"`
-	Email    string             `bson:"email,omitempty" json:"email,omitempty"`
-	Password string             `bson:"password" json:"-"`
-	Avatar   string             `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username      string             `bson:"username" json:"username"`
+	Email         string             `bson:"email,omitempty" json:"email,omitempty"`
+	Password      string             `bson:"password" json:"-"`
+	Avatar        string             `bson:"avatar,omitempty" json:"-"` // clé du blob téléversé (voir auth.AvatarURL)
+	AvatarVersion int64              `bson:"avatar_version,omitempty" json:"-"`
+	Role          string             `bson:"role,omitempty" json:"role,omitempty"` // "" | "moderator" | "admin"
 }
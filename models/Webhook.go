@@ -0,0 +1,42 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Webhook est un abonnement sortant aux évènements d'un salon ("message", "join", "leave").
+// Chaque envoi est signé avec Secret (HMAC-SHA256), qui n'est montré qu'à la création.
+type Webhook struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Room      string              `bson:"room" json:"room"`
+	URL       string              `bson:"url" json:"url"`
+	Secret    string              `bson:"secret" json:"-"`
+	Events    []string            `bson:"events" json:"events"`
+	Active    bool                `bson:"active" json:"active"`
+	CreatedBy string              `bson:"created_by" json:"created_by"`
+	CreatedAt primitive.DateTime  `bson:"created_at" json:"created_at"`
+	UpdatedAt *primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// WebhookDelivery est un envoi et son journal de tentatives. Status: pending,
+// retrying, delivered, ou dead (abandonné après le dernier essai: lettre morte).
+type WebhookDelivery struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	WebhookID     string              `bson:"webhook_id" json:"webhook_id"`
+	Room          string              `bson:"room" json:"room"`
+	Event         string              `bson:"event" json:"event"`
+	Payload       string              `bson:"payload" json:"payload"` // corps JSON envoyé, identique à chaque essai
+	Status        string              `bson:"status" json:"status"`
+	Attempts      int                 `bson:"attempts" json:"attempts"`
+	NextAttemptAt primitive.DateTime  `bson:"next_attempt_at" json:"next_attempt_at"`
+	LeaseUntil    *primitive.DateTime `bson:"lease_until,omitempty" json:"-"`
+	Log           []DeliveryAttempt   `bson:"log,omitempty" json:"log,omitempty"`
+	CreatedAt     primitive.DateTime  `bson:"created_at" json:"created_at"`
+	DeliveredAt   *primitive.DateTime `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}
+
+// DeliveryAttempt est une tentative d'envoi: code HTTP reçu ou erreur réseau.
+type DeliveryAttempt struct {
+	At         primitive.DateTime `bson:"at" json:"at"`
+	StatusCode int                `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string             `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64              `bson:"duration_ms" json:"duration_ms"`
+}